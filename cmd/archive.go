@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/archive"
+)
+
+// pruneInterval is how often events past ARCHIVE_RETENTION_DAYS are deleted
+const pruneInterval = time.Hour
+
+/*
+pruneArchive deletes the events older than days from the archive, at once
+and then every pruneInterval until done is closed
+*/
+func pruneArchive(store *archive.Archive, days int, logger internal.DebugLogger, done <-chan struct{}) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		before := time.Now().AddDate(0, 0, -days)
+		if n, err := store.Prune(before); err != nil {
+			logger.LogError("Could not prune the archive: %s", err)
+		} else if n > 0 {
+			logger.LogInfo("Pruned %d archived events older than %d days", n, days)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+const archiveUsage = `usage: teleirc archive search [flags] WORDS...
+       teleirc archive export [flags] [WORDS...]
+
+search prints the archived events whose text or sender contains all the
+words, export writes them as JSON lines by default. Flags:
+`
+
+/*
+runArchive runs the archive subcommand with its arguments, and returns the
+exit code
+*/
+func runArchive(args []string, out, errOut io.Writer) int {
+	if len(args) == 0 || (args[0] != "search" && args[0] != "export") {
+		fmt.Fprint(errOut, archiveUsage)
+		return 2
+	}
+	command := args[0]
+	flags := flag.NewFlagSet("archive "+command, flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	flags.Usage = func() {
+		fmt.Fprint(errOut, archiveUsage)
+		flags.PrintDefaults()
+	}
+	path := flags.String("conf", *flagPath, "config file (.env, .yaml or .toml) with ARCHIVE_PATH")
+	db := flags.String("db", "", "archive database, instead of ARCHIVE_PATH")
+	since := flags.String("since", "", "only events since a time ago (7d, 12h) or a date (2006-01-02)")
+	until := flags.String("until", "", "only events before a time ago (7d, 12h) or a date (2006-01-02)")
+	side := flags.String("side", "", "only events from irc or telegram")
+	limit := flags.Int("limit", 0, "only the newest events, 0 for all")
+	format := flags.String("format", "", "output format: text, json or csv (default text for search, json for export)")
+
+	// Flags may come before or after the words
+	var words []string
+	rest := args[1:]
+	for {
+		if err := flags.Parse(rest); err != nil {
+			return 2
+		}
+		rest = flags.Args()
+		if len(rest) == 0 {
+			break
+		}
+		words = append(words, rest[0])
+		rest = rest[1:]
+	}
+	if command == "search" && len(words) == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *format == "" {
+		*format = "text"
+		if command == "export" {
+			*format = "json"
+		}
+	}
+
+	query := archive.Query{Text: strings.Join(words, " "), Side: *side, Limit: *limit}
+	var err error
+	now := time.Now()
+	if query.Since, err = parseSince(*since, now); err != nil {
+		fmt.Fprintf(errOut, "invalid -since: %s\n", err)
+		return 2
+	}
+	if query.Until, err = parseSince(*until, now); err != nil {
+		fmt.Fprintf(errOut, "invalid -until: %s\n", err)
+		return 2
+	}
+	if query.Side != "" && query.Side != archive.IRC && query.Side != archive.Telegram {
+		fmt.Fprintf(errOut, "invalid -side %q, use irc or telegram\n", query.Side)
+		return 2
+	}
+
+	if *db == "" {
+		settings, err := internal.LoadConfig(*path)
+		if err != nil {
+			fmt.Fprintln(errOut, err)
+			return 1
+		}
+		if settings.ArchivePath == "" {
+			fmt.Fprintf(errOut, "ARCHIVE_PATH is not set in %s, use -db\n", *path)
+			return 1
+		}
+		*db = settings.ArchivePath
+	}
+	store, err := archive.Open(*db)
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return 1
+	}
+	defer store.Close()
+	events, err := store.Search(query)
+	if err != nil {
+		fmt.Fprintf(errOut, "could not search the archive: %s\n", err)
+		return 1
+	}
+	if err := writeEvents(out, events, *format); err != nil {
+		fmt.Fprintln(errOut, err)
+		return 1
+	}
+	return 0
+}
+
+/*
+parseSince parses a point in time for -since and -until: a duration before
+now, with d for days, or a date or RFC 3339 time. Empty is no limit.
+*/
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a duration such as 7d or 12h, nor a date", s)
+}
+
+/*
+writeEvents writes events to out in format: text lines like a chat log,
+JSON lines or CSV
+*/
+func writeEvents(out io.Writer, events []archive.Event, format string) error {
+	switch format {
+	case "text":
+		for _, e := range events {
+			fmt.Fprintf(out, "%s [%s %s] %s %s: %s\n", e.Time.Format("2006-01-02 15:04:05"),
+				e.Side, e.Chat, e.Kind, e.SenderName, e.Text)
+		}
+		return nil
+	case "json":
+		encoder := json.NewEncoder(out)
+		for _, e := range events {
+			if err := encoder.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{"id", "time", "side", "chat", "kind", "sender_id", "sender_name", "text",
+			"message_id", "reply_to", "edit_of", "media"})
+		for _, e := range events {
+			w.Write([]string{strconv.FormatInt(e.ID, 10), e.Time.Format(time.RFC3339Nano), e.Side, e.Chat,
+				e.Kind, e.SenderID, e.SenderName, e.Text, e.MessageID, e.ReplyTo, e.EditOf, e.Media})
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		return errors.New("unknown format " + strconv.Quote(format) + ", use text, json or csv")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/archive"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/ritlug/teleirc/internal/testing/faketelegram"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	cases := []struct {
+		in   string
+		want time.Time
+	}{
+		{"", time.Time{}},
+		{"7d", now.AddDate(0, 0, -7)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)},
+		{"2024-05-01T10:00:00Z", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		got, err := parseSince(c.in, now)
+		if assert.NoError(t, err, c.in) {
+			assert.True(t, c.want.Equal(got), "%s: got %s", c.in, got)
+		}
+	}
+	for _, in := range []string{"d", "-1d", "yesterday", "-5m"} {
+		_, err := parseSince(in, now)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestRunArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.db")
+	store, err := archive.Open(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	now := time.Now()
+	for _, e := range []archive.Event{
+		{Time: now.AddDate(0, 0, -10), Side: archive.IRC, Chat: "#bridge", Kind: "text", SenderName: "alice", Text: "old deploy"},
+		{Time: now.Add(-time.Hour), Side: archive.IRC, Chat: "#bridge", Kind: "text", SenderName: "alice", Text: "deploy is done"},
+		{Time: now.Add(-time.Minute), Side: archive.Telegram, Chat: "-100", Kind: "text", SenderName: "batman", Text: "thanks for the deploy"},
+		{Time: now, Side: archive.Telegram, Chat: "-100", Kind: "text", SenderName: "batman", Text: "bye"},
+	} {
+		assert.NoError(t, store.Add(e))
+	}
+	store.Close()
+
+	var out, errOut bytes.Buffer
+	assert.Equal(t, 0, runArchive([]string{"search", "deploy", "-db", path, "--since", "7d"}, &out, &errOut), errOut.String())
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Contains(t, lines[0], "[irc #bridge] text alice: deploy is done")
+		assert.Contains(t, lines[1], "[telegram -100] text batman: thanks for the deploy")
+	}
+
+	out.Reset()
+	assert.Equal(t, 0, runArchive([]string{"export", "-db", path, "-side", "telegram"}, &out, &errOut), errOut.String())
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		var e archive.Event
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &e))
+		assert.Equal(t, "bye", e.Text)
+		assert.Equal(t, "batman", e.SenderName)
+	}
+
+	out.Reset()
+	assert.Equal(t, 0, runArchive([]string{"export", "-db", path, "-format", "csv", "-limit", "1"}, &out, &errOut), errOut.String())
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+	assert.True(t, strings.HasPrefix(out.String(), "id,time,side,"))
+
+	errOut.Reset()
+	assert.Equal(t, 2, runArchive([]string{"search", "-db", path}, &out, &errOut))
+	assert.Contains(t, errOut.String(), "usage:")
+	assert.Equal(t, 2, runArchive([]string{"search", "x", "-db", path, "-side", "matrix"}, &out, &errOut))
+	assert.Equal(t, 2, runArchive([]string{"delete"}, &out, &errOut))
+}
+
+func TestBridgeArchive(t *testing.T) {
+	ircServer, err := fakeirc.New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ircServer.Close()
+	tgServer := faketelegram.New(testToken)
+	defer tgServer.Close()
+
+	settings := newTestSettings(ircServer, tgServer)
+	settings.ArchivePath = filepath.Join(t.TempDir(), "archive.db")
+	settings.ArchiveRetentionDays = 30
+	signals := make(chan os.Signal, 1)
+	done := make(chan bool, 1)
+	go func() {
+		done <- runBridge(settings, internal.Debug{}, signals, nil)
+	}()
+
+	_, joined := ircServer.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	if !assert.True(t, joined, "bridge should join the IRC channel") {
+		return
+	}
+	ircServer.Join("alice", "#bridge")
+	ircServer.Say("alice", "#bridge", "hello from IRC")
+	tgServer.WaitForCalls("sendMessage", 1, 5*time.Second)
+	tgServer.AddMessage(-100, &models.User{ID: 2, FirstName: "Bruce", Username: "batman"}, "hello from Telegram")
+	_, relayed := ircServer.WaitForCommand(girc.PRIVMSG, "#bridge", 5*time.Second)
+	assert.True(t, relayed, "Telegram message should reach IRC")
+
+	signals <- syscall.SIGTERM
+	select {
+	case exitError := <-done:
+		assert.False(t, exitError, "bridge should stop cleanly")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "bridge did not stop")
+		return
+	}
+
+	store, err := archive.Open(settings.ArchivePath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer store.Close()
+	// Telegram dates are in seconds, so the order of the sides is not known
+	events, err := store.Search(archive.Query{Text: "hello", Side: archive.IRC})
+	if assert.NoError(t, err) && assert.Len(t, events, 1) {
+		assert.Equal(t, "#bridge", events[0].Chat)
+		assert.Equal(t, "alice", events[0].SenderName)
+		assert.Equal(t, "<alice> hello from IRC", events[0].Text)
+	}
+	events, err = store.Search(archive.Query{Text: "hello", Side: archive.Telegram})
+	if assert.NoError(t, err) && assert.Len(t, events, 1) {
+		assert.Equal(t, "-100", events[0].Chat)
+		assert.Equal(t, "batman", events[0].SenderName)
+		assert.Equal(t, "<batman> hello from Telegram", events[0].Text)
+	}
+}
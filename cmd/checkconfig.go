@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/handlers/irc"
+	tg "github.com/ritlug/teleirc/internal/handlers/telegram"
+)
+
+// probeTimeout limits how long each probe may take
+const probeTimeout = 30 * time.Second
+
+// Bot tokens are the bot's user ID and a 35 character secret
+var tokenRegex = regexp.MustCompile(`^[0-9]+:[A-Za-z0-9_-]{35}$`)
+
+/*
+checkResult is the outcome of one check of the config
+*/
+type checkResult struct {
+	name string
+	// err is why the check failed, if it did
+	err error
+	// warning marks a failure that does not stop the bot from running
+	warning bool
+}
+
+/*
+probeLogger only logs in debug mode, so the probes do not clutter the report
+*/
+type probeLogger struct {
+	internal.DebugLogger
+}
+
+func (l probeLogger) LogInfo(f string, v ...any)    { l.LogDebug(f, v...) }
+func (l probeLogger) LogError(f string, v ...any)   { l.LogDebug(f, v...) }
+func (l probeLogger) LogWarning(f string, v ...any) { l.LogDebug(f, v...) }
+
+func (l probeLogger) With(args ...any) internal.DebugLogger {
+	return probeLogger{l.DebugLogger.With(args...)}
+}
+
+/*
+runCheckConfig runs the check-config subcommand with its arguments, and
+returns the exit code: 0 if every check passed, 1 otherwise
+*/
+func runCheckConfig(args []string, logger internal.Debug, out io.Writer) int {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	path := flags.String("conf", *flagPath, "config file (.env, .yaml or .toml)")
+	probe := flags.Bool("probe", false, "also connect to IRC and Telegram")
+	flags.Parse(args)
+
+	fmt.Fprintf(out, "Checking %s\n", *path)
+	settings, err := internal.LoadConfig(*path)
+	if err != nil {
+		var configErrs internal.ConfigErrors
+		if errors.As(err, &configErrs) {
+			for _, configErr := range configErrs {
+				printResult(out, checkResult{name: "config is valid", err: configErr})
+			}
+		} else {
+			printResult(out, checkResult{name: "config is valid", err: err})
+		}
+		fmt.Fprintln(out, "FAILED")
+		return 1
+	}
+	results := []checkResult{{name: "config is valid"}}
+	results = append(results, checkSettings(settings)...)
+	if *probe {
+		results = append(results, probeIRC(settings, probeLogger{logger})...)
+		results = append(results, probeTelegram(settings, probeLogger{logger})...)
+	}
+
+	failed := 0
+	for _, result := range results {
+		printResult(out, result)
+		if result.err != nil && !result.warning {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(out, "FAILED: %d of %d checks\n", failed, len(results))
+		return 1
+	}
+	fmt.Fprintln(out, "OK")
+	return 0
+}
+
+func printResult(out io.Writer, result checkResult) {
+	switch {
+	case result.err == nil:
+		fmt.Fprintf(out, "  PASS  %s\n", result.name)
+	case result.warning:
+		fmt.Fprintf(out, "  WARN  %s: %s\n", result.name, result.err)
+	default:
+		fmt.Fprintf(out, "  FAIL  %s: %s\n", result.name, result.err)
+	}
+}
+
+/*
+checkSettings checks what LoadConfig does not: settings that are valid on
+their own but cannot work
+*/
+func checkSettings(settings *internal.Settings) []checkResult {
+	results := []checkResult{
+		{name: "IRC_CHANNEL is a channel name", err: checkChannel(settings.IRC.Channel)},
+		{name: "TELEIRC_TOKEN looks like a bot token", err: checkToken(settings.Telegram.Token)},
+		{name: "TELEGRAM_CHAT_ID is a group", err: checkChatID(settings.Telegram.ChatID)},
+	}
+	if id := settings.Telegram.ChatID; id < 0 && !strings.HasPrefix(strconv.FormatInt(id, 10), "-100") {
+		results = append(results, checkResult{
+			name:    "TELEGRAM_CHAT_ID is a supergroup",
+			err:     errors.New("basic groups get a new ID when Telegram upgrades them, set TELEGRAM_STATE_FILE to follow it"),
+			warning: true,
+		})
+	}
+	if settings.Telegram.ChannelID != 0 {
+		results = append(results, checkResult{
+			name: "TELEGRAM_CHANNEL_ID is a channel",
+			err:  checkChatID(settings.Telegram.ChannelID),
+		})
+	}
+
+	files := []struct{ name, path string }{
+		{"IRC_CA_FILE", settings.IRC.TLSCAFile},
+		{"IRC_CLIENT_CERT", settings.IRC.TLSClientCert},
+		{"IRC_CLIENT_KEY", settings.IRC.TLSClientKey},
+		{"TELEGRAM_WEBHOOK_TLS_CERT", settings.Telegram.WebhookTLSCert},
+		{"TELEGRAM_WEBHOOK_TLS_KEY", settings.Telegram.WebhookTLSKey},
+	}
+	for _, file := range files {
+		if file.path != "" {
+			results = append(results, checkResult{name: file.name + " is readable", err: checkReadable(file.path)})
+		}
+	}
+	dirs := []struct{ name, path string }{
+		{"IRC_STS_FILE", settings.IRC.STSFile},
+		{"TELEGRAM_STATE_FILE", settings.Telegram.StateFile},
+	}
+	for _, dir := range dirs {
+		if dir.path != "" {
+			results = append(results, checkResult{
+				name: dir.name + " is in an existing directory",
+				err:  checkDir(filepath.Dir(dir.path)),
+			})
+		}
+	}
+	return results
+}
+
+/*
+checkChannel returns why name is not a valid IRC channel name, if it is not
+*/
+func checkChannel(name string) error {
+	if name == "" || !strings.ContainsAny(name[:1], "#&+!") {
+		return fmt.Errorf("%q does not start with #, &, + or !; in a .env file, quote it like IRC_CHANNEL=\"#channel\"", name)
+	}
+	if len(name) < 2 {
+		return errors.New("the channel name is empty")
+	}
+	if strings.ContainsAny(name, " ,\a") {
+		return fmt.Errorf("%q contains a space, comma or control character", name)
+	}
+	return nil
+}
+
+func checkToken(token string) error {
+	if !tokenRegex.MatchString(token) {
+		return errors.New("bot tokens look like 123456789:AAAAAAaAAa2AaAAaoAAAA-a_aaAAaAaaaAA, ask @BotFather for yours")
+	}
+	return nil
+}
+
+/*
+checkChatID returns an error unless id is a group or channel, which have
+negative IDs. Positive IDs belong to users.
+*/
+func checkChatID(id int64) error {
+	if id >= 0 {
+		return fmt.Errorf("%d is not negative, group and channel IDs look like -1001234567890", id)
+	}
+	return nil
+}
+
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func checkDir(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return nil
+}
+
+/*
+probeIRC connects to the first IRC server with the configured settings
+*/
+func probeIRC(settings *internal.Settings, logger internal.DebugLogger) []checkResult {
+	client := irc.NewClient(&settings.IRC, &settings.Telegram, logger)
+	result, err := client.Probe(probeTimeout)
+	name := "IRC server accepts the bot"
+	if result != nil {
+		name = fmt.Sprintf("IRC server %s accepts the bot", result.Server)
+	}
+
+	var authErr *irc.AuthError
+	if errors.As(err, &authErr) {
+		return []checkResult{{name: name}, {name: "IRC SASL authentication", err: err}}
+	}
+	results := []checkResult{{name: name, err: err}}
+	if err == nil && client.Config.SASL != nil {
+		var authFailed error
+		if !result.Authenticated {
+			authFailed = errors.New("the server did not offer SASL")
+			if settings.IRC.NickServUser != "" && settings.IRC.NickServPassword != "" {
+				authFailed = errors.New("the server did not offer SASL, NickServ is used instead")
+			}
+		}
+		results = append(results, checkResult{name: "IRC SASL authentication", err: authFailed, warning: true})
+	}
+	return results
+}
+
+/*
+probeTelegram checks the token and the chat with the Bot API
+*/
+func probeTelegram(settings *internal.Settings, logger internal.DebugLogger) []checkResult {
+	client := tg.NewClient(&settings.Telegram, &settings.IRC, &settings.Imgur, logger)
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	result, err := client.Probe(ctx)
+
+	tokenCheck := checkResult{name: "Telegram accepts TELEIRC_TOKEN"}
+	if result == nil || result.Bot == nil {
+		tokenCheck.err = err
+		return []checkResult{tokenCheck}
+	}
+	tokenCheck.name += fmt.Sprintf(" (@%s)", result.Bot.Username)
+	chatCheck := checkResult{name: fmt.Sprintf("bot is in Telegram chat %d", settings.Telegram.ChatID)}
+	if result.Chat != nil && result.Chat.Title != "" {
+		chatCheck.name += fmt.Sprintf(" (%s)", result.Chat.Title)
+	}
+	if err != nil {
+		chatCheck.err = fmt.Errorf("%w; is the bot in the group, and is TELEGRAM_CHAT_ID right?", err)
+		return []checkResult{tokenCheck, chatCheck}
+	}
+	if result.Status == models.ChatMemberTypeLeft || result.Status == models.ChatMemberTypeBanned {
+		chatCheck.err = fmt.Errorf("the bot's status in the chat is %q, add it to the group", result.Status)
+		return []checkResult{tokenCheck, chatCheck}
+	}
+
+	privacyCheck := checkResult{name: "bot can read all messages"}
+	if !result.CanReadMessages() {
+		privacyCheck.err = errors.New("privacy mode is on, disable it with /setprivacy in @BotFather " +
+			"and add the bot to the group again, or make it an administrator")
+	}
+	return []checkResult{tokenCheck, chatCheck, privacyCheck}
+}
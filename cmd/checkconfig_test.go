@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/ritlug/teleirc/internal/testing/faketelegram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+writeEnvFile writes a .env file with the given settings. LoadConfig sets
+them in the environment, so they are unset again when the test ends.
+*/
+func writeEnvFile(t *testing.T, settings map[string]string) string {
+	t.Helper()
+	var content strings.Builder
+	for key, value := range settings {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+		fmt.Fprintf(&content, "%s=%q\n", key, value)
+	}
+	path := filepath.Join(t.TempDir(), "teleirc.env")
+	require.NoError(t, os.WriteFile(path, []byte(content.String()), 0600))
+	return path
+}
+
+func TestCheckChannel(t *testing.T) {
+	assert.NoError(t, checkChannel("#bridge"))
+	assert.NoError(t, checkChannel("&local"))
+	for _, name := range []string{"", "bridge", "#", "#my channel", "#a,#b"} {
+		assert.Error(t, checkChannel(name), name)
+	}
+}
+
+func TestCheckSettings(t *testing.T) {
+	settings := &internal.Settings{
+		IRC:      internal.IRCSettings{Channel: "#bridge"},
+		Telegram: internal.TelegramSettings{Token: testToken, ChatID: -1001234567890},
+	}
+	for _, result := range checkSettings(settings) {
+		assert.NoError(t, result.err, result.name)
+	}
+
+	settings.Telegram.Token = "not a token"
+	settings.Telegram.ChatID = 1234
+	settings.IRC.TLSCAFile = filepath.Join(t.TempDir(), "missing.pem")
+	failed := map[string]bool{}
+	for _, result := range checkSettings(settings) {
+		failed[result.name] = result.err != nil
+	}
+	assert.Equal(t, map[string]bool{
+		"IRC_CHANNEL is a channel name":        false,
+		"TELEIRC_TOKEN looks like a bot token": true,
+		"TELEGRAM_CHAT_ID is a group":          true,
+		"IRC_CA_FILE is readable":              true,
+	}, failed)
+}
+
+func TestCheckConfigProbe(t *testing.T) {
+	ircServer, err := fakeirc.New()
+	require.NoError(t, err)
+	defer ircServer.Close()
+	tgServer := faketelegram.New(testToken)
+	defer tgServer.Close()
+
+	path := writeEnvFile(t, map[string]string{
+		"IRC_SERVER":       ircServer.Host,
+		"IRC_PORT":         fmt.Sprint(ircServer.Port),
+		"IRC_CHANNEL":      "#bridge",
+		"IRC_BOT_NAME":     "teleirc",
+		"TELEIRC_TOKEN":    testToken,
+		"TELEGRAM_CHAT_ID": "-1001234567890",
+		"TELEGRAM_API_URL": tgServer.URL,
+	})
+
+	// The bot is not in the chat yet
+	var out bytes.Buffer
+	assert.Equal(t, 1, runCheckConfig([]string{"-conf", path, "-probe"}, internal.Debug{}, &out))
+	assert.Contains(t, out.String(), "PASS  IRC server "+ircServer.Addr())
+	assert.Contains(t, out.String(), "PASS  Telegram accepts TELEIRC_TOKEN (@teleirc_bot)")
+	assert.Contains(t, out.String(), "FAIL  bot is in Telegram chat -1001234567890")
+
+	// In privacy mode, the bot only sees commands
+	tgServer.AddChat(models.ChatFullInfo{ID: -1001234567890, Type: models.ChatTypeSupergroup, Title: "Bridge"},
+		models.ChatMemberTypeMember)
+	out.Reset()
+	assert.Equal(t, 1, runCheckConfig([]string{"-conf", path, "-probe"}, internal.Debug{}, &out))
+	assert.Contains(t, out.String(), "PASS  bot is in Telegram chat -1001234567890 (Bridge)")
+	assert.Contains(t, out.String(), "FAIL  bot can read all messages: privacy mode is on")
+
+	tgServer.Me.CanReadAllGroupMessages = true
+	out.Reset()
+	assert.Equal(t, 0, runCheckConfig([]string{"-conf", path, "-probe"}, internal.Debug{}, &out), out.String())
+	assert.True(t, strings.HasSuffix(out.String(), "OK\n"))
+}
+
+func TestCheckConfigInvalid(t *testing.T) {
+	path := writeEnvFile(t, map[string]string{
+		"IRC_SERVER":       "irc.example.com",
+		"IRC_PORT":         "70000",
+		"IRC_CHANNEL":      "#bridge",
+		"IRC_BOT_NAME":     "teleirc",
+		"TELEIRC_TOKEN":    testToken,
+		"TELEGRAM_CHAT_ID": "-100",
+	})
+	var out bytes.Buffer
+	assert.Equal(t, 1, runCheckConfig([]string{"-conf", path}, internal.Debug{}, &out))
+	assert.Contains(t, out.String(), "FAIL  config is valid: Field Settings.IRC.Port failed to validate: 65535 too large.")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/metrics"
+)
+
+/*
+statusReporter is a side of the bridge that reports its state
+*/
+type statusReporter interface {
+	Status() internal.SideStatus
+}
+
+/*
+readiness is the body of a /readyz response
+*/
+type readiness struct {
+	Ready    bool                `json:"ready"`
+	IRC      internal.SideStatus `json:"irc"`
+	Telegram internal.SideStatus `json:"telegram"`
+}
+
+/*
+httpServer serves the metrics and the health of the bridge
+*/
+type httpServer struct {
+	*http.Server
+	// addr is the address the server listens on
+	addr net.Addr
+}
+
+/*
+startHTTPServer serves the metrics at /metrics, whether the process runs
+at /healthz, and whether both sides are connected at /readyz, on listen,
+until the server is closed
+*/
+func startHTTPServer(listen string, ircSide, tgSide statusReporter, logger internal.DebugLogger) (*httpServer, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		body := readiness{IRC: ircSide.Status(), Telegram: tgSide.Status()}
+		body.Ready = body.IRC.Ready && body.Telegram.Ready
+		code := http.StatusOK
+		if !body.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, body)
+	})
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	server := &httpServer{
+		Server: &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		addr:   l.Addr(),
+	}
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.LogError("HTTP server: %s", err)
+		}
+	}()
+	logger.LogInfo("Serving metrics and health checks on http://%s", server.addr)
+	return server, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
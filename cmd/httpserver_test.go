@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/ritlug/teleirc/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+fakeSide is a side of the bridge with a fixed status
+*/
+type fakeSide internal.SideStatus
+
+func (s fakeSide) Status() internal.SideStatus {
+	return internal.SideStatus(s)
+}
+
+var readySide = fakeSide{Ready: true}
+
+func get(t *testing.T, server *httpServer, path string) (int, string) {
+	t.Helper()
+	resp, err := http.Get("http://" + server.addr.String() + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestHTTPServerMetrics(t *testing.T) {
+	server, err := startHTTPServer("127.0.0.1:0", readySide, readySide, internal.Debug{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	code, body := get(t, server, "/metrics")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "# TYPE teleirc_messages_relayed_total counter")
+	assert.Contains(t, body, "# TYPE teleirc_telegram_poll_duration_seconds histogram")
+}
+
+func TestHTTPServerHealthz(t *testing.T) {
+	server, err := startHTTPServer("127.0.0.1:0", fakeSide{}, fakeSide{}, internal.Debug{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	// The process is healthy even when neither side is connected
+	code, body := get(t, server, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"status":"ok"}`, body)
+}
+
+func TestHTTPServerReadyz(t *testing.T) {
+	server, err := startHTTPServer("127.0.0.1:0", readySide, readySide, internal.Debug{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	code, body := get(t, server, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"ready":true,"irc":{"ready":true},"telegram":{"ready":true}}`, body)
+}
+
+func TestHTTPServerNotReady(t *testing.T) {
+	ircSide := fakeSide{Reason: "not in #bridge", LastError: "connection reset"}
+	server, err := startHTTPServer("127.0.0.1:0", ircSide, readySide, internal.Debug{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	code, body := get(t, server, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	var got readiness
+	require.NoError(t, json.Unmarshal([]byte(body), &got))
+	assert.False(t, got.Ready)
+	assert.Equal(t, internal.SideStatus(ircSide), got.IRC)
+	assert.True(t, got.Telegram.Ready)
+}
+
+func TestHTTPServerInvalidAddress(t *testing.T) {
+	_, err := startHTTPServer("not an address", readySide, readySide, internal.Debug{})
+	assert.Error(t, err)
+}
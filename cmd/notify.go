@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/systemd"
+)
+
+// notifyInterval is how often the state of the bridge is checked for systemd
+const notifyInterval = time.Second
+
+/*
+notifySystemd keeps systemd up to date until done is closed: it sends
+READY=1 once both sides are ready, STATUS= whenever the state changes, and
+WATCHDOG=1 while both sides are ready. A side is only ready while its
+connection makes progress, so systemd restarts a bridge that hangs for
+longer than WatchdogSec. It sends STOPPING=1 when done is closed.
+*/
+func notifySystemd(n *systemd.Notifier, ircSide, tgSide statusReporter, logger internal.DebugLogger, done <-chan struct{}) {
+	interval := notifyInterval
+	watchdog := n.WatchdogInterval()
+	if watchdog > 0 && watchdog/2 < interval {
+		interval = watchdog / 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	readySent := false
+	lastStatus := ""
+	for {
+		ircStatus, tgStatus := ircSide.Status(), tgSide.Status()
+		ready := ircStatus.Ready && tgStatus.Ready
+		var states []string
+		if status := statusText(ircStatus, tgStatus); status != lastStatus {
+			states = append(states, "STATUS="+status)
+			lastStatus = status
+		}
+		if ready && !readySent {
+			states = append(states, "READY=1")
+			readySent = true
+		}
+		if ready && watchdog > 0 {
+			states = append(states, "WATCHDOG=1")
+		}
+		if len(states) > 0 {
+			if err := n.Notify(strings.Join(states, "\n")); err != nil {
+				logger.LogWarning("Could not notify systemd: %s", err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			if err := n.Notify("STOPPING=1"); err != nil {
+				logger.LogWarning("Could not notify systemd: %s", err)
+			}
+			return
+		}
+	}
+}
+
+/*
+statusText describes the state of both sides for systemctl status
+*/
+func statusText(ircStatus, tgStatus internal.SideStatus) string {
+	if ircStatus.Ready && tgStatus.Ready {
+		return "Relaying messages between IRC and Telegram"
+	}
+	return "IRC: " + sideText(ircStatus) + "; Telegram: " + sideText(tgStatus)
+}
+
+func sideText(status internal.SideStatus) string {
+	if status.Ready {
+		return "ready"
+	}
+	if status.LastError != "" {
+		return status.Reason + " (" + status.LastError + ")"
+	}
+	return status.Reason
+}
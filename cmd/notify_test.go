@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/systemd"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/ritlug/teleirc/internal/testing/fakesystemd"
+	"github.com/ritlug/teleirc/internal/testing/faketelegram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+switchSide is a side of the bridge whose status tests can change
+*/
+type switchSide struct {
+	mu     sync.Mutex
+	status internal.SideStatus
+}
+
+func (s *switchSide) Status() internal.SideStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *switchSide) set(status internal.SideStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+/*
+startFakeSystemd starts a notify socket, with a watchdog of the given
+interval if it is not 0, and connects to it
+*/
+func startFakeSystemd(t *testing.T, watchdog time.Duration) (*fakesystemd.Socket, *systemd.Notifier) {
+	s, err := fakesystemd.New(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	t.Setenv("NOTIFY_SOCKET", s.Path)
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	if watchdog > 0 {
+		t.Setenv("WATCHDOG_USEC", strconv.FormatInt(watchdog.Microseconds(), 10))
+	}
+	n, err := systemd.NewNotifier()
+	require.NoError(t, err)
+	t.Cleanup(func() { n.Close() })
+	return s, n
+}
+
+func count(states []string, state string) int {
+	n := 0
+	for _, s := range states {
+		if s == state {
+			n++
+		}
+	}
+	return n
+}
+
+func TestNotifySystemd(t *testing.T) {
+	socket, notifier := startFakeSystemd(t, 200*time.Millisecond)
+	ircSide := &switchSide{status: internal.SideStatus{Reason: "not connected to IRC", LastError: "connection refused"}}
+	tgSide := &switchSide{status: internal.SideStatus{Ready: true}}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		notifySystemd(notifier, ircSide, tgSide, internal.Debug{}, done)
+		close(stopped)
+	}()
+
+	assert.True(t, socket.WaitFor("STATUS=IRC: not connected to IRC (connection refused); Telegram: ready", time.Second))
+	// Nothing is ready, so systemd gets neither READY=1 nor WATCHDOG=1
+	time.Sleep(300 * time.Millisecond)
+	assert.NotContains(t, socket.States(), "READY=1")
+	assert.NotContains(t, socket.States(), "WATCHDOG=1")
+
+	ircSide.set(internal.SideStatus{Ready: true})
+	assert.True(t, socket.WaitFor("READY=1", time.Second))
+	assert.Contains(t, socket.States(), "STATUS=Relaying messages between IRC and Telegram")
+	assert.True(t, socket.WaitFor("WATCHDOG=1", time.Second))
+
+	// The watchdog is not pinged while a side is stuck
+	tgSide.set(internal.SideStatus{Reason: "no successful getUpdates for 3m0s"})
+	assert.True(t, socket.WaitFor("STATUS=IRC: ready; Telegram: no successful getUpdates for 3m0s", time.Second))
+	pings := count(socket.States(), "WATCHDOG=1")
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, pings, count(socket.States(), "WATCHDOG=1"))
+
+	close(done)
+	<-stopped
+	assert.True(t, socket.WaitFor("STOPPING=1", time.Second))
+	// READY=1 is only sent once
+	assert.Equal(t, 1, count(socket.States(), "READY=1"))
+}
+
+func TestNotifySystemdWithoutWatchdog(t *testing.T) {
+	socket, notifier := startFakeSystemd(t, 0)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		notifySystemd(notifier, readySide, readySide, internal.Debug{}, done)
+		close(stopped)
+	}()
+
+	assert.True(t, socket.WaitFor("READY=1", time.Second))
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, slices.Contains(socket.States(), "WATCHDOG=1"))
+	close(done)
+	<-stopped
+}
+
+func TestBridgeNotifiesSystemd(t *testing.T) {
+	socket, _ := startFakeSystemd(t, 0)
+	ircServer, err := fakeirc.New()
+	require.NoError(t, err)
+	defer ircServer.Close()
+	tgServer := faketelegram.New(testToken)
+	defer tgServer.Close()
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan bool, 1)
+	go func() {
+		done <- runBridge(newTestSettings(ircServer, tgServer), internal.Debug{}, signals, nil)
+	}()
+
+	_, joined := ircServer.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	require.True(t, joined, "bridge should join the IRC channel")
+	assert.True(t, socket.WaitFor("READY=1", 5*time.Second), "bridge should tell systemd it is ready")
+
+	signals <- syscall.SIGTERM
+	select {
+	case exitError := <-done:
+		assert.False(t, exitError, "bridge should stop cleanly")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "bridge did not stop")
+	}
+	assert.True(t, socket.WaitFor("STOPPING=1", time.Second))
+}
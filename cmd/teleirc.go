@@ -3,17 +3,23 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
 	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/archive"
+	"github.com/ritlug/teleirc/internal/chanlog"
 	"github.com/ritlug/teleirc/internal/handlers/irc"
 	tg "github.com/ritlug/teleirc/internal/handlers/telegram"
+	"github.com/ritlug/teleirc/internal/history"
+	"github.com/ritlug/teleirc/internal/systemd"
 )
 
 var (
-	flagPath    = flag.String("conf", ".env", "config file")
+	flagPath    = flag.String("conf", ".env", "config file (.env, .yaml or .toml)")
 	flagDebug   = flag.Bool("debug", false, "enable debugging output")
 	flagVersion = flag.Bool("version", false, "displays current version of TeleIRC")
 	version     string
@@ -21,59 +27,187 @@ var (
 
 func main() {
 	flag.Parse()
-	logger := internal.Debug{DebugLevel: *flagDebug}
-
-	logger.LogInfo("Current TeleIRC version: %s", version)
+	level := "info"
+	if *flagDebug {
+		level = "debug"
+	}
+	// Both are valid, so this cannot fail
+	logger, _ := internal.NewLogger(level, "text")
 
 	if *flagVersion {
+		logger.LogInfo("Current TeleIRC version: %s", version)
 		return
 	}
 
+	// Subcommands print their results on stdout, which must not start
+	// with a log line, so archive exports can be piped
+	switch flag.Arg(0) {
+	case "check-config":
+		os.Exit(runCheckConfig(flag.Args()[1:], logger, os.Stdout))
+	case "archive":
+		os.Exit(runArchive(flag.Args()[1:], os.Stdout, os.Stderr))
+	}
+
+	logger.LogInfo("Current TeleIRC version: %s", version)
+
 	settings, err := internal.LoadConfig(*flagPath)
 	if err != nil {
 		logger.LogError("config load: %s", err)
 		os.Exit(1)
 	}
 
-	if settings.LogLevel == "debug" {
-		logger.DebugLevel = true
+	// -debug wins over LOG_LEVEL
+	if !*flagDebug {
+		level = settings.LogLevel
+	}
+	if logger, err = internal.NewLogger(level, settings.LogFormat); err != nil {
+		// logger is unusable here, so the error goes straight to stderr
+		fmt.Fprintf(os.Stderr, "config load: %s\n", err)
+		os.Exit(1)
 	}
 
 	// Notify that logger is enabled
 	logger.LogDebug("Debug mode enabled!")
 
 	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	reload := func() (*internal.Settings, error) {
+		return internal.LoadConfig(*flagPath)
+	}
+	if exitError := runBridge(settings, logger, signalChannel, reload); exitError {
+		os.Exit(1)
+	}
+}
 
+/*
+runBridge starts the IRC and Telegram clients and relays messages between
+them until either side fails or a signal is received. SIGHUP reloads the
+config with reload instead. When started by systemd with Type=notify, it
+reports the state of the bridge to systemd. Relayed events are archived if
+ARCHIVE_PATH is set, and the channel is logged if CHANNEL_LOG_DIR is set.
+It returns whether the bridge stopped because of an error.
+*/
+func runBridge(settings *internal.Settings, logger internal.DebugLogger, signals <-chan os.Signal,
+	reload func() (*internal.Settings, error)) bool {
 	tgClient := tg.NewClient(&settings.Telegram, &settings.IRC, &settings.Imgur, logger)
-	tgChan := make(chan error)
+	tgChan := make(chan error, 1)
 
 	ircClient := irc.NewClient(&settings.IRC, &settings.Telegram, logger)
-	ircChan := make(chan error)
+	ircChan := make(chan error, 1)
+
+	if settings.HTTPListen != "" {
+		server, err := startHTTPServer(settings.HTTPListen, ircClient, tgClient, logger)
+		if err != nil {
+			logger.LogError("HTTP server: %s", err)
+			return true
+		}
+		defer server.Close()
+	}
+
+	// background runs until the bridge shuts down
+	var background sync.WaitGroup
+	done := make(chan struct{})
+
+	if settings.ArchivePath != "" {
+		store, err := archive.Open(settings.ArchivePath)
+		if err != nil {
+			logger.LogError("Archive: %s", err)
+			return true
+		}
+		defer store.Close()
+		ircClient.Archive = store
+		tgClient.Archive = store
+		if days := settings.ArchiveRetentionDays; days > 0 {
+			background.Go(func() { pruneArchive(store, days, logger, done) })
+		}
+	}
+
+	if settings.ChannelLogDir != "" {
+		channelLog, err := chanlog.New(settings.ChannelLogDir, settings.ChannelLogFormat)
+		if err != nil {
+			logger.LogError("Channel log: %s", err)
+			return true
+		}
+		defer channelLog.Close()
+		ircClient.ChannelLog = channelLog
+	}
+
+	// Both sides share the scrollback, nil if HISTORY_SIZE is 0
+	scrollback := history.New(settings.HistorySize)
+	ircClient.History = scrollback
+	tgClient.History = scrollback
+
+	notifier, err := systemd.NewNotifier()
+	if err != nil {
+		logger.LogWarning("Could not connect to systemd, not sending notifications: %s", err)
+	} else if notifier != nil {
+		defer notifier.Close()
+		background.Go(func() { notifySystemd(notifier, ircClient, tgClient, logger, done) })
+	}
 
 	go ircClient.StartBot(ircChan, tgClient.SendMessage)
 	go tgClient.StartBot(tgChan, ircClient.SendMessage)
 
 	exitError := false
 
-	select {
-	case ircErr := <-ircChan:
-		logger.LogError("IRC error: %s", ircErr)
-		exitError = true
-	case tgErr := <-tgChan:
-		logger.LogError("Telegram error: %s", tgErr)
-		exitError = true
-	case signal := <-signalChannel:
-		logger.LogInfo("Signal Received: %s", signal.String())
-		break
+	for running := true; running; {
+		select {
+		case ircErr := <-ircChan:
+			logger.LogError("IRC error: %s", ircErr)
+			exitError = true
+			running = false
+		case tgErr := <-tgChan:
+			logger.LogError("Telegram error: %s", tgErr)
+			exitError = true
+			running = false
+		case signal := <-signals:
+			if signal == syscall.SIGHUP {
+				reloadBridge(reload, ircClient, tgClient, logger)
+				continue
+			}
+			logger.LogInfo("Signal Received: %s", signal.String())
+			running = false
+		}
 	}
 
 	logger.LogInfo("Shutting Down...")
+	close(done)
+	background.Wait()
 	ircClient.Close()
 	tgClient.Close()
 	logger.LogInfo("Exiting")
 
-	if exitError {
-		os.Exit(1)
+	return exitError
+}
+
+/*
+reloadBridge loads the config again and hands the new settings to both
+clients. A side only reconnects if one of its connection settings changed.
+An invalid config is rejected, and the bridge keeps running with the old one.
+*/
+func reloadBridge(reload func() (*internal.Settings, error), ircClient irc.Client, tgClient *tg.Client, logger internal.DebugLogger) {
+	logger.LogInfo("Reloading config...")
+	settings, err := reload()
+	if err != nil {
+		logger.LogError("config reload failed, keeping the old config: %s", err)
+		return
+	}
+	ircReconnect, err := ircClient.Reload(&settings.IRC, &settings.Telegram)
+	if err != nil {
+		logger.LogError("config reload failed, keeping the old config: %s", err)
+		return
+	}
+	tgRestart := tgClient.Reload(&settings.Telegram, &settings.IRC, &settings.Imgur)
+
+	switch {
+	case ircReconnect && tgRestart:
+		logger.LogInfo("Config reloaded, reconnecting to IRC and Telegram")
+	case ircReconnect:
+		logger.LogInfo("Config reloaded, reconnecting to IRC")
+	case tgRestart:
+		logger.LogInfo("Config reloaded, reconnecting to Telegram")
+	default:
+		logger.LogInfo("Config reloaded")
 	}
 }
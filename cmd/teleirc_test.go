@@ -0,0 +1,267 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/metrics"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/ritlug/teleirc/internal/testing/faketelegram"
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "000000000:AAAAAAaAAa2AaAAaoAAAA-a_aaAAaAaaaAA"
+
+/*
+newTestSettings returns settings for a bridge between the fake servers
+*/
+func newTestSettings(ircServer *fakeirc.Server, tgServer *faketelegram.Server) *internal.Settings {
+	return &internal.Settings{
+		IRC: internal.IRCSettings{
+			Server:   ircServer.Host,
+			Port:     ircServer.Port,
+			Channel:  "#bridge",
+			BotIdent: "teleirc",
+			BotName:  "TeleIRC",
+			BotNick:  "teleirc",
+			Prefix:   "<",
+			Suffix:   ">",
+		},
+		Telegram: internal.TelegramSettings{
+			Token:       testToken,
+			ChatID:      -100,
+			Prefix:      "<",
+			Suffix:      ">",
+			APIURL:      tgServer.URL,
+			HTTPTimeout: 2 * time.Second,
+		},
+	}
+}
+
+func TestBridgeEndToEnd(t *testing.T) {
+	ircServer, err := fakeirc.New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ircServer.Close()
+	tgServer := faketelegram.New(testToken)
+	defer tgServer.Close()
+
+	relayedToTg := metrics.MessagesRelayed.Value(metrics.IRCToTelegram, "text")
+	relayedToIRC := metrics.MessagesRelayed.Value(metrics.TelegramToIRC, "text")
+	signals := make(chan os.Signal, 1)
+	done := make(chan bool, 1)
+	go func() {
+		done <- runBridge(newTestSettings(ircServer, tgServer), internal.Debug{}, signals, nil)
+	}()
+
+	_, joined := ircServer.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	if !assert.True(t, joined, "bridge should join the IRC channel") {
+		return
+	}
+
+	// IRC to Telegram
+	ircServer.Join("alice", "#bridge")
+	ircServer.Say("alice", "#bridge", "hello from \x02IRC\x02")
+	calls := tgServer.WaitForCalls("sendMessage", 1, 5*time.Second)
+	if assert.Len(t, calls, 1) {
+		assert.Equal(t, "-100", calls[0].Params["chat_id"])
+		assert.Equal(t, "<alice> hello from IRC", calls[0].Params["text"])
+	}
+
+	// Telegram to IRC
+	tgServer.AddMessage(-100, &models.User{ID: 2, FirstName: "Bruce", Username: "batman"}, "hello from Telegram")
+	msg, relayed := ircServer.WaitForCommand(girc.PRIVMSG, "#bridge", 5*time.Second)
+	if assert.True(t, relayed, "Telegram message should reach IRC") {
+		assert.Equal(t, "<batman> hello from Telegram", msg.Last())
+	}
+	assert.Equal(t, relayedToTg+1, metrics.MessagesRelayed.Value(metrics.IRCToTelegram, "text"))
+	assert.Equal(t, relayedToIRC+1, metrics.MessagesRelayed.Value(metrics.TelegramToIRC, "text"))
+
+	signals <- syscall.SIGTERM
+	select {
+	case exitError := <-done:
+		assert.False(t, exitError, "bridge should stop cleanly")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "bridge did not stop")
+	}
+}
+
+func TestBridgeIRCFailure(t *testing.T) {
+	ircServer, err := fakeirc.New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	ircServer.Password = "secret"
+	defer ircServer.Close()
+	tgServer := faketelegram.New(testToken)
+	defer tgServer.Close()
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan bool, 1)
+	go func() {
+		done <- runBridge(newTestSettings(ircServer, tgServer), internal.Debug{}, signals, nil)
+	}()
+
+	select {
+	case exitError := <-done:
+		assert.True(t, exitError, "bridge should fail when IRC rejects the connection")
+	case <-time.After(10 * time.Second):
+		signals <- syscall.SIGTERM
+		assert.Fail(t, "bridge did not notice the IRC failure")
+	}
+}
+
+func TestBridgeReload(t *testing.T) {
+	ircServer, err := fakeirc.New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ircServer.Close()
+	tgServer := faketelegram.New(testToken)
+	defer tgServer.Close()
+
+	settings := newTestSettings(ircServer, tgServer)
+	var next *internal.Settings
+	var nextErr error
+	reload := func() (*internal.Settings, error) {
+		return next, nextErr
+	}
+	// reloadWith reloads the bridge with a copy of the settings changed by change
+	reloadWith := func(signals chan os.Signal, change func(*internal.Settings)) {
+		copied := *settings
+		change(&copied)
+		next, nextErr, settings = &copied, nil, &copied
+		signals <- syscall.SIGHUP
+	}
+	joins := func() int {
+		count := 0
+		for _, e := range ircServer.Received() {
+			if e.Command == girc.JOIN {
+				count++
+			}
+		}
+		return count
+	}
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan bool, 1)
+	go func() {
+		done <- runBridge(settings, internal.Debug{}, signals, reload)
+	}()
+	_, joined := ircServer.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	if !assert.True(t, joined, "bridge should join the IRC channel") {
+		return
+	}
+	ircServer.Join("alice", "#bridge")
+
+	// Formatting changes apply without reconnecting
+	reloadWith(signals, func(s *internal.Settings) { s.IRC.Prefix, s.IRC.Suffix = "[", "]" })
+	assert.Eventually(t, func() bool {
+		ircServer.Say("alice", "#bridge", "hello")
+		calls := tgServer.CallsTo("sendMessage")
+		return len(calls) > 0 && calls[len(calls)-1].Params["text"] == "[alice] hello"
+	}, 5*time.Second, 50*time.Millisecond, "the new prefix should be used")
+	assert.Equal(t, 1, joins(), "bridge should not reconnect to IRC")
+
+	// A new nick needs a new connection
+	reloadWith(signals, func(s *internal.Settings) { s.IRC.BotNick = "teleirc2" })
+	_, renamed := ircServer.WaitForCommand(girc.NICK, "teleirc2", 5*time.Second)
+	assert.True(t, renamed, "bridge should reconnect with the new nick")
+	assert.Eventually(t, func() bool { return joins() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	// A new Bot API server only restarts the Telegram side
+	newTgServer := faketelegram.New(testToken)
+	defer newTgServer.Close()
+	reloadWith(signals, func(s *internal.Settings) { s.Telegram.APIURL = newTgServer.URL })
+	assert.Len(t, newTgServer.WaitForCalls("getMe", 1, 5*time.Second), 1, "bot should restart with the new server")
+	newTgServer.AddMessage(-100, &models.User{ID: 2, Username: "batman"}, "hello again")
+	msg, relayed := ircServer.WaitForCommand(girc.PRIVMSG, "#bridge", 5*time.Second)
+	if assert.True(t, relayed, "messages from the new server should reach IRC") {
+		assert.Equal(t, "<batman> hello again", msg.Last())
+	}
+	assert.Equal(t, 2, joins(), "bridge should not reconnect to IRC")
+
+	// A new channel is joined, and relayed from
+	reloadWith(signals, func(s *internal.Settings) { s.IRC.Channel = "#newbridge" })
+	_, joined = ircServer.WaitForCommand(girc.JOIN, "#newbridge", 5*time.Second)
+	assert.True(t, joined, "bridge should join the new channel")
+	ircServer.Join("alice", "#newbridge")
+	assert.Eventually(t, func() bool {
+		ircServer.Say("alice", "#newbridge", "moved")
+		calls := newTgServer.CallsTo("sendMessage")
+		return len(calls) > 0 && calls[len(calls)-1].Params["text"] == "[alice] moved"
+	}, 5*time.Second, 50*time.Millisecond, "messages from the new channel should reach Telegram")
+
+	// An invalid config keeps the bridge running
+	next, nextErr = nil, assert.AnError
+	signals <- syscall.SIGHUP
+	select {
+	case <-done:
+		assert.Fail(t, "bridge stopped on an invalid config")
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	signals <- syscall.SIGTERM
+	select {
+	case exitError := <-done:
+		assert.False(t, exitError, "bridge should stop cleanly")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "bridge did not stop")
+	}
+}
+
+func TestBridgeChannelLog(t *testing.T) {
+	ircServer, err := fakeirc.New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ircServer.Close()
+	tgServer := faketelegram.New(testToken)
+	defer tgServer.Close()
+
+	settings := newTestSettings(ircServer, tgServer)
+	settings.ChannelLogDir = t.TempDir()
+	settings.ChannelLogFormat = "irssi"
+	signals := make(chan os.Signal, 1)
+	done := make(chan bool, 1)
+	go func() {
+		done <- runBridge(settings, internal.Debug{}, signals, nil)
+	}()
+
+	_, joined := ircServer.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	if !assert.True(t, joined, "bridge should join the IRC channel") {
+		return
+	}
+	ircServer.Join("alice", "#bridge")
+	ircServer.Say("alice", "#bridge", "hello from IRC")
+	tgServer.WaitForCalls("sendMessage", 1, 5*time.Second)
+	tgServer.AddMessage(-100, &models.User{ID: 2, FirstName: "Bruce", Username: "batman"}, "hello from Telegram")
+	_, relayed := ircServer.WaitForCommand(girc.PRIVMSG, "#bridge", 5*time.Second)
+	assert.True(t, relayed, "Telegram message should reach IRC")
+
+	signals <- syscall.SIGTERM
+	select {
+	case exitError := <-done:
+		assert.False(t, exitError, "bridge should stop cleanly")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "bridge did not stop")
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(settings.ChannelLogDir, "#bridge."+time.Now().Format("2006-01-02")+".log"))
+	if assert.NoError(t, err) {
+		log := string(data)
+		assert.Contains(t, log, "--- Log opened ")
+		assert.Regexp(t, `-!- alice \[\S+@\S+\] has joined #bridge\n`, log)
+		assert.Contains(t, log, "< alice> hello from IRC\n")
+		assert.Contains(t, log, "< teleirc> <batman> hello from Telegram\n")
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Package archive keeps a record of every event the bridge relays in an SQLite
+database, with full-text search over the text of the events
+*/
+package archive
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	// Registers the "sqlite" driver
+	_ "modernc.org/sqlite"
+)
+
+// Sides of the bridge an event can come from
+const (
+	IRC      = "irc"
+	Telegram = "telegram"
+)
+
+/*
+Event is something that happened on one side of the bridge and was relayed
+to the other
+*/
+type Event struct {
+	ID   int64     `json:"id"`
+	Time time.Time `json:"time"`
+	// Side is where the event happened, IRC or Telegram
+	Side string `json:"side"`
+	// Chat is the IRC channel or the Telegram chat ID
+	Chat string `json:"chat"`
+	// Kind is the kind of event, as in the teleirc_messages_relayed_total
+	// metric: text, action, join, edit, ...
+	Kind string `json:"kind"`
+	// SenderID is nick!user@host on IRC and the user ID on Telegram
+	SenderID   string `json:"sender_id,omitempty"`
+	SenderName string `json:"sender_name,omitempty"`
+	// Text is the message as it was relayed
+	Text string `json:"text"`
+	// MessageID is the ID of the Telegram message
+	MessageID string `json:"message_id,omitempty"`
+	// ReplyTo is the ID of the Telegram message this one replies to
+	ReplyTo string `json:"reply_to,omitempty"`
+	// EditOf is the ID of the Telegram message this event edits
+	EditOf string `json:"edit_of,omitempty"`
+	// Media is the Telegram file ID of a document, or a geo: URI
+	Media string `json:"media,omitempty"`
+}
+
+// schema creates the events table and an FTS5 index of the text, which
+// triggers keep up to date
+const schema = `
+CREATE TABLE IF NOT EXISTS events (
+	id          INTEGER PRIMARY KEY,
+	time        INTEGER NOT NULL,
+	side        TEXT NOT NULL,
+	chat        TEXT NOT NULL,
+	kind        TEXT NOT NULL,
+	sender_id   TEXT NOT NULL,
+	sender_name TEXT NOT NULL,
+	text        TEXT NOT NULL,
+	message_id  TEXT NOT NULL,
+	reply_to    TEXT NOT NULL,
+	edit_of     TEXT NOT NULL,
+	media       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_time ON events (time);
+CREATE VIRTUAL TABLE IF NOT EXISTS events_text USING fts5 (
+	text, sender_name, content='events', content_rowid='id'
+);
+CREATE TRIGGER IF NOT EXISTS events_insert AFTER INSERT ON events BEGIN
+	INSERT INTO events_text (rowid, text, sender_name) VALUES (new.id, new.text, new.sender_name);
+END;
+CREATE TRIGGER IF NOT EXISTS events_delete AFTER DELETE ON events BEGIN
+	INSERT INTO events_text (events_text, rowid, text, sender_name)
+	VALUES ('delete', old.id, old.text, old.sender_name);
+END;
+`
+
+/*
+Archive is an SQLite database of relayed events. A nil Archive stores
+nothing, so the bridge runs the same without one.
+*/
+type Archive struct {
+	db *sql.DB
+}
+
+/*
+Open opens the archive at path, creating it if needed
+*/
+func Open(path string) (*Archive, error) {
+	// WAL lets the archive subcommand read while the bridge writes
+	pragmas := url.Values{"_pragma": {"journal_mode(WAL)", "busy_timeout(5000)"}}
+	// Escapes ? and # in the path. Without a host, relative paths stay
+	// relative.
+	dsn := url.URL{Scheme: "file", Path: path, OmitHost: true, RawQuery: pragmas.Encode()}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not open archive %s: %w", path, err)
+	}
+	return &Archive{db: db}, nil
+}
+
+/*
+Close closes the database
+*/
+func (a *Archive) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.db.Close()
+}
+
+/*
+Add stores an event. Events without a time happened now.
+*/
+func (a *Archive) Add(e Event) error {
+	if a == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	_, err := a.db.Exec(`INSERT INTO events
+		(time, side, chat, kind, sender_id, sender_name, text, message_id, reply_to, edit_of, media)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Time.UnixNano(), e.Side, e.Chat, e.Kind, e.SenderID, e.SenderName, e.Text,
+		e.MessageID, e.ReplyTo, e.EditOf, e.Media)
+	return err
+}
+
+/*
+Prune deletes the events older than before, and returns how many it deleted
+*/
+func (a *Archive) Prune(before time.Time) (int64, error) {
+	if a == nil {
+		return 0, nil
+	}
+	result, err := a.db.Exec(`DELETE FROM events WHERE time < ?`, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+/*
+Query selects events to search for
+*/
+type Query struct {
+	// Text are words that must all appear in the text or sender name, in
+	// any order. Empty matches every event.
+	Text string
+	// Since and Until limit the time of the events, if set
+	Since time.Time
+	Until time.Time
+	// Side limits the events to IRC or Telegram, if set
+	Side string
+	// Limit is the most events to return, the newest ones. 0 returns all.
+	Limit int
+}
+
+/*
+Search returns the events matching q, oldest first
+*/
+func (a *Archive) Search(q Query) ([]Event, error) {
+	var where []string
+	var args []any
+	if match := matchExpr(q.Text); match != "" {
+		where = append(where, "id IN (SELECT rowid FROM events_text WHERE events_text MATCH ?)")
+		args = append(args, match)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "time < ?")
+		args = append(args, q.Until.UnixNano())
+	}
+	if q.Side != "" {
+		where = append(where, "side = ?")
+		args = append(args, q.Side)
+	}
+
+	query := `SELECT id, time, side, chat, kind, sender_id, sender_name, text, message_id, reply_to, edit_of, media
+		FROM events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY time DESC, id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var nanos int64
+		if err := rows.Scan(&e.ID, &nanos, &e.Side, &e.Chat, &e.Kind, &e.SenderID, &e.SenderName,
+			&e.Text, &e.MessageID, &e.ReplyTo, &e.EditOf, &e.Media); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, nanos)
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Oldest first, like a log
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+/*
+matchExpr turns words into an FTS5 query matching all of them. Every word
+is quoted, so characters such as - or * are not read as operators.
+*/
+func matchExpr(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
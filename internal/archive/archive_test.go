@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestArchive(t *testing.T) *Archive {
+	t.Helper()
+	a, err := Open(filepath.Join(t.TempDir(), "archive.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+func texts(events []Event) []string {
+	var texts []string
+	for _, e := range events {
+		texts = append(texts, e.Text)
+	}
+	return texts
+}
+
+func TestAddAndSearch(t *testing.T) {
+	a := openTestArchive(t)
+	now := time.Now()
+	events := []Event{
+		{Time: now.Add(-3 * time.Hour), Side: IRC, Chat: "#bridge", Kind: "text",
+			SenderID: "alice!alice@example.com", SenderName: "alice", Text: "<alice> the deploy failed"},
+		{Time: now.Add(-2 * time.Hour), Side: Telegram, Chat: "-100", Kind: "reply",
+			SenderID: "2", SenderName: "batman", Text: "<batman> [Re alice: the deploy failed] retrying the deploy",
+			MessageID: "10", ReplyTo: "9"},
+		{Time: now.Add(-time.Hour), Side: IRC, Chat: "#bridge", Kind: "join",
+			SenderID: "robin!robin@example.com", SenderName: "robin", Text: "* robin joins"},
+	}
+	for _, e := range events {
+		require.NoError(t, a.Add(e))
+	}
+
+	found, err := a.Search(Query{Text: "deploy"})
+	require.NoError(t, err)
+	if assert.Len(t, found, 2) {
+		// Oldest first, with every field
+		expected := events[1]
+		expected.ID = found[1].ID
+		assert.Equal(t, expected.Time.UnixNano(), found[1].Time.UnixNano())
+		found[1].Time = expected.Time
+		assert.Equal(t, expected, found[1])
+		assert.Equal(t, "<alice> the deploy failed", found[0].Text)
+	}
+
+	found, err = a.Search(Query{Text: "deploy retrying"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{events[1].Text}, texts(found))
+
+	// Sender names are searched too
+	found, err = a.Search(Query{Text: "robin"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"* robin joins"}, texts(found))
+
+	found, err = a.Search(Query{Since: now.Add(-150 * time.Minute)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{events[1].Text, events[2].Text}, texts(found))
+
+	found, err = a.Search(Query{Until: now.Add(-150 * time.Minute)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{events[0].Text}, texts(found))
+
+	found, err = a.Search(Query{Side: IRC})
+	require.NoError(t, err)
+	assert.Equal(t, []string{events[0].Text, events[2].Text}, texts(found))
+
+	// The limit keeps the newest events
+	found, err = a.Search(Query{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{events[1].Text, events[2].Text}, texts(found))
+}
+
+func TestSearchOperators(t *testing.T) {
+	a := openTestArchive(t)
+	require.NoError(t, a.Add(Event{Side: IRC, Kind: "text", Text: `<alice> is "foo-bar" OR NOT`}))
+
+	// FTS5 syntax in the query is matched as words
+	for _, text := range []string{`foo-bar`, `"foo`, `OR NOT`, `foo*`} {
+		_, err := a.Search(Query{Text: text})
+		assert.NoError(t, err, text)
+	}
+	found, err := a.Search(Query{Text: "foo-bar"})
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+}
+
+func TestPrune(t *testing.T) {
+	a := openTestArchive(t)
+	now := time.Now()
+	require.NoError(t, a.Add(Event{Time: now.Add(-48 * time.Hour), Side: IRC, Kind: "text", Text: "old deploy"}))
+	require.NoError(t, a.Add(Event{Side: IRC, Kind: "text", Text: "new deploy"}))
+
+	deleted, err := a.Prune(now.Add(-24 * time.Hour))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, deleted)
+
+	// Pruned events are gone from the index too
+	found, err := a.Search(Query{Text: "deploy"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new deploy"}, texts(found))
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "what?#archive.db")
+	a, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, a.Add(Event{Side: IRC, Kind: "text", Text: "kept"}))
+	require.NoError(t, a.Close())
+
+	a, err = Open(path)
+	require.NoError(t, err)
+	defer a.Close()
+	found, err := a.Search(Query{Text: "kept"})
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.FileExists(t, path)
+}
+
+func TestOpenRelative(t *testing.T) {
+	t.Chdir(t.TempDir())
+	a, err := Open("archive.db")
+	require.NoError(t, err)
+	require.NoError(t, a.Close())
+	assert.FileExists(t, "archive.db")
+}
+
+func TestNilArchive(t *testing.T) {
+	var a *Archive
+	assert.NoError(t, a.Add(Event{Text: "dropped"}))
+	deleted, err := a.Prune(time.Now())
+	assert.NoError(t, err)
+	assert.Zero(t, deleted)
+	assert.NoError(t, a.Close())
+}
@@ -0,0 +1,226 @@
+/*
+Package chanlog writes the IRC channel to plain-text log files in the format
+of irssi or WeeChat, one file per channel and day, so tools such as pisg
+can read them
+*/
+package chanlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the log files
+const (
+	Irssi   = "irssi"
+	WeeChat = "weechat"
+)
+
+// Kinds of lines
+const (
+	Message = "message"
+	Action  = "action"
+	Join    = "join"
+	Part    = "part"
+	Quit    = "quit"
+	Kick    = "kick"
+	Nick    = "nick"
+	Topic   = "topic"
+)
+
+/*
+Line is something that happened in the channel
+*/
+type Line struct {
+	Time    time.Time
+	Kind    string
+	Channel string
+	Nick    string
+	// Host is user@host of Nick, if known
+	Host string
+	// Target is the nick kicked, or the new nick
+	Target string
+	// Text is the message, the topic, or the reason of a part, quit or kick
+	Text string
+}
+
+/*
+Writer writes lines to a file per channel and day in a directory. A nil
+Writer writes nothing.
+*/
+type Writer struct {
+	dir    string
+	format string
+
+	mu   sync.Mutex
+	file *os.File
+	path string
+}
+
+/*
+New returns a Writer to dir, which is created if needed, in format
+*/
+func New(dir, format string) (*Writer, error) {
+	if format != Irssi && format != WeeChat {
+		return nil, fmt.Errorf("unknown channel log format %q, use %s or %s", format, Irssi, WeeChat)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Writer{dir: dir, format: format}, nil
+}
+
+/*
+Write appends l to the file of its channel and day. Lines without a time
+happened now.
+*/
+func (w *Writer) Write(l Line) error {
+	if w == nil {
+		return nil
+	}
+	if l.Time.IsZero() {
+		l.Time = time.Now()
+	}
+	l.Time = l.Time.Local()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.open(w.fileName(l.Channel, l.Time), l.Time); err != nil {
+		return err
+	}
+	var text string
+	if w.format == WeeChat {
+		text = weechatLine(l)
+	} else {
+		text = irssiLine(l)
+	}
+	_, err := w.file.WriteString(text + "\n")
+	return err
+}
+
+/*
+Close closes the current file
+*/
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeFile(time.Now())
+}
+
+/*
+fileName returns the path of the log of channel on the day of t, such as
+#teleirc.2024-05-10.log
+*/
+func (w *Writer) fileName(channel string, t time.Time) string {
+	// Channel names may contain / on some networks
+	name := strings.ReplaceAll(strings.ToLower(channel), "/", "_")
+	return filepath.Join(w.dir, name+"."+t.Format("2006-01-02")+".log")
+}
+
+/*
+open makes path the current file, closing the previous one on a new day
+or channel
+*/
+func (w *Writer) open(path string, t time.Time) error {
+	if w.file != nil && w.path == path {
+		return nil
+	}
+	if err := w.closeFile(t); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.path = path
+	if w.format == Irssi {
+		_, err = fmt.Fprintf(file, "--- Log opened %s\n", t.Format("Mon Jan 02 15:04:05 2006"))
+	}
+	return err
+}
+
+func (w *Writer) closeFile(t time.Time) error {
+	if w.file == nil {
+		return nil
+	}
+	if w.format == Irssi {
+		fmt.Fprintf(w.file, "--- Log closed %s\n", t.Local().Format("Mon Jan 02 15:04:05 2006"))
+	}
+	err := w.file.Close()
+	w.file = nil
+	w.path = ""
+	return err
+}
+
+/*
+irssiLine formats l like the default theme of irssi
+*/
+func irssiLine(l Line) string {
+	var text string
+	switch l.Kind {
+	case Message:
+		text = fmt.Sprintf("< %s> %s", l.Nick, l.Text)
+	case Action:
+		text = fmt.Sprintf(" * %s %s", l.Nick, l.Text)
+	case Join:
+		text = fmt.Sprintf("-!- %s [%s] has joined %s", l.Nick, l.Host, l.Channel)
+	case Part:
+		text = fmt.Sprintf("-!- %s [%s] has left %s [%s]", l.Nick, l.Host, l.Channel, l.Text)
+	case Quit:
+		text = fmt.Sprintf("-!- %s [%s] has quit [%s]", l.Nick, l.Host, l.Text)
+	case Kick:
+		text = fmt.Sprintf("-!- %s was kicked from %s by %s [%s]", l.Target, l.Channel, l.Nick, l.Text)
+	case Nick:
+		text = fmt.Sprintf("-!- %s is now known as %s", l.Nick, l.Target)
+	case Topic:
+		if l.Text == "" {
+			text = fmt.Sprintf("-!- Topic unset by %s on %s", l.Nick, l.Channel)
+		} else {
+			text = fmt.Sprintf("-!- %s changed the topic of %s to: %s", l.Nick, l.Channel, l.Text)
+		}
+	default:
+		text = fmt.Sprintf("-!- %s %s", l.Nick, l.Text)
+	}
+	return l.Time.Format("15:04") + " " + text
+}
+
+/*
+weechatLine formats l like the logger plugin of WeeChat: the time, the
+prefix and the message separated by tabs
+*/
+func weechatLine(l Line) string {
+	var prefix, text string
+	switch l.Kind {
+	case Message:
+		prefix, text = l.Nick, l.Text
+	case Action:
+		prefix, text = " *", l.Nick+" "+l.Text
+	case Join:
+		prefix, text = "-->", fmt.Sprintf("%s (%s) has joined %s", l.Nick, l.Host, l.Channel)
+	case Part:
+		prefix, text = "<--", fmt.Sprintf("%s (%s) has left %s (%s)", l.Nick, l.Host, l.Channel, l.Text)
+	case Quit:
+		prefix, text = "<--", fmt.Sprintf("%s (%s) has quit (%s)", l.Nick, l.Host, l.Text)
+	case Kick:
+		prefix, text = "<--", fmt.Sprintf("%s has kicked %s (%s)", l.Nick, l.Target, l.Text)
+	case Nick:
+		prefix, text = "--", fmt.Sprintf("%s is now known as %s", l.Nick, l.Target)
+	case Topic:
+		if l.Text == "" {
+			prefix, text = "--", fmt.Sprintf("%s has unset topic for %s", l.Nick, l.Channel)
+		} else {
+			prefix, text = "--", fmt.Sprintf("%s has changed topic for %s to \"%s\"", l.Nick, l.Channel, l.Text)
+		}
+	default:
+		prefix, text = "--", l.Nick+" "+l.Text
+	}
+	return l.Time.Format("2006-01-02 15:04:05") + "\t" + prefix + "\t" + text
+}
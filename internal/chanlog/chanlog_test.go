@@ -0,0 +1,116 @@
+package chanlog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// day is the day of the test lines, in local time like the logs
+var day = time.Date(2024, 5, 10, 0, 0, 0, 0, time.Local)
+
+func at(hour, min int) time.Time {
+	return day.Add(time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute)
+}
+
+// lines has one line of every kind
+var lines = []Line{
+	{Time: at(9, 1), Kind: Join, Channel: "#Bridge", Nick: "alice", Host: "alice@example.com"},
+	{Time: at(9, 2), Kind: Message, Channel: "#Bridge", Nick: "alice", Text: "hello"},
+	{Time: at(9, 3), Kind: Action, Channel: "#Bridge", Nick: "alice", Text: "waves"},
+	{Time: at(9, 4), Kind: Message, Channel: "#Bridge", Nick: "teleirc", Text: "<batman> hi alice"},
+	{Time: at(9, 5), Kind: Topic, Channel: "#Bridge", Nick: "alice", Text: "Deploy on Friday"},
+	{Time: at(9, 6), Kind: Topic, Channel: "#Bridge", Nick: "alice"},
+	{Time: at(9, 7), Kind: Nick, Channel: "#Bridge", Nick: "alice", Target: "alice_"},
+	{Time: at(9, 8), Kind: Kick, Channel: "#Bridge", Nick: "alice_", Target: "mallory", Text: "spam"},
+	{Time: at(9, 9), Kind: Part, Channel: "#Bridge", Nick: "robin", Host: "robin@example.com", Text: "bye"},
+	{Time: at(9, 10), Kind: Quit, Channel: "#Bridge", Nick: "alice_", Host: "alice@example.com", Text: "Ping timeout"},
+}
+
+func writeLines(t *testing.T, format string) string {
+	t.Helper()
+	dir := t.TempDir()
+	w, err := New(dir, format)
+	require.NoError(t, err)
+	for _, l := range lines {
+		require.NoError(t, w.Write(l))
+	}
+	require.NoError(t, w.Close())
+	data, err := os.ReadFile(filepath.Join(dir, "#bridge.2024-05-10.log"))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestIrssi(t *testing.T) {
+	log := strings.Split(writeLines(t, Irssi), "\n")
+	require.Len(t, log, len(lines)+3)
+	assert.Equal(t, "--- Log opened Fri May 10 09:01:00 2024", log[0])
+	assert.Equal(t, []string{
+		"09:01 -!- alice [alice@example.com] has joined #Bridge",
+		"09:02 < alice> hello",
+		"09:03  * alice waves",
+		"09:04 < teleirc> <batman> hi alice",
+		"09:05 -!- alice changed the topic of #Bridge to: Deploy on Friday",
+		"09:06 -!- Topic unset by alice on #Bridge",
+		"09:07 -!- alice is now known as alice_",
+		"09:08 -!- mallory was kicked from #Bridge by alice_ [spam]",
+		"09:09 -!- robin [robin@example.com] has left #Bridge [bye]",
+		"09:10 -!- alice_ [alice@example.com] has quit [Ping timeout]",
+	}, log[1:len(lines)+1])
+	assert.True(t, strings.HasPrefix(log[len(lines)+1], "--- Log closed "))
+}
+
+func TestWeeChat(t *testing.T) {
+	log := strings.Split(strings.TrimSuffix(writeLines(t, WeeChat), "\n"), "\n")
+	assert.Equal(t, []string{
+		"2024-05-10 09:01:00\t-->\talice (alice@example.com) has joined #Bridge",
+		"2024-05-10 09:02:00\talice\thello",
+		"2024-05-10 09:03:00\t *\talice waves",
+		"2024-05-10 09:04:00\tteleirc\t<batman> hi alice",
+		"2024-05-10 09:05:00\t--\talice has changed topic for #Bridge to \"Deploy on Friday\"",
+		"2024-05-10 09:06:00\t--\talice has unset topic for #Bridge",
+		"2024-05-10 09:07:00\t--\talice is now known as alice_",
+		"2024-05-10 09:08:00\t<--\talice_ has kicked mallory (spam)",
+		"2024-05-10 09:09:00\t<--\trobin (robin@example.com) has left #Bridge (bye)",
+		"2024-05-10 09:10:00\t<--\talice_ (alice@example.com) has quit (Ping timeout)",
+	}, log)
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(dir, Irssi)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(Line{Time: at(23, 59), Kind: Message, Channel: "#bridge", Nick: "alice", Text: "good night"}))
+	require.NoError(t, w.Write(Line{Time: at(24, 1), Kind: Message, Channel: "#bridge", Nick: "alice", Text: "good morning"}))
+	require.NoError(t, w.Close())
+
+	// Reopening a day appends to its file
+	w, err = New(dir, Irssi)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(Line{Time: at(24, 2), Kind: Message, Channel: "#bridge", Nick: "alice", Text: "back"}))
+	require.NoError(t, w.Close())
+
+	first, err := os.ReadFile(filepath.Join(dir, "#bridge.2024-05-10.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(first), "23:59 < alice> good night\n--- Log closed Sat May 11 00:01:00 2024\n")
+	assert.NotContains(t, string(first), "good morning")
+	second, err := os.ReadFile(filepath.Join(dir, "#bridge.2024-05-11.log"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(second), "--- Log opened"))
+	assert.Contains(t, string(second), "00:01 < alice> good morning\n")
+	assert.Contains(t, string(second), "00:02 < alice> back\n")
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(t.TempDir(), "mirc")
+	assert.Error(t, err)
+
+	var w *Writer
+	assert.NoError(t, w.Write(Line{Kind: Message, Channel: "#bridge", Nick: "alice", Text: "hello"}))
+	assert.NoError(t, w.Close())
+}
@@ -3,76 +3,114 @@ package internal
 import (
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
+	"github.com/ritlug/teleirc/internal/proxy"
+	"github.com/ritlug/teleirc/internal/websocket"
 )
 
 var validate *validator.Validate
 
+// fileEnv holds the environment variables LoadConfig set from a config file
+// or a secret file. They are cleared before the config is loaded again, so
+// the new values in the files are used, while variables from the real
+// environment still win.
+var fileEnv = map[string]bool{}
+
 const defaultPath = ".env"
 
 // IRCSettings includes settings related to the IRC bot/message relaying
 type IRCSettings struct {
-	BindAddress         string   `env:"IRC_HOST_IP" envDefault:""`
-	Server              string   `env:"IRC_SERVER,required"`
-	ServerPass          string   `env:"IRC_SERVER_PASSWORD" envDefault:""`
-	Port                int      `env:"IRC_PORT" envDefault:"6667" validate:"min=0,max=65535"`
-	TLSAllowSelfSigned  bool     `env:"IRC_CERT_ALLOW_SELFSIGNED" envDefault:"true"`
-	TLSAllowCertExpired bool     `env:"IRC_CERT_ALLOW_EXPIRED" envDefault:"true"`
-	Channel             string   `env:"IRC_CHANNEL,required" validate:"notempty"`
-	ChannelKey          string   `env:"IRC_CHANNEL_KEY" envDefault:""`
-	BotIdent            string   `env:"IRC_BOT_IDENT,required" envDefault:"teleirc"`
-	BotName             string   `env:"IRC_BOT_REALNAME" envDefault:"telegw"`
-	BotNick             string   `env:"IRC_BOT_NAME,required" validate:"notempty"`
-	SendStickerEmoji    bool     `env:"IRC_SEND_STICKER_EMOJI" envDefault:"true"`
-	SendDocument        bool     `env:"IRC_SEND_DOCUMENT" envDefault:"true"`
-	Prefix              string   `env:"IRC_PREFIX" envDefault:"<"`
-	Suffix              string   `env:"IRC_SUFFIX" envDefault:">"`
-	ShowJoinMessage     bool     `env:"IRC_SHOW_JOIN_MESSAGE" envDefault:"true"`
-	ShowLeaveMessage    bool     `env:"IRC_SHOW_LEAVE_MESSAGE" envDefault:"true"`
-	ShowZWSP            bool     `env:"IRC_SHOW_ZWSP" envDefault:"false"`
-	ShowLocationMessage bool     `env:"IRC_SHOW_LOCATION_MESSAGE" envDefault:"false"`
-	NickServUser        string   `env:"IRC_NICKSERV_USER" envDefault:""`
-	NickServPassword    string   `env:"IRC_NICKSERV_PASS" envDefault:""`
-	NickServService     string   `env:"IRC_NICKSERV_SERVICE" envDefault:""`
-	EditedPrefix        string   `env:"IRC_EDITED_PREFIX" envDefault:"[EDIT] "`
-	MaxMessageLength    int      `env:"IRC_MAX_MESSAGE_LENGTH" envDefault:"400"`
-	IRCBlacklist        []string `env:"IRC_BLACKLIST" envDefault:"[]string{}"`
-	UseSSL              bool     `env:"IRC_USE_SSL" envDefault:"false"`
-	NoForwardPrefix     string   `env:"IRC_NO_FORWARD_PREFIX" envDefault:""`
-	QuitMessage         string   `env:"IRC_QUIT_MESSAGE" envDefault:""`
+	BindAddress         string        `env:"IRC_HOST_IP" envDefault:""`
+	Server              string        `env:"IRC_SERVER,required" validate:"ircserver"`
+	ServerPass          string        `env:"IRC_SERVER_PASSWORD" envDefault:"" secret:"true"`
+	Port                int           `env:"IRC_PORT" envDefault:"6667" validate:"min=0,max=65535"`
+	Servers             []string      `env:"IRC_SERVERS" envDefault:""`
+	ShuffleServers      bool          `env:"IRC_SERVERS_SHUFFLE" envDefault:"false"`
+	ReconnectDelay      time.Duration `env:"IRC_RECONNECT_DELAY" envDefault:"10s"`
+	PingInterval        time.Duration `env:"IRC_PING_INTERVAL" envDefault:"30s"`
+	LagTimeout          time.Duration `env:"IRC_LAG_TIMEOUT" envDefault:"60s"`
+	LagWarning          time.Duration `env:"IRC_LAG_WARNING" envDefault:"0s"`
+	STSFile             string        `env:"IRC_STS_FILE" envDefault:""`
+	TLSAllowSelfSigned  bool          `env:"IRC_CERT_ALLOW_SELFSIGNED" envDefault:"false"`
+	TLSAllowCertExpired bool          `env:"IRC_CERT_ALLOW_EXPIRED" envDefault:"false"`
+	TLSCAFile           string        `env:"IRC_CA_FILE" envDefault:"" validate:"omitempty,file"`
+	TLSServerName       string        `env:"IRC_TLS_SERVER_NAME" envDefault:""`
+	TLSPins             []string      `env:"IRC_CERT_PINS" envDefault:""`
+	TLSMinVersion       string        `env:"IRC_TLS_MIN_VERSION" envDefault:"1.2" validate:"oneof=1.0 1.1 1.2 1.3"`
+	TLSClientCert       string        `env:"IRC_CLIENT_CERT" envDefault:"" validate:"omitempty,file"`
+	TLSClientKey        string        `env:"IRC_CLIENT_KEY" envDefault:"" validate:"omitempty,file"`
+	Channel             string        `env:"IRC_CHANNEL,required" validate:"notempty"`
+	ChannelKey          string        `env:"IRC_CHANNEL_KEY" envDefault:""`
+	BotIdent            string        `env:"IRC_BOT_IDENT,required" envDefault:"teleirc"`
+	BotName             string        `env:"IRC_BOT_REALNAME" envDefault:"telegw"`
+	BotNick             string        `env:"IRC_BOT_NAME,required" validate:"notempty"`
+	SendStickerEmoji    bool          `env:"IRC_SEND_STICKER_EMOJI" envDefault:"true"`
+	SendDocument        bool          `env:"IRC_SEND_DOCUMENT" envDefault:"true"`
+	Prefix              string        `env:"IRC_PREFIX" envDefault:"<"`
+	Suffix              string        `env:"IRC_SUFFIX" envDefault:">"`
+	ShowJoinMessage     bool          `env:"IRC_SHOW_JOIN_MESSAGE" envDefault:"true"`
+	ShowLeaveMessage    bool          `env:"IRC_SHOW_LEAVE_MESSAGE" envDefault:"true"`
+	ShowZWSP            bool          `env:"IRC_SHOW_ZWSP" envDefault:"false"`
+	ShowLocationMessage bool          `env:"IRC_SHOW_LOCATION_MESSAGE" envDefault:"false"`
+	NickServUser        string        `env:"IRC_NICKSERV_USER" envDefault:""`
+	NickServPassword    string        `env:"IRC_NICKSERV_PASS" envDefault:"" secret:"true"`
+	NickServService     string        `env:"IRC_NICKSERV_SERVICE" envDefault:"NickServ"`
+	SASLRequired        bool          `env:"IRC_SASL_REQUIRED" envDefault:"false"`
+	EditedPrefix        string        `env:"IRC_EDITED_PREFIX" envDefault:"[EDIT] "`
+	MaxMessageLength    int           `env:"IRC_MAX_MESSAGE_LENGTH" envDefault:"400"`
+	IRCBlacklist        []string      `env:"IRC_BLACKLIST" envDefault:"[]string{}"`
+	Proxy               string        `env:"IRC_PROXY" envDefault:"" validate:"omitempty,proxyurl"`
+	UseSSL              bool          `env:"IRC_USE_SSL" envDefault:"false" validate:"required_with=TLSClientCert"`
+	NoForwardPrefix     string        `env:"IRC_NO_FORWARD_PREFIX" envDefault:""`
+	QuitMessage         string        `env:"IRC_QUIT_MESSAGE" envDefault:""`
+	HistoryOnJoin       int           `env:"IRC_HISTORY_ON_JOIN" envDefault:"0" validate:"min=0,max=20"`
+	ChatHistoryLimit    int           `env:"IRC_CHATHISTORY_LIMIT" envDefault:"50" validate:"min=0"`
 }
 
 // TelegramSettings includes settings related to the Telegram bot/message relaying
 type TelegramSettings struct {
-	Token                 string   `env:"TELEIRC_TOKEN,required"`
-	ChatID                int64    `env:"TELEGRAM_CHAT_ID,required"`
-	Prefix                string   `env:"TELEGRAM_MESSAGE_PREFIX" envDefault:"<"`
-	Suffix                string   `env:"TELEGRAM_MESSAGE_SUFFIX" envDefault:">"`
-	ReplyPrefix           string   `env:"TELEGRAM_MESSAGE_REPLY_PREFIX" envDefault:"["`
-	ReplySuffix           string   `env:"TELEGRAM_MESSAGE_REPLY_SUFFIX" envDefault:"]"`
-	ReplyLength           int      `env:"TELEGRAM_MESSAGE_REPLY_LENGTH" envDefault:"15"`
-	ShowTopicMessage      bool     `env:"SHOW_TOPIC_MESSAGE" envDefault:"false"`
-	ShowJoinMessage       bool     `env:"SHOW_JOIN_MESSAGE" envDefault:"false"`
-	JoinMessageAllowList  []string `env:"JOIN_MESSAGE_ALLOW_LIST" envDefault:"[]string{}"`
-	ShowActionMessage     bool     `env:"SHOW_ACTION_MESSAGE" envDefault:"true"`
-	ShowLeaveMessage      bool     `env:"SHOW_LEAVE_MESSAGE" envDefault:"false"`
-	LeaveMessageAllowList []string `env:"LEAVE_MESSAGE_ALLOW_LIST" envDefault:"[]string{}"`
-	ShowKickMessage       bool     `env:"SHOW_KICK_MESSAGE" envDefault:"false"`
-	ShowNickMessage       bool     `env:"SHOW_NICK_MESSAGE" envDefault:"false"`
-	ShowDisconnectMessage bool     `env:"SHOW_DISCONNECT_MESSAGE" envDefault:"false"`
-	MaxMessagePerMinute   int      `env:"MAX_MESSAGE_PER_MINUTE" envDefault:"20"`
+	Token                 string        `env:"TELEIRC_TOKEN,required" secret:"true"`
+	ChatID                int64         `env:"TELEGRAM_CHAT_ID,required"`
+	Prefix                string        `env:"TELEGRAM_MESSAGE_PREFIX" envDefault:"<"`
+	Suffix                string        `env:"TELEGRAM_MESSAGE_SUFFIX" envDefault:">"`
+	ReplyPrefix           string        `env:"TELEGRAM_MESSAGE_REPLY_PREFIX" envDefault:"["`
+	ReplySuffix           string        `env:"TELEGRAM_MESSAGE_REPLY_SUFFIX" envDefault:"]"`
+	ReplyLength           int           `env:"TELEGRAM_MESSAGE_REPLY_LENGTH" envDefault:"15"`
+	ShowTopicMessage      bool          `env:"SHOW_TOPIC_MESSAGE" envDefault:"false"`
+	ShowJoinMessage       bool          `env:"SHOW_JOIN_MESSAGE" envDefault:"false"`
+	JoinMessageAllowList  []string      `env:"JOIN_MESSAGE_ALLOW_LIST" envDefault:"[]string{}"`
+	ShowActionMessage     bool          `env:"SHOW_ACTION_MESSAGE" envDefault:"true"`
+	ShowLeaveMessage      bool          `env:"SHOW_LEAVE_MESSAGE" envDefault:"false"`
+	LeaveMessageAllowList []string      `env:"LEAVE_MESSAGE_ALLOW_LIST" envDefault:"[]string{}"`
+	ShowKickMessage       bool          `env:"SHOW_KICK_MESSAGE" envDefault:"false"`
+	ShowNickMessage       bool          `env:"SHOW_NICK_MESSAGE" envDefault:"false"`
+	ShowDisconnectMessage bool          `env:"SHOW_DISCONNECT_MESSAGE" envDefault:"false"`
+	MaxMessagePerMinute   int           `env:"MAX_MESSAGE_PER_MINUTE" envDefault:"20"`
+	StateFile             string        `env:"TELEGRAM_STATE_FILE" envDefault:""`
+	ChannelID             int64         `env:"TELEGRAM_CHANNEL_ID" envDefault:"0"`
+	WebhookURL            string        `env:"TELEGRAM_WEBHOOK_URL" envDefault:"" validate:"omitempty,url"`
+	WebhookListen         string        `env:"TELEGRAM_WEBHOOK_LISTEN" envDefault:":8443"`
+	WebhookPath           string        `env:"TELEGRAM_WEBHOOK_PATH" envDefault:""`
+	WebhookSecret         string        `env:"TELEGRAM_WEBHOOK_SECRET" envDefault:"" validate:"omitempty,max=256,webhooksecret" secret:"true"`
+	WebhookTLSCert        string        `env:"TELEGRAM_WEBHOOK_TLS_CERT" envDefault:"" validate:"required_with=WebhookTLSKey,omitempty,file"`
+	WebhookTLSKey         string        `env:"TELEGRAM_WEBHOOK_TLS_KEY" envDefault:"" validate:"required_with=WebhookTLSCert,omitempty,file"`
+	APIURL                string        `env:"TELEGRAM_API_URL" envDefault:"" validate:"omitempty,url"`
+	HTTPTimeout           time.Duration `env:"TELEGRAM_HTTP_TIMEOUT" envDefault:"1m" validate:"min=2s"`
+	HTTPProxy             string        `env:"TELEGRAM_HTTP_PROXY" envDefault:"" validate:"omitempty,proxyurl"`
 	DebugEnabled          bool
 }
 
 // ImgurSettings includes settings related to Imgur uploading for Telegram photos
 type ImgurSettings struct {
 	ImgurClientID     string `env:"IMGUR_CLIENT_ID" envDefault:"7d6b00b87043f58"`
-	ImgurClientSecret string `env:"IMGUR_CLIENT_SECRET" envDefault:""`
-	ImgurRefreshToken string `env:"IMGUR_REFRESH_TOKEN" envDefault:""`
+	ImgurClientSecret string `env:"IMGUR_CLIENT_SECRET" envDefault:"" secret:"true"`
+	ImgurRefreshToken string `env:"IMGUR_REFRESH_TOKEN" envDefault:"" secret:"true"`
 	ImgurAccessToken  string ``
 	ImgurAlbumHash    string `env:"IMGUR_ALBUM_HASH" envDefault:""`
 }
@@ -83,40 +121,114 @@ type Settings struct {
 	Telegram TelegramSettings
 	Imgur    ImgurSettings
 
-	LogLevel string `env:"LOG_LEVEL" envDefault:"info"`
+	LogLevel  string `env:"LOG_LEVEL" envDefault:"info" validate:"oneof=debug info warn warning error"`
+	LogFormat string `env:"LOG_FORMAT" envDefault:"text" validate:"oneof=text json"`
+	// ProxyURL is used by both sides unless they set their own proxy
+	ProxyURL string `env:"PROXY_URL" envDefault:"" validate:"omitempty,proxyurl"`
+	// HTTPListen is the address of the HTTP server for metrics, off if empty
+	HTTPListen string `env:"HTTP_LISTEN" envDefault:""`
+	// ArchivePath is the SQLite database relayed events are kept in, off if empty
+	ArchivePath          string `env:"ARCHIVE_PATH" envDefault:""`
+	ArchiveRetentionDays int    `env:"ARCHIVE_RETENTION_DAYS" envDefault:"0" validate:"min=0"`
+	// ChannelLogDir is where the daily logs of the IRC channel go, off if empty
+	ChannelLogDir    string `env:"CHANNEL_LOG_DIR" envDefault:""`
+	ChannelLogFormat string `env:"CHANNEL_LOG_FORMAT" envDefault:"irssi" validate:"oneof=irssi weechat"`
+	// HistorySize is how many relayed messages are kept for !history, off if 0
+	HistorySize int `env:"HISTORY_SIZE" envDefault:"0" validate:"min=0"`
 }
 
 func validateEmptyString(fl validator.FieldLevel) bool {
 	return fl.Field().String() != ""
 }
 
+// Telegram only accepts A-Z, a-z, 0-9, _ and - in webhook secret tokens
+var webhookSecretRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func validateWebhookSecret(fl validator.FieldLevel) bool {
+	return webhookSecretRegex.MatchString(fl.Field().String())
+}
+
+func validateProxyURL(fl validator.FieldLevel) bool {
+	return proxy.IsValid(fl.Field().String())
+}
+
+// IRC servers are host names, or ws:// and wss:// URLs for IRC over WebSocket
+func validateIRCServer(fl validator.FieldLevel) bool {
+	server := fl.Field().String()
+	if websocket.IsURL(server) {
+		_, err := websocket.ParseURL(server)
+		return err == nil
+	}
+	return !strings.Contains(server, "://")
+}
+
+/*
+ConfigError is an invalid setting. File, Line and Key are set if the setting
+came from a YAML or TOML config file.
+*/
+type ConfigError struct {
+	// Field is the setting's namespace, such as Settings.IRC.Port
+	Field   string
+	File    string
+	Line    int
+	Key     string
+	Message string
+}
+
+func (ce ConfigError) Error() string {
+	if ce.File == "" {
+		return ce.Message
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", ce.File, ce.Line, ce.Key, ce.Message)
+}
+
 // ConfigErrors lets us wrap the validator errors in a type we can return
-type ConfigErrors []validator.FieldError
+type ConfigErrors []ConfigError
 
 func (ce ConfigErrors) Error() string {
 	finalStr := ""
 	for _, err := range ce {
-		switch err.Tag() {
-		case "notempty":
-			finalStr += fmt.Sprintf("Field %s was an empty string. "+
-				"Perhaps you had a # and need to surround the value with \"\"?\n", err.Namespace())
-		case "min":
-			finalStr += fmt.Sprintf("Field %s failed to validate: %s too small.\n",
-				err.Namespace(), err.Param())
-		case "max":
-			finalStr += fmt.Sprintf("Field %s failed to validate: %s too large.\n",
-				err.Namespace(), err.Param())
-		default:
-			finalStr += fmt.Sprintf("Field %s failed to validate: %s failed %s.\n",
-				err.Namespace(), err.Param(), err.Tag())
-		}
+		finalStr += err.Error() + "\n"
 	}
 	return finalStr
 }
 
+/*
+newConfigError describes a validator error, at the location its setting
+was read from, if it came from a config file
+*/
+func newConfigError(err validator.FieldError, locations map[string]ConfigError) ConfigError {
+	ce := ConfigError{Field: err.Namespace()}
+	for _, location := range locations {
+		if location.Field == err.StructNamespace() {
+			ce = location
+		}
+	}
+	switch err.Tag() {
+	case "notempty":
+		ce.Message = fmt.Sprintf("Field %s was an empty string. "+
+			"Perhaps you had a # and need to surround the value with \"\"?", err.Namespace())
+	case "min":
+		ce.Message = fmt.Sprintf("Field %s failed to validate: %s too small.",
+			err.Namespace(), err.Param())
+	case "max":
+		ce.Message = fmt.Sprintf("Field %s failed to validate: %s too large.",
+			err.Namespace(), err.Param())
+	case "oneof":
+		ce.Message = fmt.Sprintf("Field %s failed to validate: %q is not one of %s.",
+			err.Namespace(), err.Value(), err.Param())
+	default:
+		ce.Message = fmt.Sprintf("Field %s failed to validate: %s failed %s.",
+			err.Namespace(), err.Param(), err.Tag())
+	}
+	return ce
+}
+
 /*
 For environment variables that are meant to be a list separated by whitespace,
-we need to do that manually.   This function does just that.
+we need to do that manually.   This function does just that.  The env parser
+already split the value on commas, so those pieces are joined back first and
+either separator works.
 */
 func splitEnvVar(list []string) []string {
 	if list == nil {
@@ -125,11 +237,41 @@ func splitEnvVar(list []string) []string {
 		return list
 	}
 
-	return strings.Split(list[0], " ")
+	return strings.Split(strings.Join(list, " "), " ")
+}
+
+/*
+setFileEnv sets an environment variable from a config file, unless it is
+set already
+*/
+func setFileEnv(key, value string) error {
+	if _, set := os.LookupEnv(key); set {
+		return nil
+	}
+	fileEnv[key] = true
+	return os.Setenv(key, value)
+}
+
+/*
+loadDotenv sets the environment variables of the .env file at path
+*/
+func loadDotenv(path string) error {
+	values, err := godotenv.Read(path)
+	if err != nil {
+		return err
+	}
+	for key, value := range values {
+		if err := setFileEnv(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 /*
 LoadConfig loads in the .env file in the provided path (or ".env" by default)
+A path ending in .yaml, .yml or .toml is read as a structured config file
+instead. Environment variables override the settings of either file.
 If the user-provided config is valid, return a new Settings struct that contains these settings.
 Otherwise, return the error that caused the failure.
 */
@@ -138,36 +280,84 @@ func LoadConfig(path string) (*Settings, error) {
 	if err := validate.RegisterValidation("notempty", validateEmptyString); err != nil {
 		return nil, err
 	}
+	if err := validate.RegisterValidation("webhooksecret", validateWebhookSecret); err != nil {
+		return nil, err
+	}
+	if err := validate.RegisterValidation("proxyurl", validateProxyURL); err != nil {
+		return nil, err
+	}
+	if err := validate.RegisterValidation("ircserver", validateIRCServer); err != nil {
+		return nil, err
+	}
+	for key := range fileEnv {
+		os.Unsetenv(key)
+	}
+	fileEnv = map[string]bool{}
+	var locations map[string]ConfigError
 	// Attempt to load environment variables from path if path was provided
-	if path != ".env" && path != "" {
-		if err := godotenv.Load(path); err != nil {
+	if isConfigFile(path) {
+		var err error
+		if locations, err = loadConfigFile(path); err != nil {
+			return nil, err
+		}
+	} else if path != ".env" && path != "" {
+		if err := loadDotenv(path); err != nil {
 			return nil, err
 		}
 	} else if _, err := os.Stat(defaultPath); !os.IsNotExist(err) {
 		// Attempt to load from defaultPath if defaultPath exists
-		if err := godotenv.Load(defaultPath); err != nil {
+		if err := loadDotenv(defaultPath); err != nil {
 			return nil, err
 		}
 	}
+	if err := loadSecretFiles(); err != nil {
+		return nil, err
+	}
 	settings := &Settings{}
 	if err := env.Parse(settings); err != nil {
 		return nil, err
 	}
+	// The scheme of a WebSocket URL decides whether TLS is used
+	if websocket.IsURL(settings.IRC.Server) {
+		settings.IRC.UseSSL = strings.HasPrefix(settings.IRC.Server, "wss://")
+	}
 	if err := validate.Struct(settings); err != nil {
 		fieldErrs := ConfigErrors{}
 		for _, errs := range err.(validator.ValidationErrors) {
-			fieldErrs = append(fieldErrs, errs)
+			fieldErrs = append(fieldErrs, newConfigError(errs, locations))
 		}
 		return nil, fieldErrs
 	}
 
 	settings.IRC.IRCBlacklist = splitEnvVar(settings.IRC.IRCBlacklist)
+	settings.IRC.TLSPins = splitEnvVar(settings.IRC.TLSPins)
+	settings.IRC.Servers = splitEnvVar(settings.IRC.Servers)
 	settings.Telegram.JoinMessageAllowList = splitEnvVar(settings.Telegram.JoinMessageAllowList)
 	settings.Telegram.LeaveMessageAllowList = splitEnvVar(settings.Telegram.LeaveMessageAllowList)
 
+	if settings.IRC.Proxy == "" {
+		settings.IRC.Proxy = settings.ProxyURL
+	}
+	if settings.Telegram.HTTPProxy == "" {
+		settings.Telegram.HTTPProxy = settings.ProxyURL
+	}
+
 	if settings.LogLevel == "debug" {
 		settings.Telegram.DebugEnabled = true
 	}
 
+	// A chat ID learned from a group migration overrides TELEGRAM_CHAT_ID,
+	// as long as TELEGRAM_CHAT_ID still points at the old group
+	if settings.Telegram.StateFile != "" {
+		state, err := LoadState(settings.Telegram.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		if state.TelegramChatID != 0 && state.TelegramOldChatID == settings.Telegram.ChatID {
+			settings.Telegram.ChatID = state.TelegramChatID
+		}
+	}
+
+	setSecrets(settings.secretValues())
 	return settings, nil
 }
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+loadTestConfig loads a YAML config with the required settings, followed by
+extra
+*/
+func loadTestConfig(t *testing.T, extra string) (*Settings, error) {
+	t.Helper()
+	path := writeConfig(t, "teleirc.yaml", `
+irc:
+  server: irc.example.com
+  channel: "#bridge"
+  bot_name: teleirc
+telegram:
+  teleirc_token: "000000000:AAAAAAaAAa2AaAAaoAAAA-a_aaAAaAaaaAA"
+  chat_id: -100
+`+extra)
+	return LoadConfig(path)
+}
+
+func TestWebhookTLSPair(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "cert.pem")
+	key := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(cert, []byte("cert"), 0600))
+	require.NoError(t, os.WriteFile(key, []byte("key"), 0600))
+
+	settings, err := loadTestConfig(t, "  webhook_tls_cert: "+cert+"\n  webhook_tls_key: "+key+"\n")
+	require.NoError(t, err)
+	assert.Equal(t, cert, settings.Telegram.WebhookTLSCert)
+
+	// Half a pair would serve plain HTTP
+	_, err = loadTestConfig(t, "  webhook_tls_cert: "+cert+"\n")
+	assert.ErrorContains(t, err, "WebhookTLSKey")
+	_, err = loadTestConfig(t, "  webhook_tls_key: "+key+"\n")
+	assert.ErrorContains(t, err, "WebhookTLSCert")
+
+	_, err = loadTestConfig(t, "  webhook_tls_cert: "+cert+"\n  webhook_tls_key: "+filepath.Join(dir, "missing.pem")+"\n")
+	assert.ErrorContains(t, err, "WebhookTLSKey")
+}
+
+func TestCertificateChecksDefault(t *testing.T) {
+	// Leaving the flags unset means full verification
+	settings, err := loadTestConfig(t, "")
+	require.NoError(t, err)
+	assert.False(t, settings.IRC.TLSAllowSelfSigned)
+	assert.False(t, settings.IRC.TLSAllowCertExpired)
+}
+
+func TestServersSeparators(t *testing.T) {
+	for _, servers := range []string{
+		"irc1.example.com irc2.example.com:+6697",
+		"irc1.example.com,irc2.example.com:+6697",
+	} {
+		t.Run(servers, func(t *testing.T) {
+			t.Setenv("IRC_SERVERS", servers)
+			settings, err := loadTestConfig(t, "")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"irc1.example.com", "irc2.example.com:+6697"}, settings.IRC.Servers)
+		})
+	}
+}
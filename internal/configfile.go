@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+setting describes a field of Settings that can be configured
+*/
+type setting struct {
+	// env is the environment variable, such as IRC_PORT
+	env string
+	// key is the key in a YAML or TOML config file, such as irc.port
+	key string
+	// namespace is the field's namespace, such as Settings.IRC.Port
+	namespace string
+	typ       reflect.Type
+	// secret is set for passwords and tokens, see secrets.go
+	secret bool
+}
+
+/*
+settingsByKey returns the configurable fields of Settings, by config file
+key. Settings of the IRC, Telegram and Imgur sections are keyed by their
+environment variable in lower case, without the section's prefix. Secrets
+can also be read from a file named by a key ending in _file.
+*/
+func settingsByKey() map[string]setting {
+	settings := map[string]setting{}
+	var walk func(typ reflect.Type, section, namespace string)
+	walk = func(typ reflect.Type, section, namespace string) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}) {
+				walk(field.Type, strings.ToLower(field.Name), namespace+"."+field.Name)
+				continue
+			}
+			env, _, _ := strings.Cut(field.Tag.Get("env"), ",")
+			if env == "" {
+				continue
+			}
+			key := strings.ToLower(env)
+			if section != "" {
+				key = section + "." + strings.TrimPrefix(key, section+"_")
+			}
+			s := setting{
+				env:       env,
+				key:       key,
+				namespace: namespace + "." + field.Name,
+				typ:       field.Type,
+				secret:    field.Tag.Get("secret") == "true",
+			}
+			settings[key] = s
+			if s.secret {
+				settings[key+"_file"] = setting{env: env + "_FILE", key: key + "_file", namespace: s.namespace, typ: reflect.TypeOf("")}
+			}
+		}
+	}
+	walk(reflect.TypeOf(Settings{}), "", "Settings")
+	return settings
+}
+
+/*
+isConfigFile returns whether path is a YAML or TOML config file, rather
+than a .env file
+*/
+func isConfigFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".toml":
+		return true
+	}
+	return false
+}
+
+/*
+fileValue is a setting in a config file
+*/
+type fileValue struct {
+	key   string
+	line  int
+	value interface{}
+}
+
+/*
+loadConfigFile reads the YAML or TOML config file at path and sets the
+environment variables of its settings, unless they are set already. It
+returns where each environment variable it set came from.
+*/
+func loadConfigFile(path string) (map[string]ConfigError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values []fileValue
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		values, err = parseTOML(data)
+	} else {
+		values, err = parseYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	settings := settingsByKey()
+	locations := map[string]ConfigError{}
+	var errs ConfigErrors
+	for _, v := range values {
+		location := ConfigError{File: path, Line: v.line, Key: v.key}
+		s, ok := settings[v.key]
+		if !ok {
+			location.Message = "unknown setting"
+			errs = append(errs, location)
+			continue
+		}
+		location.Field = s.namespace
+		str, err := settingString(v.value, s.typ)
+		if err != nil {
+			location.Message = err.Error()
+			errs = append(errs, location)
+			continue
+		}
+		if _, set := os.LookupEnv(s.env); set {
+			continue
+		}
+		if err := setFileEnv(s.env, str); err != nil {
+			return nil, err
+		}
+		locations[s.env] = location
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return locations, nil
+}
+
+/*
+settingString converts a value from a config file to the environment
+variable format of a setting of type typ. Lists are joined by spaces.
+*/
+func settingString(value interface{}, typ reflect.Type) (string, error) {
+	if list, ok := value.([]interface{}); ok {
+		if typ.Kind() != reflect.Slice {
+			return "", errors.New("expected a single value, not a list")
+		}
+		items := make([]string, len(list))
+		for i, item := range list {
+			str, err := settingString(item, typ.Elem())
+			if err != nil {
+				return "", err
+			}
+			items[i] = str
+		}
+		return strings.Join(items, " "), nil
+	}
+	if _, ok := value.(map[string]interface{}); ok {
+		return "", errors.New("expected a value, not a table")
+	}
+
+	str := fmt.Sprint(value)
+	switch {
+	case typ == reflect.TypeOf(time.Duration(0)):
+		if _, err := time.ParseDuration(str); err != nil {
+			return "", fmt.Errorf("expected a duration such as 10s, not %q", str)
+		}
+	case typ.Kind() == reflect.Bool:
+		if _, err := strconv.ParseBool(str); err != nil {
+			return "", fmt.Errorf("expected true or false, not %q", str)
+		}
+	case typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Int64:
+		if _, err := strconv.ParseInt(str, 10, 64); err != nil {
+			return "", fmt.Errorf("expected a whole number, not %q", str)
+		}
+	}
+	return str, nil
+}
+
+/*
+parseYAML returns the settings of a YAML config file
+*/
+func parseYAML(data []byte) ([]fileValue, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	var values []fileValue
+	var walk func(node *yaml.Node, prefix string) error
+	walk = func(node *yaml.Node, prefix string) error {
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: expected a mapping of settings", node.Line)
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			// Sections hold settings, other mappings are not settings
+			if value.Kind == yaml.MappingNode && prefix == "" {
+				if err := walk(value, key.Value+"."); err != nil {
+					return err
+				}
+				continue
+			}
+			var v interface{}
+			if err := value.Decode(&v); err != nil {
+				return err
+			}
+			values = append(values, fileValue{key: prefix + key.Value, line: key.Line, value: v})
+		}
+		return nil
+	}
+	return values, walk(doc.Content[0], "")
+}
+
+/*
+parseTOML returns the settings of a TOML config file
+*/
+func parseTOML(data []byte) ([]fileValue, error) {
+	tree := map[string]interface{}{}
+	if err := toml.Unmarshal(data, &tree); err != nil {
+		var decodeErr *toml.DecodeError
+		if errors.As(err, &decodeErr) {
+			line, _ := decodeErr.Position()
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		return nil, err
+	}
+
+	// The decoded tree has no positions, so find the line of every key
+	lines := map[string]int{}
+	p := unstable.Parser{}
+	p.Reset(data)
+	table := ""
+	for p.NextExpression() {
+		expr := p.Expression()
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			table = tomlKey(expr.Key()) + "."
+		case unstable.KeyValue:
+			key := expr.Key()
+			first := key.Node()
+			lines[table+tomlKey(key)] = p.Shape(first.Raw).Start.Line
+		}
+	}
+
+	var values []fileValue
+	var walk func(tree map[string]interface{}, prefix string)
+	walk = func(tree map[string]interface{}, prefix string) {
+		for key, value := range tree {
+			if section, ok := value.(map[string]interface{}); ok && prefix == "" {
+				walk(section, key+".")
+				continue
+			}
+			values = append(values, fileValue{key: prefix + key, line: lines[prefix+key], value: value})
+		}
+	}
+	walk(tree, "")
+	sort.Slice(values, func(i, j int) bool { return values[i].line < values[j].line })
+	return values, nil
+}
+
+/*
+tomlKey joins the parts of a dotted TOML key
+*/
+func tomlKey(it unstable.Iterator) string {
+	var parts []string
+	for it.Next() {
+		parts = append(parts, string(it.Node().Data))
+	}
+	return strings.Join(parts, ".")
+}
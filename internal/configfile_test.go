@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+writeConfig writes a config file, and unsets the environment variables
+it sets when the test ends
+*/
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	// The variables are unset already, LoadConfig must not unset them again
+	// once a later test sets them
+	t.Cleanup(func() { fileEnv = map[string]bool{} })
+	for _, s := range settingsByKey() {
+		if _, set := os.LookupEnv(s.env); !set {
+			env := s.env
+			t.Cleanup(func() { os.Unsetenv(env) })
+		}
+	}
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	path := writeConfig(t, "teleirc.yaml", `
+irc:
+  server: irc.example.com
+  port: 6697
+  use_ssl: true
+  channel: "#bridge"
+  bot_name: teleirc
+  blacklist:
+    - spammer
+    - troll
+  reconnect_delay: 30s
+telegram:
+  teleirc_token: "000000000:AAAAAAaAAa2AaAAaoAAAA-a_aaAAaAaaaAA"
+  chat_id: -100
+  show_join_message: true
+log_level: debug
+`)
+	settings, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "irc.example.com", settings.IRC.Server)
+	assert.Equal(t, 6697, settings.IRC.Port)
+	assert.True(t, settings.IRC.UseSSL)
+	assert.Equal(t, []string{"spammer", "troll"}, settings.IRC.IRCBlacklist)
+	assert.Equal(t, 30*time.Second, settings.IRC.ReconnectDelay)
+	assert.Equal(t, int64(-100), settings.Telegram.ChatID)
+	assert.True(t, settings.Telegram.ShowJoinMessage)
+	assert.Equal(t, "debug", settings.LogLevel)
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	path := writeConfig(t, "teleirc.toml", `
+log_level = "info"
+
+[irc]
+server = "irc.example.com"
+channel = "#bridge"
+bot_name = "teleirc"
+blacklist = ["spammer", "troll"]
+
+[telegram]
+teleirc_token = "000000000:AAAAAAaAAa2AaAAaoAAAA-a_aaAAaAaaaAA"
+chat_id = -100
+`)
+	settings, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "irc.example.com", settings.IRC.Server)
+	assert.Equal(t, "#bridge", settings.IRC.Channel)
+	assert.Equal(t, []string{"spammer", "troll"}, settings.IRC.IRCBlacklist)
+	assert.Equal(t, int64(-100), settings.Telegram.ChatID)
+}
+
+func TestLoadConfigEnvOverridesFile(t *testing.T) {
+	path := writeConfig(t, "teleirc.yaml", `
+irc:
+  server: irc.example.com
+  port: 6697
+  channel: "#bridge"
+  bot_name: teleirc
+telegram:
+  teleirc_token: "000000000:AAAAAAaAAa2AaAAaoAAAA-a_aaAAaAaaaAA"
+  chat_id: -100
+`)
+	t.Setenv("IRC_PORT", "7000")
+	settings, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, 7000, settings.IRC.Port)
+	assert.Equal(t, "irc.example.com", settings.IRC.Server)
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected ConfigError
+	}{
+		{"teleirc.yaml", "irc:\n  server: irc.example.com\n  prot: 6697\n",
+			ConfigError{Line: 3, Key: "irc.prot", Message: "unknown setting"}},
+		{"teleirc.yaml", "irc:\n  port: [1, 2]\n",
+			ConfigError{Field: "Settings.IRC.Port", Line: 2, Key: "irc.port", Message: "expected a single value, not a list"}},
+		{"teleirc.toml", "[irc]\nserver = \"irc.example.com\"\nuse_ssl = \"maybe\"\n",
+			ConfigError{Field: "Settings.IRC.UseSSL", Line: 3, Key: "irc.use_ssl", Message: `expected true or false, not "maybe"`}},
+	}
+	for _, test := range tests {
+		path := writeConfig(t, test.name, test.content)
+		_, err := LoadConfig(path)
+		var errs ConfigErrors
+		if assert.ErrorAs(t, err, &errs, test.content) && assert.Len(t, errs, 1) {
+			test.expected.File = path
+			assert.Equal(t, test.expected, errs[0])
+		}
+	}
+}
+
+func TestLoadConfigValidationLine(t *testing.T) {
+	path := writeConfig(t, "teleirc.yaml", `
+irc:
+  server: irc.example.com
+  port: 70000
+  channel: "#bridge"
+  bot_name: teleirc
+telegram:
+  teleirc_token: "000000000:AAAAAAaAAa2AaAAaoAAAA-a_aaAAaAaaaAA"
+  chat_id: -100
+`)
+	_, err := LoadConfig(path)
+	require.Error(t, err)
+	assert.Equal(t, path+":4: irc.port: Field Settings.IRC.Port failed to validate: 65535 too large.\n", err.Error())
+}
+
+func TestLoadConfigSyntaxError(t *testing.T) {
+	path := writeConfig(t, "teleirc.toml", "[irc]\nserver = \n")
+	_, err := LoadConfig(path)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), path+": line 2:")
+	}
+}
@@ -1,17 +1,19 @@
 package internal
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"strings"
 )
 
 var (
-	logFlags = log.Ldate | log.Ltime
-	info     = log.New(os.Stdout, "INFO: ", logFlags)
-	debug    = log.New(os.Stdout, "DEBUG: ", logFlags)
-	errorLog = log.New(os.Stderr, "ERROR: ", logFlags)
-	warning  = log.New(os.Stderr, "WARNING: ", logFlags)
-	plain    = log.New(os.Stdout, "", 0)
+	plain = log.New(os.Stdout, "", 0)
+	// defaultLogger is used by the zero value of Debug
+	defaultLogger = slog.New(newLogHandler(os.Stdout, os.Stderr, slog.LevelInfo, "text"))
 )
 
 // DebugLogger provides an interface to call the logging functions
@@ -21,38 +23,171 @@ type DebugLogger interface {
 	LogError(f string, v ...any)
 	LogWarning(f string, v ...any)
 	PrintVersion(f string, v ...any)
+	// With returns a logger that adds fields, such as side or chat_id, to
+	// every line. args are key-value pairs, as in slog.Logger.With.
+	With(args ...any) DebugLogger
 }
 
-// Debug contains information about the debug level
+// Calls through DebugLogger are checked by go vet like calls to fmt.Printf
+var _ DebugLogger = Debug{}
+
+/*
+Debug logs through log/slog. The zero value logs text at the info level.
+Debug and info lines go to standard out, warnings and errors to standard
+error. The values of secret settings, such as the bot token, are scrubbed
+from every line.
+*/
 type Debug struct {
-	DebugLevel bool
+	Logger *slog.Logger
+}
+
+/*
+NewLogger returns a logger for LOG_LEVEL and LOG_FORMAT
+*/
+func NewLogger(level, format string) (Debug, error) {
+	return newLogger(os.Stdout, os.Stderr, level, format)
+}
+
+func newLogger(out, errOut io.Writer, level, format string) (Debug, error) {
+	slogLevel, err := ParseLogLevel(level)
+	if err != nil {
+		return Debug{}, err
+	}
+	if format != "text" && format != "json" {
+		return Debug{}, fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+	return Debug{Logger: slog.New(newLogHandler(out, errOut, slogLevel, format))}, nil
+}
+
+/*
+ParseLogLevel returns the slog level of a LOG_LEVEL value: debug, info,
+warn, warning or error
+*/
+func ParseLogLevel(level string) (slog.Level, error) {
+	if strings.EqualFold(level, "warning") {
+		return slog.LevelWarn, nil
+	}
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", level)
+	}
+	return slogLevel, nil
+}
+
+func (d Debug) logger() *slog.Logger {
+	if d.Logger == nil {
+		return defaultLogger
+	}
+	return d.Logger
+}
+
+func (d Debug) log(level slog.Level, f string, v ...any) {
+	logger := d.logger()
+	// Skip formatting lines that are not logged
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+	logger.Log(context.Background(), level, fmt.Sprintf(f, v...))
+}
+
+// With returns a logger that adds fields to every line
+func (d Debug) With(args ...any) DebugLogger {
+	return Debug{Logger: d.logger().With(args...)}
 }
 
 // LogInfo prints info-level messages to standard out
 func (d Debug) LogInfo(f string, v ...any) {
-	info.Printf(f, v...)
+	d.log(slog.LevelInfo, f, v...)
 }
 
 // LogDebug prints debug-level messages to standard out
-func (d Debug) LogDebug(f string, v ...interface{}) {
-	if d.DebugLevel {
-		debug.Printf(f, v...)
-	}
+func (d Debug) LogDebug(f string, v ...any) {
+	d.log(slog.LevelDebug, f, v...)
 }
 
-// LogError prints error-level messages to standard out
-func (d Debug) LogError(f string, v ...interface{}) {
-	errorLog.Printf(f, v...)
+// LogError prints error-level messages to standard error
+func (d Debug) LogError(f string, v ...any) {
+	d.log(slog.LevelError, f, v...)
 }
 
-// LogWarning prints warning-level messages to standard out
-func (d Debug) LogWarning(f string, v ...interface{}) {
-	if d.DebugLevel {
-		warning.Printf(f, v...)
-	}
+// LogWarning prints warning-level messages to standard error
+func (d Debug) LogWarning(f string, v ...any) {
+	d.log(slog.LevelWarn, f, v...)
 }
 
 // PrintVersion prints the TeleIRC version number
-func (d Debug) PrintVersion(f string, v ...interface{}) {
+func (d Debug) PrintVersion(f string, v ...any) {
 	plain.Printf(f, v...)
 }
+
+/*
+logHandler sends warnings and errors to one handler and everything else to
+another, after scrubbing secrets
+*/
+type logHandler struct {
+	out    slog.Handler
+	errOut slog.Handler
+}
+
+func newLogHandler(out, errOut io.Writer, level slog.Level, format string) logHandler {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return logHandler{out: slog.NewJSONHandler(out, opts), errOut: slog.NewJSONHandler(errOut, opts)}
+	}
+	return logHandler{out: slog.NewTextHandler(out, opts), errOut: slog.NewTextHandler(errOut, opts)}
+}
+
+func (h logHandler) handler(level slog.Level) slog.Handler {
+	if level >= slog.LevelWarn {
+		return h.errOut
+	}
+	return h.out
+}
+
+func (h logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler(level).Enabled(ctx, level)
+}
+
+func (h logHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, redact(r.Message), r.PC)
+	r.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.handler(r.Level).Handle(ctx, redacted)
+}
+
+func (h logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return logHandler{out: h.out.WithAttrs(redacted), errOut: h.errOut.WithAttrs(redacted)}
+}
+
+func (h logHandler) WithGroup(name string) slog.Handler {
+	return logHandler{out: h.out.WithGroup(name), errOut: h.errOut.WithGroup(name)}
+}
+
+/*
+redactAttr scrubs secrets from string and error fields
+*/
+func redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redact(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]any, len(group))
+		for i, member := range group {
+			redacted[i] = redactAttr(member)
+		}
+		return slog.Group(attr.Key, redacted...)
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return slog.String(attr.Key, redact(err.Error()))
+		}
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
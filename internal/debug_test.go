@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for _, level := range []string{"debug", "info", "warn", "warning", "error", "INFO"} {
+		_, err := ParseLogLevel(level)
+		assert.NoError(t, err, level)
+	}
+	_, err := ParseLogLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestLoggerLevels(t *testing.T) {
+	var out, errOut bytes.Buffer
+	logger, err := newLogger(&out, &errOut, "info", "text")
+	require.NoError(t, err)
+
+	logger.LogDebug("hidden")
+	logger.LogInfo("shown %d", 1)
+	logger.LogWarning("warned")
+	logger.LogError("failed")
+	assert.NotContains(t, out.String(), "hidden")
+	assert.Contains(t, out.String(), `level=INFO msg="shown 1"`)
+	assert.Contains(t, errOut.String(), "level=WARN msg=warned")
+	assert.Contains(t, errOut.String(), "level=ERROR msg=failed")
+}
+
+func TestLoggerJSONFields(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := newLogger(&out, &out, "debug", "json")
+	require.NoError(t, err)
+
+	logger.With("side", "telegram").With("chat_id", int64(-100)).LogDebug("sent %q", "hello")
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "DEBUG", line["level"])
+	assert.Equal(t, `sent "hello"`, line["msg"])
+	assert.Equal(t, "telegram", line["side"])
+	assert.Equal(t, float64(-100), line["chat_id"])
+}
+
+func TestLoggerRedacts(t *testing.T) {
+	t.Cleanup(func() { setSecrets(nil) })
+	setSecrets([]string{"hunter2"})
+	var out bytes.Buffer
+	logger, err := newLogger(&out, &out, "info", "text")
+	require.NoError(t, err)
+
+	logger.With("password", "hunter2").LogInfo("logging in with %s", "hunter2")
+	logger.Logger.Info("failed", "err", errors.New("bad password hunter2"))
+	assert.NotContains(t, out.String(), "hunter2")
+	assert.Contains(t, out.String(), "password=***")
+}
+
+func TestNewLoggerInvalid(t *testing.T) {
+	_, err := NewLogger("info", "xml")
+	assert.Error(t, err)
+	_, err = NewLogger("loud", "text")
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package irc
+
+import (
+	"strings"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal/chanlog"
+)
+
+/*
+channelLogLine returns the line of the channel log for e, if it belongs in
+the log of channel. The bot is only in the bridged channel, so every QUIT
+and NICK it sees is about someone in it.
+*/
+func channelLogLine(e girc.Event, channel string) (chanlog.Line, bool) {
+	if e.Source == nil {
+		return chanlog.Line{}, false
+	}
+	l := chanlog.Line{
+		Time:    e.Timestamp,
+		Channel: channel,
+		Nick:    e.Source.Name,
+		Host:    e.Source.Ident + "@" + e.Source.Host,
+	}
+	// param returns the ith parameter, or "" if there are not that many
+	param := func(i int) string {
+		if i < len(e.Params) {
+			return e.Params[i]
+		}
+		return ""
+	}
+	if e.Command != girc.QUIT && e.Command != girc.NICK && !strings.EqualFold(param(0), channel) {
+		return chanlog.Line{}, false
+	}
+
+	switch e.Command {
+	case girc.PRIVMSG:
+		ok, ctcp := e.IsCTCP()
+		switch {
+		case e.IsAction():
+			l.Kind, l.Text = chanlog.Action, ctcp.Text
+		case ok:
+			// Other CTCP requests are not shown in the channel
+			return chanlog.Line{}, false
+		default:
+			l.Kind, l.Text = chanlog.Message, param(1)
+		}
+	case girc.JOIN:
+		l.Kind = chanlog.Join
+	case girc.PART:
+		l.Kind, l.Text = chanlog.Part, param(1)
+	case girc.QUIT:
+		l.Kind, l.Text = chanlog.Quit, param(0)
+	case girc.KICK:
+		l.Kind, l.Target, l.Text = chanlog.Kick, param(1), param(2)
+	case girc.NICK:
+		l.Kind, l.Target = chanlog.Nick, param(0)
+	case girc.TOPIC:
+		l.Kind, l.Text = chanlog.Topic, param(1)
+	default:
+		return chanlog.Line{}, false
+	}
+	return l, true
+}
+
+/*
+addChannelLogHandler logs the events getHandlerMapping handles to the
+channel log, if there is one. Played back history is left out, so the log
+stays in order.
+*/
+func (c Client) addChannelLogHandler(eventType string) {
+	if c.ChannelLog == nil {
+		return
+	}
+	c.AddHandler(eventType, func(gc *girc.Client, e girc.Event) {
+		if c.InPlayback(e) {
+			return
+		}
+		if l, ok := channelLogLine(e, c.IRCSettings().Channel); ok {
+			c.writeChannelLog(l)
+		}
+	})
+}
+
+/*
+writeChannelLog writes l to the channel log, if there is one
+*/
+func (c Client) writeChannelLog(l chanlog.Line) {
+	if err := c.ChannelLog.Write(l); err != nil {
+		c.logger.LogError("Could not write the channel log: %s", err)
+	}
+}
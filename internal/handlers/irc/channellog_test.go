@@ -0,0 +1,109 @@
+package irc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/chanlog"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+readChannelLog returns the contents of every log file in dir
+*/
+func readChannelLog(t *testing.T, dir string) string {
+	t.Helper()
+	var log strings.Builder
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		log.Write(content)
+		return err
+	})
+	require.NoError(t, err)
+	return log.String()
+}
+
+func TestChannelLogLine(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want chanlog.Line
+	}{
+		{":alice!al@example.com PRIVMSG #Bridge :hello", chanlog.Line{Kind: chanlog.Message, Text: "hello"}},
+		{":alice!al@example.com PRIVMSG #bridge :\x01ACTION waves\x01", chanlog.Line{Kind: chanlog.Action, Text: "waves"}},
+		{":alice!al@example.com JOIN #bridge", chanlog.Line{Kind: chanlog.Join}},
+		{":alice!al@example.com PART #bridge :bye", chanlog.Line{Kind: chanlog.Part, Text: "bye"}},
+		{":alice!al@example.com PART #bridge", chanlog.Line{Kind: chanlog.Part}},
+		{":alice!al@example.com QUIT :Ping timeout", chanlog.Line{Kind: chanlog.Quit, Text: "Ping timeout"}},
+		{":alice!al@example.com KICK #bridge mallory :spam", chanlog.Line{Kind: chanlog.Kick, Target: "mallory", Text: "spam"}},
+		{":alice!al@example.com NICK alice_", chanlog.Line{Kind: chanlog.Nick, Target: "alice_"}},
+		{":alice!al@example.com TOPIC #bridge :Deploy on Friday", chanlog.Line{Kind: chanlog.Topic, Text: "Deploy on Friday"}},
+		{":alice!al@example.com TOPIC #bridge :", chanlog.Line{Kind: chanlog.Topic}},
+	}
+	for _, c := range cases {
+		e := girc.ParseEvent(c.raw)
+		got, ok := channelLogLine(*e, "#bridge")
+		if assert.True(t, ok, c.raw) {
+			c.want.Time = e.Timestamp
+			c.want.Channel = "#bridge"
+			c.want.Nick = "alice"
+			c.want.Host = "al@example.com"
+			assert.Equal(t, c.want, got, c.raw)
+		}
+	}
+
+	for _, raw := range []string{
+		":alice!al@example.com PRIVMSG #other :hello",
+		":alice!al@example.com PRIVMSG teleirc :hello",
+		":alice!al@example.com PRIVMSG #bridge :\x01VERSION\x01",
+		":alice!al@example.com JOIN #other",
+		":server.example.com INVITE teleirc #bridge",
+		"PING :token",
+	} {
+		_, ok := channelLogLine(*girc.ParseEvent(raw), "#bridge")
+		assert.False(t, ok, raw)
+	}
+}
+
+func TestChannelLogBatch(t *testing.T) {
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+	// Batches need message tags
+	s.ChatHistory = true
+
+	dir := t.TempDir()
+	channelLog, err := chanlog.New(dir, chanlog.Irssi)
+	require.NoError(t, err)
+	defer channelLog.Close()
+	client := NewClient(newTestSettings(s), &internal.TelegramSettings{}, internal.Debug{})
+	client.ChannelLog = channelLog
+	errChan := make(chan error, 1)
+	go client.StartBot(errChan, func(string) {})
+	defer func() {
+		client.Close()
+		assert.NoError(t, waitForError(t, errChan))
+	}()
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	require.True(t, ok, "client should join the channel")
+
+	// Only played back history is left out, other batches happen now
+	s.Join("alice", "#bridge")
+	s.Send("BATCH +ns netsplit irc1.example.com irc2.example.com")
+	s.Send("@batch=ns :alice!a@example.com QUIT :irc1.example.com irc2.example.com")
+	s.Send("BATCH -ns")
+	s.Join("bob", "#bridge")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(readChannelLog(t, dir), "bob")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, readChannelLog(t, dir), "irc1.example.com irc2.example.com")
+}
@@ -0,0 +1,331 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal/metrics"
+)
+
+// chatHistoryCap is the IRCv3 capability to fetch the messages the bot missed
+const chatHistoryCap = "draft/chathistory"
+
+// seenSize is how many msgids are remembered, so no message is relayed twice
+const seenSize = 1000
+
+// delayedFmt marks the messages played back after a reconnect with their time
+const delayedFmt = "[delayed %s] %s"
+
+// serverTimeFormat is the format of timestamps in CHATHISTORY references
+const serverTimeFormat = "2006-01-02T15:04:05.000Z"
+
+/*
+marker is the last message the bot saw in a channel
+*/
+type marker struct {
+	msgid string
+	at    time.Time
+}
+
+/*
+String returns the marker as a CHATHISTORY reference, preferring the msgid
+*/
+func (m marker) String() string {
+	if m.msgid != "" {
+		return "msgid=" + m.msgid
+	}
+	return "timestamp=" + m.at.UTC().Format(serverTimeFormat)
+}
+
+/*
+playbackRequest is a CHATHISTORY request waiting for its batch
+*/
+type playbackRequest struct {
+	after marker
+	limit int
+}
+
+/*
+playbackBatch collects the messages of a chathistory batch
+*/
+type playbackBatch struct {
+	playbackRequest
+	channel string
+	events  []girc.Event
+}
+
+/*
+playbackState remembers the last message seen in each channel, so the
+messages sent while the bot was disconnected can be fetched once it
+rejoins, and the msgids already relayed, so none is relayed twice
+*/
+type playbackState struct {
+	mu sync.Mutex
+	// last maps lower case channel names to the last message seen in them
+	last map[string]marker
+	// seen holds the last seenSize msgids, in the order they were seen
+	seen  map[string]bool
+	order []string
+	// requests maps lower case channel names to the request sent for them
+	requests map[string]playbackRequest
+	// batches maps the references of open batches to their messages
+	batches map[string]*playbackBatch
+}
+
+func newPlaybackState() *playbackState {
+	return &playbackState{
+		last:     map[string]marker{},
+		seen:     map[string]bool{},
+		requests: map[string]playbackRequest{},
+		batches:  map[string]*playbackBatch{},
+	}
+}
+
+/*
+reset forgets the requests and batches of the last connection
+*/
+func (p *playbackState) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = map[string]playbackRequest{}
+	p.batches = map[string]*playbackBatch{}
+}
+
+/*
+see records e as the last message seen in channel, and returns false if
+its msgid was seen before. Events without a msgid or server time do not
+move the marker, as the server could not find them.
+*/
+func (p *playbackState) see(channel string, e girc.Event) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	msgid, _ := e.Tags.Get("msgid")
+	if msgid != "" {
+		if p.seen[msgid] {
+			return false
+		}
+		p.seen[msgid] = true
+		p.order = append(p.order, msgid)
+		if len(p.order) > seenSize {
+			delete(p.seen, p.order[0])
+			p.order = p.order[1:]
+		}
+	}
+	if _, ok := e.Tags.Get("time"); msgid == "" && !ok {
+		return true
+	}
+	key := strings.ToLower(channel)
+	if last, ok := p.last[key]; !ok || !e.Timestamp.Before(last.at) {
+		p.last[key] = marker{msgid: msgid, at: e.Timestamp}
+	}
+	return true
+}
+
+/*
+seenBefore returns whether the msgid of e was seen before
+*/
+func (p *playbackState) seenBefore(e girc.Event) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	msgid, _ := e.Tags.Get("msgid")
+	return msgid != "" && p.seen[msgid]
+}
+
+/*
+joined is called when the bot joins channel. It returns the last message
+seen there to fetch what came after it, or records the join as the point
+to start from if the bot never saw anything in channel.
+*/
+func (p *playbackState) joined(channel string, e girc.Event) (marker, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := strings.ToLower(channel)
+	if last, ok := p.last[key]; ok {
+		return last, true
+	}
+	if _, ok := e.Tags.Get("time"); ok {
+		p.last[key] = marker{at: e.Timestamp}
+	}
+	return marker{}, false
+}
+
+/*
+request records a CHATHISTORY request sent for channel
+*/
+func (p *playbackState) request(channel string, r playbackRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests[strings.ToLower(channel)] = r
+}
+
+/*
+open starts collecting the batch ref for channel, if the bot asked for it
+*/
+func (p *playbackState) open(ref, channel string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := strings.ToLower(channel)
+	if r, ok := p.requests[key]; ok {
+		delete(p.requests, key)
+		p.batches[ref] = &playbackBatch{playbackRequest: r, channel: channel}
+	}
+}
+
+/*
+add adds e to the open batch ref, and returns false if there is no such
+batch
+*/
+func (p *playbackState) add(ref string, e girc.Event) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.batches[ref]
+	if ok {
+		b.events = append(b.events, e)
+	}
+	return ok
+}
+
+/*
+inBatch returns whether ref is an open chathistory batch the bot asked for
+*/
+func (p *playbackState) inBatch(ref string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.batches[ref]
+	return ok
+}
+
+/*
+close ends the batch ref and returns it, or nil if there is no such batch
+*/
+func (p *playbackState) close(ref string) *playbackBatch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := p.batches[ref]
+	delete(p.batches, ref)
+	return b
+}
+
+/*
+missed returns the messages of b to play back: the last b.limit messages
+from others the bot did not see before
+*/
+func (p *playbackState) missed(b *playbackBatch, nick string) []girc.Event {
+	var missed []girc.Event
+	for _, e := range b.events {
+		if e.Command != girc.PRIVMSG || e.Source == nil || len(e.Params) < 2 ||
+			strings.EqualFold(e.Source.Name, nick) || p.seenBefore(e) {
+			continue
+		}
+		// Other CTCP requests are not shown in the channel
+		if ok, _ := e.IsCTCP(); ok && !e.IsAction() {
+			continue
+		}
+		if _, ok := e.Tags.Get("msgid"); !ok && !e.Timestamp.After(b.after.at) {
+			continue
+		}
+		missed = append(missed, e)
+	}
+	return missed[max(0, len(missed)-b.limit):]
+}
+
+/*
+addPlaybackHandlers adds the handlers that remember the last message seen
+in the channel, and after the bot rejoins it, fetch the messages sent
+while it was away with CHATHISTORY and relay up to IRC_CHATHISTORY_LIMIT
+of them to Telegram, marked as delayed
+*/
+func (c Client) addPlaybackHandlers() {
+	c.AddHandler(girc.CONNECTED, func(gc *girc.Client, e girc.Event) {
+		c.playback.reset()
+	})
+	c.AddHandler(girc.PRIVMSG, func(gc *girc.Client, e girc.Event) {
+		if !e.IsFromChannel() || !strings.EqualFold(e.Params[0], c.IRCSettings().Channel) {
+			return
+		}
+		if ref, ok := e.Tags.Get("batch"); ok && c.playback.add(ref, e) {
+			return
+		}
+		c.playback.see(e.Params[0], e)
+	})
+	c.AddHandler(girc.JOIN, func(gc *girc.Client, e girc.Event) {
+		limit := c.IRCSettings().ChatHistoryLimit
+		if limit == 0 || e.Source == nil || e.Source.ID() != gc.GetID() || len(e.Params) == 0 ||
+			!strings.EqualFold(e.Params[0], c.IRCSettings().Channel) {
+			return
+		}
+		after, ok := c.playback.joined(e.Params[0], e)
+		if !ok || !gc.HasCapability(chatHistoryCap) {
+			return
+		}
+		if serverLimit, ok := gc.GetServerOptionInt("CHATHISTORY"); ok && serverLimit > 0 {
+			limit = min(limit, serverLimit)
+		}
+		c.playback.request(e.Params[0], playbackRequest{after: after, limit: limit})
+		c.logger.With("event", "chathistory", "channel", e.Params[0]).
+			LogDebug("Asking for at most %d messages after %s", limit, after)
+		gc.Cmd.SendRawf("CHATHISTORY LATEST %s %s %d", e.Params[0], after, limit)
+	})
+	c.AddHandler("BATCH", func(gc *girc.Client, e girc.Event) {
+		if len(e.Params) == 0 || len(e.Params[0]) < 2 {
+			return
+		}
+		ref := e.Params[0][1:]
+		switch e.Params[0][0] {
+		case '+':
+			if len(e.Params) > 2 && e.Params[1] == "chathistory" {
+				c.playback.open(ref, e.Params[2])
+			}
+		case '-':
+			if b := c.playback.close(ref); b != nil {
+				c.replay(gc, b)
+			}
+		}
+	})
+	c.AddHandler("FAIL", func(gc *girc.Client, e girc.Event) {
+		if len(e.Params) > 0 && e.Params[0] == "CHATHISTORY" {
+			c.logger.With("event", "chathistory").LogError("Could not fetch missed messages: %s", e.Last())
+		}
+	})
+}
+
+/*
+InPlayback returns whether e is part of the history the bot asked for after
+rejoining, which the playback handlers relay instead of the others
+*/
+func (c Client) InPlayback(e girc.Event) bool {
+	ref, ok := e.Tags.Get("batch")
+	return ok && c.playback.inBatch(ref)
+}
+
+/*
+replay relays the messages of a chathistory batch that the bot missed to
+Telegram, skipping the blacklisted users and the messages not meant to be
+forwarded
+*/
+func (c Client) replay(gc *girc.Client, b *playbackBatch) {
+	missed := c.playback.missed(b, gc.GetNick())
+	relayed := 0
+	for _, e := range missed {
+		if !c.playback.see(b.channel, e) {
+			continue
+		}
+		if checkBlacklist(c, e.Source.Name) {
+			metrics.MessagesDropped.Inc(metrics.IRCToTelegram, metrics.Blacklist)
+			continue
+		}
+		formatted, kind, ok := formatMessage(c, e)
+		if !ok {
+			continue
+		}
+		relay(c, e, kind, fmt.Sprintf(delayedFmt, e.Timestamp.Format("15:04"), formatted))
+		relayed++
+	}
+	if relayed > 0 {
+		c.logger.With("event", "chathistory", "channel", b.channel).
+			LogInfo("Relayed %d messages missed while disconnected", relayed)
+	}
+}
@@ -0,0 +1,144 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/chanlog"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarker(t *testing.T) {
+	at := time.Date(2024, 5, 10, 9, 5, 3, 250000000, time.FixedZone("CEST", 2*60*60))
+	assert.Equal(t, "msgid=abc", marker{msgid: "abc", at: at}.String())
+	assert.Equal(t, "timestamp=2024-05-10T07:05:03.250Z", marker{at: at}.String())
+}
+
+func TestPlaybackState(t *testing.T) {
+	p := newPlaybackState()
+	at := time.Now()
+	message := func(raw string, at time.Time) girc.Event {
+		e := girc.ParseEvent(raw)
+		e.Timestamp = at
+		return *e
+	}
+
+	// Nothing to catch up on the first time
+	_, ok := p.joined("#bridge", message("@time=2024-05-10T07:05:03.250Z :teleirc!t@h JOIN #bridge", at))
+	assert.False(t, ok)
+	assert.True(t, p.see("#Bridge", message("@msgid=1;time=x :alice!a@h PRIVMSG #bridge :one", at)))
+	assert.False(t, p.see("#bridge", message("@msgid=1;time=x :alice!a@h PRIVMSG #bridge :one", at)), "seen twice")
+	after, ok := p.joined("#bridge", message(":teleirc!t@h JOIN #bridge", at))
+	assert.True(t, ok)
+	assert.Equal(t, marker{msgid: "1", at: at}, after)
+
+	// Only batches the bot asked for are collected
+	p.open("other", "#bridge")
+	assert.False(t, p.add("other", message(":alice!a@h PRIVMSG #bridge :two", at)))
+	p.request("#bridge", playbackRequest{after: after, limit: 2})
+	p.open("ref", "#BRIDGE")
+	for _, raw := range []string{
+		"@msgid=1 :alice!a@h PRIVMSG #bridge :one",
+		"@msgid=2 :teleirc!t@h PRIVMSG #bridge :from telegram",
+		"@msgid=3 :alice!a@h PRIVMSG #bridge :\x01VERSION\x01",
+		"@msgid=4 :alice!a@h PRIVMSG #bridge :two",
+		"@msgid=5 :alice!a@h NOTICE #bridge :notice",
+		"@msgid=6 :alice!a@h PRIVMSG #bridge :three",
+		"@msgid=7 :alice!a@h PRIVMSG #bridge :\x01ACTION four\x01",
+	} {
+		assert.True(t, p.add("ref", message(raw, at)))
+	}
+	// Without a msgid, only messages after the marker count
+	assert.True(t, p.add("ref", message(":alice!a@h PRIVMSG #bridge :old", at)))
+	b := p.close("ref")
+	require.NotNil(t, b)
+	assert.Nil(t, p.close("ref"))
+	assert.Equal(t, "#BRIDGE", b.channel)
+
+	var texts []string
+	for _, e := range p.missed(b, "TeleIRC") {
+		texts = append(texts, e.Last())
+	}
+	assert.Equal(t, []string{"three", "\x01ACTION four\x01"}, texts, "the last 2 messages from others")
+}
+
+func TestChatHistory(t *testing.T) {
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+	s.ChatHistory = true
+
+	settings := newTestSettings(s)
+	settings.ChatHistoryLimit = 2
+	settings.ReconnectDelay = 100 * time.Millisecond
+	client := NewClient(settings, &internal.TelegramSettings{}, internal.Debug{})
+	dir := t.TempDir()
+	client.ChannelLog, err = chanlog.New(dir, chanlog.Irssi)
+	require.NoError(t, err)
+	toTg := make(chan string, 10)
+	errChan := make(chan error, 1)
+	go client.StartBot(errChan, func(msg string) { toTg <- msg })
+	defer func() {
+		client.Close()
+		assert.NoError(t, waitForError(t, errChan))
+	}()
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	require.True(t, ok, "client should join the channel")
+	receive := func() string {
+		t.Helper()
+		select {
+		case msg := <-toTg:
+			return msg
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "message not relayed")
+			return ""
+		}
+	}
+
+	s.Join("alice", "#bridge")
+	s.Say("alice", "#bridge", "one")
+	assert.Equal(t, "alice one", receive())
+
+	// The bot misses messages on a dead connection
+	s.Stall()
+	client.SendMessage("from telegram")
+	_, ok = s.WaitForCommand(girc.PRIVMSG, "#bridge", 5*time.Second)
+	require.True(t, ok)
+	s.Say("alice", "#bridge", "two")
+	s.Say("alice", "#bridge", "three")
+	s.Action("alice", "#bridge", "waves")
+	s.Disconnect()
+
+	// and gets the last 2 after reconnecting
+	assert.Regexp(t, `^\[delayed \d\d:\d\d\] alice three$`, receive())
+	assert.Regexp(t, `^\[delayed \d\d:\d\d\] \* alice waves$`, receive())
+	assert.Equal(t, 2, countJoins(s, "#bridge"))
+	chathistory, ok := s.WaitForCommand("CHATHISTORY", "LATEST", 5*time.Second)
+	require.True(t, ok)
+	assert.Equal(t, []string{"LATEST", "#bridge", "msgid=fake-3", "2"}, chathistory.Params)
+
+	s.Say("alice", "#bridge", "four")
+	assert.Equal(t, "alice four", receive())
+	assert.Eventually(t, func() bool {
+		return strings.Contains(readChannelLog(t, dir), "four")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotContains(t, readChannelLog(t, dir), "three", "played back history is not logged")
+
+	// Nothing is relayed twice
+	s.Disconnect()
+	assert.Eventually(t, func() bool { return countJoins(s, "#bridge") == 3 }, 5*time.Second, 10*time.Millisecond)
+	_, ok = s.WaitFor(func(e girc.Event) bool {
+		return e.Command == "CHATHISTORY" && len(e.Params) > 2 && e.Params[2] == "msgid=fake-9"
+	}, 5*time.Second)
+	require.True(t, ok, "client should ask for the messages after the last one")
+	select {
+	case msg := <-toTg:
+		assert.Fail(t, "message relayed twice", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
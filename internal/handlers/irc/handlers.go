@@ -6,6 +6,9 @@ import (
 	"strings"
 
 	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal/archive"
+	"github.com/ritlug/teleirc/internal/history"
+	"github.com/ritlug/teleirc/internal/metrics"
 )
 
 const (
@@ -70,6 +73,24 @@ func shouldSendLeave(c ClientInterface, toCheck string) bool {
 	return false
 }
 
+/*
+relay sends a message about an IRC event of the given kind to Telegram,
+and archives the event
+*/
+func relay(c ClientInterface, e girc.Event, kind, msg string) {
+	metrics.MessagesRelayed.Inc(metrics.IRCToTelegram, kind)
+	event := archive.Event{Time: e.Timestamp, Side: archive.IRC, Kind: kind, Text: msg}
+	if e.IsFromChannel() {
+		event.Chat = e.Params[0]
+	}
+	if e.Source != nil {
+		event.SenderID = e.Source.String()
+		event.SenderName = e.Source.Name
+	}
+	c.RecordEvent(event)
+	c.SendToTg(msg)
+}
+
 func hasNoForwardPrefix(c ClientInterface, toCheck string) bool {
 	noForwardPrefix := c.IRCSettings().NoForwardPrefix
 
@@ -90,58 +111,100 @@ so that the specified channel is joined after the server connection is establish
 func connectHandler(c ClientInterface) func(*girc.Client, girc.Event) {
 	return func(gc *girc.Client, e girc.Event) {
 		c.Logger().LogDebug("connectHandler triggered")
-		if c.IRCSettings().ChannelKey != "" {
-			c.JoinKey(c.IRCSettings().Channel, c.IRCSettings().ChannelKey)
-		} else {
-			c.Join(c.IRCSettings().Channel)
+		metrics.Connected.Set(1, metrics.IRC)
+		if usesNickServ(c.IRCSettings()) {
+			// The channel is joined once the bot is identified, see nickserv.go
+			return
 		}
+		joinChannel(c)
+	}
+}
+
+/*
+joinChannel joins the IRC channel specified in the settings
+*/
+func joinChannel(c ClientInterface) {
+	if c.IRCSettings().ChannelKey != "" {
+		c.JoinKey(c.IRCSettings().Channel, c.IRCSettings().ChannelKey)
+	} else {
+		c.Join(c.IRCSettings().Channel)
 	}
 }
 
 func disconnectHandler(c ClientInterface) func(*girc.Client, girc.Event) {
 	return func(gc *girc.Client, e girc.Event) {
 		c.Logger().LogDebug("disconnectHandler triggered")
+		metrics.Connected.Set(0, metrics.IRC)
 		if c.TgSettings().ShowDisconnectMessage {
-			c.SendToTg("Lost connection to '" + c.IRCSettings().Channel + "' on '" + c.IRCSettings().Server + "'")
+			// The server the bot was connected to, which may be any of IRC_SERVERS
+			relay(c, e, "disconnect", "Lost connection to '"+c.IRCSettings().Channel+"' on '"+gc.Config.Server+"'")
 		}
 	}
 }
 
+// colorStripper matches mIRC formatting codes
+var colorStripper = regexp.MustCompile(`[\x02\x1F\x0F\x16]|\x03(\d\d?(,\d\d?)?)?`)
+
+/*
+formatMessage formats a PRIVMSG sent to the channel for Telegram, and
+returns it with its kind. It returns false if the sender did not want it
+forwarded.
+*/
+func formatMessage(c ClientInterface, e girc.Event) (string, string, bool) {
+	if hasNoForwardPrefix(c, e.Params[1]) {
+		metrics.MessagesDropped.Inc(metrics.IRCToTelegram, metrics.NoForwardPrefix)
+		return "", "", false // sender didn't want this forwarded
+	}
+
+	formatted := ""
+	kind := "text"
+	if e.IsAction() {
+		msg := e.Last()
+		// Strips out ACTION word from text
+		formatted = "* " + e.Source.Name + msg[7:len(msg)-1]
+		kind = "action"
+	} else {
+		formatted = c.IRCSettings().Prefix + e.Source.Name + c.IRCSettings().Suffix + " " + e.Params[1]
+	}
+
+	// Strip of mIRC formatting
+	return colorStripper.ReplaceAllString(formatted, ""), kind, true
+}
+
 /*
 messageHandler handles the PRIVMSG IRC event, which entails both private
-and channel messages. However, it only cares about channel messages
+and channel messages. It relays channel messages, and answers !history
+from either
 */
 func messageHandler(c ClientInterface) func(*girc.Client, girc.Event) {
-	var colorStripper = regexp.MustCompile(`[\x02\x1F\x0F\x16]|\x03(\d\d?(,\d\d?)?)?`)
-	var ircChannel = c.IRCSettings().Channel
-
 	return func(gc *girc.Client, e girc.Event) {
 		c.Logger().LogDebug("messageHandler triggered")
 
+		// Played back history is relayed with a delay, see chathistory.go
+		if c.InPlayback(e) {
+			return
+		}
+
 		// Only send if user is not in blacklist ...
 		if !(checkBlacklist(c, e.Source.Name)) {
+			// !history is answered in private, in the channel or not
+			if n, ok := history.ParseCommand(e.Last(), historyCommand, history.DefaultLines); ok &&
+				c.SendHistory(e.Source.Name, n) {
+				return
+			}
 			// ... and if the channel matches. Array index is safe because IsFromChannel
 			// itself does it this way.
-			if e.IsFromChannel() && e.Params[0] == ircChannel {
-				formatted := ""
-				if e.IsAction() {
-					msg := e.Last()
-					// Strips out ACTION word from text
-					formatted = "* " + e.Source.Name + msg[7:len(msg)-1]
-				} else {
-					formatted = c.IRCSettings().Prefix + e.Source.Name + c.IRCSettings().Suffix + " " + e.Params[1]
-				}
-
-				if hasNoForwardPrefix(c, e.Params[1]) {
-					return // sender didn't want this forwarded
+			if e.IsFromChannel() && e.Params[0] == c.IRCSettings().Channel {
+				formatted, kind, ok := formatMessage(c, e)
+				if !ok {
+					return
 				}
-
-				// Strip of mIRC formatting
-				formatted = colorStripper.ReplaceAllString(formatted, "")
-
-				c.Logger().LogDebug("sending message to tg: %s", formatted)
-				c.SendToTg(formatted)
+				c.Logger().With("event", "message", "channel", e.Params[0], "nick", e.Source.Name).
+					LogDebug("sending message to tg: %s", formatted)
+				relay(c, e, kind, formatted)
 			}
+		} else {
+			metrics.MessagesDropped.Inc(metrics.IRCToTelegram, metrics.Blacklist)
 		}
 	}
 }
@@ -150,7 +213,7 @@ func joinHandler(c ClientInterface) func(*girc.Client, girc.Event) {
 	return func(gc *girc.Client, e girc.Event) {
 		c.Logger().LogDebug("joinHandler triggered")
 		if (e.Source != nil) && shouldSendJoin(c, e.Source.Name) {
-			c.SendToTg(fmt.Sprintf(joinFmt, e.Source.Name))
+			relay(c, e, "join", fmt.Sprintf(joinFmt, e.Source.Name))
 		}
 	}
 }
@@ -159,7 +222,7 @@ func partHandler(c ClientInterface) func(*girc.Client, girc.Event) {
 	return func(gc *girc.Client, e girc.Event) {
 		c.Logger().LogDebug("partHandler triggered")
 		if (e.Source != nil) && shouldSendLeave(c, e.Source.Name) {
-			c.SendToTg(fmt.Sprintf(partFmt, e.Source.Name))
+			relay(c, e, "part", fmt.Sprintf(partFmt, e.Source.Name))
 		}
 	}
 }
@@ -173,9 +236,9 @@ func topicHandler(c ClientInterface) func(*girc.Client, girc.Event) {
 			// e.Params[1] is the new topic.  We should assume that
 			// this may or may not appear as its possible to clear a topic.
 			if len(e.Params) <= 1 {
-				c.SendToTg(fmt.Sprintf(topicClearedFmt, e.Source.Name))
+				relay(c, e, "topic", fmt.Sprintf(topicClearedFmt, e.Source.Name))
 			} else {
-				c.SendToTg(fmt.Sprintf(topicChangeFmt, e.Source.Name, e.Params[1]))
+				relay(c, e, "topic", fmt.Sprintf(topicChangeFmt, e.Source.Name, e.Params[1]))
 			}
 		}
 	}
@@ -185,7 +248,7 @@ func quitHandler(c ClientInterface) func(*girc.Client, girc.Event) {
 	return func(gc *girc.Client, e girc.Event) {
 		c.Logger().LogDebug("quitHandler triggered")
 		if (e.Source != nil) && shouldSendLeave(c, e.Source.Name) {
-			c.SendToTg(fmt.Sprintf(quitFmt, e.Source.Name, e.Params[0]))
+			relay(c, e, "quit", fmt.Sprintf(quitFmt, e.Source.Name, e.Params[0]))
 		}
 	}
 }
@@ -204,17 +267,16 @@ func kickHandler(c ClientInterface) func(*girc.Client, girc.Event) {
 			} else {
 				reason = e.Last()
 			}
-			c.SendToTg(fmt.Sprintf(kickFmt, e.Source.Name, e.Params[1], e.Params[0], reason))
+			relay(c, e, "kick", fmt.Sprintf(kickFmt, e.Source.Name, e.Params[1], e.Params[0], reason))
 		}
 	}
 }
 
 func inviteHandler(c ClientInterface) func(*girc.Client, girc.Event) {
-	ircChannel := c.IRCSettings().Channel
 	return func(gc *girc.Client, e girc.Event) {
 		c.Logger().LogDebug("inviteHandler triggered")
-		if len(e.Params) == 2 && e.Params[1] == ircChannel {
-			connectHandler(c)(gc, e)
+		if len(e.Params) == 2 && e.Params[1] == c.IRCSettings().Channel {
+			joinChannel(c)
 		}
 	}
 }
@@ -233,7 +295,7 @@ func nickHandler(c ClientInterface) func(*girc.Client, girc.Event) {
 			} else {
 				newName = e.Params[0]
 			}
-			c.SendToTg(fmt.Sprintf(nickFmt, e.Source.Name, newName))
+			relay(c, e, "nick", fmt.Sprintf(nickFmt, e.Source.Name, newName))
 		}
 	}
 }
@@ -7,6 +7,8 @@ import (
 	gomock "github.com/golang/mock/gomock"
 	"github.com/lrstanley/girc"
 	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/archive"
+	"github.com/ritlug/teleirc/internal/history"
 )
 
 func TestJoinHandler_On(t *testing.T) {
@@ -31,6 +33,9 @@ func TestJoinHandler_On(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("* TEST_NAME joins"))
@@ -66,6 +71,9 @@ func TestJoinHandlerWithAllowList_On(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("* TEST_NAME joins"))
@@ -137,6 +145,9 @@ func TestJoinHandlerWithAllowList_Off(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq(fmt.Sprintf("* %s joins", name)))
@@ -171,6 +182,9 @@ func TestPartHandler_On(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("* TEST_NAME parts"))
@@ -206,6 +220,9 @@ func TestPartHandlerWithAllowList_On(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("* TEST_NAME parts"))
@@ -278,6 +295,9 @@ func TestPartHandlerWithAllowList_Off(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq(fmt.Sprintf("* %s parts", name)))
@@ -312,6 +332,9 @@ func TestQuitHandler_On(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("* TEST_NAME quit (TEST_REASON)"))
@@ -350,6 +373,9 @@ func TestQuitHandlerWithAllowList_On(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("* TEST_NAME quit (TEST_REASON)"))
@@ -428,6 +454,9 @@ func TestQuitHandlerWithAllowList_Off(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq(fmt.Sprintf("* %s quit (TEST_REASON)", name)))
@@ -465,6 +494,9 @@ func TestKickHandler_On(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("* TEST_NAME kicked TEST_KICKEDNAME from TEST_GROUP: TEST_REASON"))
@@ -535,6 +567,9 @@ func TestKickHandlerNoReason(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		// SendToTg(gomock.Eq("* TEST_NAME kicked TEST_KICKEDNAME from TEST_GROUP: TEST_KICKEDNAME"))
@@ -574,6 +609,9 @@ func TestTopicHandler_On(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("* TEST_NAME changed topic to: NEW TOPIC!"))
@@ -651,6 +689,9 @@ func TestTopicHandlerCleared(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("* TEST_NAME removed topic"))
@@ -688,6 +729,9 @@ func TestNickHandler_On(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("* TEST_NAME is now known as: CHANGED_NAME"))
@@ -763,6 +807,9 @@ func TestNickHandlerNoNick(t *testing.T) {
 		EXPECT().
 		TgSettings().
 		Return(&tgSettings)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("* TEST_NAME is now known as: Unspecified Name"))
@@ -839,6 +886,103 @@ func TestConnectHandlerNoKey(t *testing.T) {
 	myHandler(&girc.Client{}, girc.Event{})
 }
 
+func TestConnectHandlerNickServ(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	defer ctrl.Finish()
+
+	ircSettings := internal.IRCSettings{
+		Channel:          "SomeChannel",
+		NickServUser:     "SomeUser",
+		NickServPassword: "SomePassword",
+	}
+
+	mockClient := NewMockClientInterface(ctrl)
+	mockLogger := internal.NewMockDebugLogger(ctrl)
+	mockClient.
+		EXPECT().
+		Logger().
+		Return(mockLogger)
+	mockLogger.
+		EXPECT().
+		LogDebug(gomock.Eq("connectHandler triggered"))
+	mockClient.
+		EXPECT().
+		IRCSettings().
+		Return(&ircSettings).
+		AnyTimes()
+	// The channel is joined once the bot is identified
+	mockClient.
+		EXPECT().
+		Join(gomock.Any()).
+		Times(0)
+
+	myHandler := connectHandler(mockClient)
+	myHandler(&girc.Client{}, girc.Event{})
+}
+
+func TestInviteHandlerNickServ(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	defer ctrl.Finish()
+
+	ircSettings := internal.IRCSettings{
+		Channel:          "SomeChannel",
+		ChannelKey:       "SomeKey",
+		NickServUser:     "SomeUser",
+		NickServPassword: "SomePassword",
+	}
+
+	mockClient := NewMockClientInterface(ctrl)
+	mockLogger := internal.NewMockDebugLogger(ctrl)
+	mockClient.
+		EXPECT().
+		Logger().
+		Return(mockLogger)
+	mockLogger.
+		EXPECT().
+		LogDebug(gomock.Eq("inviteHandler triggered"))
+	mockClient.
+		EXPECT().
+		IRCSettings().
+		Return(&ircSettings).
+		AnyTimes()
+	mockClient.
+		EXPECT().
+		JoinKey(gomock.Eq("SomeChannel"), gomock.Eq("SomeKey"))
+
+	myHandler := inviteHandler(mockClient)
+	myHandler(&girc.Client{}, girc.Event{Params: []string{"teleirc", "SomeChannel"}})
+}
+
+func TestInviteHandlerOtherChannel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	defer ctrl.Finish()
+
+	ircSettings := internal.IRCSettings{
+		Channel: "SomeChannel",
+	}
+
+	mockClient := NewMockClientInterface(ctrl)
+	mockLogger := internal.NewMockDebugLogger(ctrl)
+	mockClient.
+		EXPECT().
+		Logger().
+		Return(mockLogger)
+	mockLogger.
+		EXPECT().
+		LogDebug(gomock.Eq("inviteHandler triggered"))
+	mockClient.
+		EXPECT().
+		IRCSettings().
+		Return(&ircSettings).
+		AnyTimes()
+
+	myHandler := inviteHandler(mockClient)
+	myHandler(&girc.Client{}, girc.Event{Params: []string{"teleirc", "OtherChannel"}})
+}
+
 func TestDisconnectHandlerWhenDisabled(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
@@ -905,13 +1049,17 @@ func TestDisconnectHandlerWhenEnabled(t *testing.T) {
 		IRCSettings().
 		Return(&ircSettings).
 		AnyTimes()
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any()).
+		MaxTimes(1)
 	mockClient.
 		EXPECT().
 		SendToTg("Lost connection to '" + ircSettings.Channel + "' on '" + ircSettings.Server + "'").
 		MaxTimes(1)
 
 	myHandler := disconnectHandler(mockClient)
-	myHandler(&girc.Client{}, girc.Event{})
+	myHandler(&girc.Client{Config: girc.Config{Server: ircSettings.Server}}, girc.Event{})
 }
 
 func TestMessageHandlerInBlacklist(t *testing.T) {
@@ -934,6 +1082,10 @@ func TestMessageHandlerInBlacklist(t *testing.T) {
 	mockLogger.
 		EXPECT().
 		LogDebug(gomock.Eq("messageHandler triggered"))
+	mockClient.
+		EXPECT().
+		InPlayback(gomock.Any()).
+		Return(false)
 	mockClient.
 		EXPECT().
 		IRCSettings().
@@ -970,6 +1122,10 @@ func TestMessageHandlerNotChannel(t *testing.T) {
 	mockLogger.
 		EXPECT().
 		LogDebug(gomock.Eq("messageHandler triggered"))
+	mockClient.
+		EXPECT().
+		InPlayback(gomock.Any()).
+		Return(false)
 	mockClient.
 		EXPECT().
 		IRCSettings().
@@ -1011,6 +1167,10 @@ func TestMessageHandlerNoForward(t *testing.T) {
 	mockLogger.
 		EXPECT().
 		LogDebug(gomock.Eq("messageHandler triggered"))
+	mockClient.
+		EXPECT().
+		InPlayback(gomock.Any()).
+		Return(false)
 	mockClient.
 		EXPECT().
 		IRCSettings().
@@ -1053,15 +1213,37 @@ func TestMessageHandlerFull(t *testing.T) {
 	mockClient.
 		EXPECT().
 		Logger().
-		Return(mockLogger)
+		Return(mockLogger).
+		Times(2)
 	mockLogger.
 		EXPECT().
 		LogDebug(gomock.Eq("messageHandler triggered"))
+	mockClient.
+		EXPECT().
+		InPlayback(gomock.Any()).
+		Return(false)
+	mockLogger.
+		EXPECT().
+		With("event", "message", "channel", "#testchannel", "nick", "SomeUser").
+		Return(mockLogger)
+	mockLogger.
+		EXPECT().
+		LogDebug(gomock.Eq("sending message to tg: %s"), gomock.Eq("<<SomeUser>> a message"))
 	mockClient.
 		EXPECT().
 		IRCSettings().
 		Return(&ircSettings).
 		AnyTimes()
+	mockClient.
+		EXPECT().
+		RecordEvent(archive.Event{
+			Side:       archive.IRC,
+			Chat:       "#testchannel",
+			Kind:       "text",
+			SenderID:   "SomeUser!some@example.com",
+			SenderName: "SomeUser",
+			Text:       "<<SomeUser>> a message",
+		})
 	mockClient.
 		EXPECT().
 		SendToTg(gomock.Eq("<<SomeUser>> a message"))
@@ -1069,7 +1251,9 @@ func TestMessageHandlerFull(t *testing.T) {
 	myHandler := messageHandler(mockClient)
 	myHandler(&girc.Client{}, girc.Event{
 		Source: &girc.Source{
-			Name: "SomeUser",
+			Name:  "SomeUser",
+			Ident: "some",
+			Host:  "example.com",
 		},
 		// Need to be PRIVMSG
 		Command: girc.PRIVMSG,
@@ -1080,6 +1264,163 @@ func TestMessageHandlerFull(t *testing.T) {
 	})
 }
 
+func TestMessageHandlerHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	defer ctrl.Finish()
+
+	ircSettings := internal.IRCSettings{
+		IRCBlacklist: []string{},
+		Channel:      "#testchannel",
+	}
+
+	mockClient := NewMockClientInterface(ctrl)
+	mockLogger := internal.NewMockDebugLogger(ctrl)
+	mockClient.
+		EXPECT().
+		Logger().
+		Return(mockLogger)
+	mockLogger.
+		EXPECT().
+		LogDebug(gomock.Eq("messageHandler triggered"))
+	mockClient.
+		EXPECT().
+		InPlayback(gomock.Any()).
+		Return(false)
+	mockClient.
+		EXPECT().
+		IRCSettings().
+		Return(&ircSettings).
+		AnyTimes()
+	mockClient.
+		EXPECT().
+		SendHistory(gomock.Eq("SomeUser"), gomock.Eq(5)).
+		Return(true)
+	mockClient.
+		EXPECT().
+		SendToTg(gomock.Any()).
+		MaxTimes(0)
+
+	myHandler := messageHandler(mockClient)
+	myHandler(&girc.Client{}, girc.Event{
+		Source: &girc.Source{
+			Name: "SomeUser",
+		},
+		Command: girc.PRIVMSG,
+		Params: []string{
+			"#testchannel",
+			"!history 5",
+		},
+	})
+}
+
+func TestMessageHandlerHistoryOff(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	defer ctrl.Finish()
+
+	ircSettings := internal.IRCSettings{
+		IRCBlacklist: []string{},
+		Prefix:       "<",
+		Suffix:       ">",
+		Channel:      "#testchannel",
+	}
+
+	mockClient := NewMockClientInterface(ctrl)
+	mockLogger := internal.NewMockDebugLogger(ctrl)
+	mockClient.
+		EXPECT().
+		Logger().
+		Return(mockLogger).
+		Times(2)
+	mockLogger.
+		EXPECT().
+		LogDebug(gomock.Eq("messageHandler triggered"))
+	mockClient.
+		EXPECT().
+		InPlayback(gomock.Any()).
+		Return(false)
+	mockLogger.
+		EXPECT().
+		With(gomock.Any()).
+		Return(mockLogger)
+	mockLogger.
+		EXPECT().
+		LogDebug(gomock.Eq("sending message to tg: %s"), gomock.Eq("<SomeUser> !history"))
+	mockClient.
+		EXPECT().
+		IRCSettings().
+		Return(&ircSettings).
+		AnyTimes()
+	mockClient.
+		EXPECT().
+		SendHistory(gomock.Eq("SomeUser"), gomock.Eq(history.DefaultLines)).
+		Return(false)
+	mockClient.
+		EXPECT().
+		RecordEvent(gomock.Any())
+	mockClient.
+		EXPECT().
+		SendToTg(gomock.Eq("<SomeUser> !history"))
+
+	// Without history, the command is an ordinary message
+	myHandler := messageHandler(mockClient)
+	myHandler(&girc.Client{}, girc.Event{
+		Source: &girc.Source{
+			Name: "SomeUser",
+		},
+		Command: girc.PRIVMSG,
+		Params: []string{
+			"#testchannel",
+			"!history",
+		},
+	})
+}
+
+func TestMessageHandlerBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	defer ctrl.Finish()
+
+	ircSettings := internal.IRCSettings{
+		IRCBlacklist: []string{},
+		Channel:      "#testchannel",
+	}
+
+	mockClient := NewMockClientInterface(ctrl)
+	mockLogger := internal.NewMockDebugLogger(ctrl)
+	mockClient.
+		EXPECT().
+		Logger().
+		Return(mockLogger)
+	mockLogger.
+		EXPECT().
+		LogDebug(gomock.Eq("messageHandler triggered"))
+	mockClient.
+		EXPECT().
+		InPlayback(gomock.Any()).
+		Return(true)
+	mockClient.
+		EXPECT().
+		IRCSettings().
+		Return(&ircSettings).
+		AnyTimes()
+
+	// Played back history is relayed by the playback handlers, not here
+	myHandler := messageHandler(mockClient)
+	myHandler(&girc.Client{}, girc.Event{
+		Source: &girc.Source{
+			Name: "SomeUser",
+		},
+		Tags:    girc.Tags{"batch": "history1"},
+		Command: girc.PRIVMSG,
+		Params: []string{
+			"#testchannel",
+			"Hello",
+		},
+	})
+}
+
 func TestMessageHandlerWrongChannel(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
@@ -1099,6 +1440,10 @@ func TestMessageHandlerWrongChannel(t *testing.T) {
 	mockLogger.
 		EXPECT().
 		LogDebug(gomock.Eq("messageHandler triggered"))
+	mockClient.
+		EXPECT().
+		InPlayback(gomock.Any()).
+		Return(false)
 	mockClient.
 		EXPECT().
 		IRCSettings().
@@ -0,0 +1,102 @@
+package irc
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal/history"
+)
+
+// historyCommand asks the bot for the last messages
+const historyCommand = "!history"
+
+const (
+	// historyCooldown is how long a user waits between two !history
+	historyCooldown = 30 * time.Second
+	// recapCooldown is how long before a user who joins again gets another
+	// recap
+	recapCooldown = 10 * time.Minute
+	// recapBurst spaces out recaps, so the bot does not send one to
+	// everyone who comes back after a netsplit
+	recapBurst = 5 * time.Second
+)
+
+/*
+historyLimits keeps history from being used to make the bot flood
+*/
+type historyLimits struct {
+	requests *history.Cooldown
+	recaps   *history.Cooldown
+	burst    *history.Cooldown
+}
+
+func newHistoryLimits() *historyLimits {
+	return &historyLimits{
+		requests: history.NewCooldown(historyCooldown),
+		recaps:   history.NewCooldown(recapCooldown),
+		burst:    history.NewCooldown(recapBurst),
+	}
+}
+
+/*
+SendHistory sends the last n relayed messages to nick in private NOTICEs,
+and returns whether the bot keeps history. Only users in the channel may
+read them, as the channel may have a key or be secret. Users asking again
+within historyCooldown get nothing.
+*/
+func (c Client) SendHistory(nick string, n int) bool {
+	if c.History == nil {
+		return false
+	}
+	if !c.limits.requests.Allow(strings.ToLower(nick), time.Now()) {
+		c.logger.With("nick", nick).LogDebug("Ignoring %s, asked too often", historyCommand)
+		return true
+	}
+	channel := c.IRCSettings().Channel
+	if ch := c.LookupChannel(channel); ch == nil || !ch.UserIn(nick) {
+		c.Cmd.Notice(nick, "Only users in "+channel+" can read its history")
+		return true
+	}
+	lines := history.Lines(c.History.Last(min(n, history.MaxLines), ""), history.MaxLines)
+	if len(lines) == 0 {
+		c.Cmd.Notice(nick, "No messages yet")
+		return true
+	}
+	for _, line := range lines {
+		c.Cmd.Notice(nick, line)
+	}
+	return true
+}
+
+/*
+addHistoryHandlers adds the handler that sends a recap of the last
+IRC_HISTORY_ON_JOIN messages to users who join the channel, at most once
+per recapCooldown for each user@host
+*/
+func (c Client) addHistoryHandlers() {
+	if c.History == nil {
+		return
+	}
+	c.AddHandler(girc.JOIN, func(gc *girc.Client, e girc.Event) {
+		n := c.IRCSettings().HistoryOnJoin
+		channel := c.IRCSettings().Channel
+		if n == 0 || e.Source == nil || len(e.Params) == 0 || !strings.EqualFold(e.Params[0], channel) ||
+			e.Source.ID() == gc.GetID() {
+			return
+		}
+		lines := history.Lines(c.History.Last(n, ""), history.MaxLines)
+		if len(lines) == 0 {
+			return
+		}
+		now := time.Now()
+		if !c.limits.recaps.Allow(strings.ToLower(e.Source.Ident+"@"+e.Source.Host), now) ||
+			!c.limits.burst.Allow("", now) {
+			return
+		}
+		c.Cmd.Notice(e.Source.Name, "Last messages in "+channel+", "+historyCommand+" for more:")
+		for _, line := range lines {
+			c.Cmd.Notice(e.Source.Name, line)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/history"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+notices returns the texts of the NOTICEs the bot sent to nick
+*/
+func notices(s *fakeirc.Server, nick string) []string {
+	var texts []string
+	for _, e := range s.Received() {
+		if e.Command == girc.NOTICE && len(e.Params) == 2 && e.Params[0] == nick {
+			texts = append(texts, e.Last())
+		}
+	}
+	return texts
+}
+
+func TestHistory(t *testing.T) {
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+
+	settings := newTestSettings(s)
+	settings.HistoryOnJoin = 2
+	client := NewClient(settings, &internal.TelegramSettings{}, internal.Debug{})
+	client.History = history.New(10)
+	toTg := make(chan string, 10)
+	errChan := make(chan error, 1)
+	go client.StartBot(errChan, func(msg string) { toTg <- msg })
+	defer func() {
+		client.Close()
+		assert.NoError(t, waitForError(t, errChan))
+	}()
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	require.True(t, ok, "client should join the channel")
+
+	// Nothing to catch up on yet
+	s.Join("alice", "#bridge")
+	s.Say("alice", "#bridge", "!history")
+	_, ok = s.WaitForCommand(girc.NOTICE, "alice", 5*time.Second)
+	require.True(t, ok, "alice should get a NOTICE")
+	assert.Equal(t, []string{"No messages yet"}, notices(s, "alice"))
+
+	client.History.Add(history.Entry{Side: history.Telegram, Kind: "text", Text: "<batman> one"})
+	client.History.Add(history.Entry{Side: history.Telegram, Kind: "text", Text: "<batman> two"})
+	client.History.Add(history.Entry{Side: history.Telegram, Kind: "text", Text: "<batman> three"})
+
+	// Joining users get a recap
+	s.Join("robin", "#bridge")
+	assert.Eventually(t, func() bool { return len(notices(s, "robin")) == 3 }, 5*time.Second, 10*time.Millisecond)
+	recap := notices(s, "robin")
+	assert.Equal(t, "Last messages in #bridge, !history for more:", recap[0])
+	assert.Regexp(t, `^\[\d\d:\d\d\] <batman> two$`, recap[1])
+	assert.Regexp(t, `^\[\d\d:\d\d\] <batman> three$`, recap[2])
+
+	// In private, and not relayed. Robin got a recap, but has not asked yet.
+	s.Say("robin", "teleirc", "!history 1")
+	assert.Eventually(t, func() bool { return len(notices(s, "robin")) == 4 }, 5*time.Second, 10*time.Millisecond)
+	assert.Regexp(t, `<batman> three$`, notices(s, "robin")[3])
+
+	// Users outside of the channel get nothing
+	s.Say("mallory", "teleirc", "!history")
+	assert.Eventually(t, func() bool { return len(notices(s, "mallory")) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"Only users in #bridge can read its history"}, notices(s, "mallory"))
+
+	// Asking again too soon gets nothing
+	s.Say("alice", "#bridge", "!history 5")
+	s.Say("alice", "#bridge", "hello")
+	select {
+	case msg := <-toTg:
+		assert.Equal(t, "alice hello", msg)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "message not relayed")
+	}
+	assert.Len(t, notices(s, "alice"), 1)
+}
@@ -3,6 +3,7 @@ package irc
 import (
 	"github.com/lrstanley/girc"
 	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/archive"
 )
 
 /*
@@ -14,6 +15,9 @@ type ClientInterface interface {
 	Logger() internal.DebugLogger
 	addHandlers()
 	SendToTg(string)
+	RecordEvent(archive.Event)
+	SendHistory(string, int) bool
+	InPlayback(girc.Event) bool
 	IRCSettings() *internal.IRCSettings
 	TgSettings() *internal.TelegramSettings
 
@@ -1,11 +1,19 @@
 package irc
 
 import (
+	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/lrstanley/girc"
 	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/archive"
+	"github.com/ritlug/teleirc/internal/chanlog"
+	"github.com/ritlug/teleirc/internal/history"
+	"github.com/ritlug/teleirc/internal/metrics"
+	"github.com/ritlug/teleirc/internal/proxy"
+	"github.com/ritlug/teleirc/internal/websocket"
 )
 
 /*
@@ -14,61 +22,189 @@ and the IRCSettings that were passed into NewClient
 */
 type Client struct {
 	*girc.Client
-	Settings         *internal.IRCSettings
-	TelegramSettings *internal.TelegramSettings
-	logger           internal.DebugLogger
-	sendToTg         func(string)
+	// Archive stores the relayed events, if set
+	Archive *archive.Archive
+	// ChannelLog writes the channel to log files, if set
+	ChannelLog *chanlog.Writer
+	// History keeps the last relayed messages for !history, if set
+	History *history.Buffer
+
+	settings *liveSettings
+	logger   internal.DebugLogger
+	sendToTg func(string)
+	auth     *authState
+	nickserv *nickServState
+	conn     *connState
+	sts      *stsStore
+	lag      *lagState
+	status   *internal.Status
+	limits   *historyLimits
+	playback *playbackState
 }
 
 /*
 NewClient returns a new IRCClient based on the provided settings
 */
 func NewClient(settings *internal.IRCSettings, telegramSettings *internal.TelegramSettings, logger internal.DebugLogger) Client {
+	logger = logger.With("side", "irc")
 	logger.LogInfo("Creating new IRC bot client...")
-	client := girc.New(girc.Config{
+	config := girc.Config{
 		Server: settings.Server,
 		Port:   settings.Port,
-		Nick:   settings.BotNick,
-		Name:   settings.BotName,
-		User:   settings.BotIdent,
 		SSL:    settings.UseSSL,
-	})
+	}
+	configure(&config, settings)
+	if s, err := parseServer(settings.Server, settings.Port, settings.UseSSL); err == nil {
+		applyServer(&config, s)
+	}
+	client := girc.New(config)
 
-	// Bind an IP address for IRC connection
-	if settings.BindAddress != "" {
-		client.Config.Bind = settings.BindAddress
+	return Client{
+		Client:   client,
+		settings: &liveSettings{irc: settings, tg: telegramSettings},
+		logger:   logger,
+		auth:     &authState{},
+		nickserv: &nickServState{},
+		conn:     newConnState(),
+		sts:      newSTSStore(),
+		lag:      &lagState{},
+		status:   &internal.Status{},
+		limits:   newHistoryLimits(),
+		playback: newPlaybackState(),
 	}
+}
+
+/*
+configure sets the identity of the bot in the girc configuration: its
+nick, names, bind address and credentials, how it pings the server and
+the capabilities it asks for
+*/
+func configure(config *girc.Config, settings *internal.IRCSettings) {
+	config.Nick = settings.BotNick
+	config.Name = settings.BotName
+	config.User = settings.BotIdent
+
+	// Bind an IP address for IRC connection
+	config.Bind = settings.BindAddress
 
 	// IRC server authentication
-	if settings.ServerPass != "" {
-		client.Config.ServerPass = settings.ServerPass
-	}
+	config.ServerPass = settings.ServerPass
 
-	// NickServ authentication
-	if settings.NickServUser != "" && settings.NickServPassword != "" {
-		client.Config.SASL = &girc.SASLPlain{
-			User: settings.NickServUser,
-			Pass: settings.NickServPassword,
-		}
+	// Account authentication
+	config.SASL = newSASLMech(settings)
+
+	// The bot pings the server itself if IRC_PING_INTERVAL is set, see lag.go
+	if settings.PingInterval > 0 {
+		config.PingDelay = -1
+	} else {
+		config.PingDelay = defaultPingDelay
 	}
 
-	return Client{client, settings, telegramSettings, logger, nil}
+	// Missed messages are fetched after reconnecting, see chathistory.go
+	if settings.ChatHistoryLimit > 0 {
+		config.SupportedCaps = map[string][]string{chatHistoryCap: nil}
+	} else {
+		config.SupportedCaps = nil
+	}
 }
 
 /*
 StartBot adds necessary handlers to the client and then connects,
-returns any errors that occur
+reconnecting to the configured servers in turn until the client is closed.
+Returns the error that made it give up, if any.
 */
 func (c Client) StartBot(errChan chan<- error, sendMessage func(string)) {
 	c.logger.LogInfo("Starting up IRC bot...")
 	c.sendToTg = sendMessage
 	c.addHandlers()
-	// 10 second timeout for connection
-	if err := c.ConnectDialer(&net.Dialer{Timeout: 10 * time.Second}); err != nil {
+	c.addAuthHandlers()
+	c.addNickServHandlers()
+	c.addSTSHandlers()
+	c.addLagHandlers()
+	c.addHistoryHandlers()
+	c.addPlaybackHandlers()
+	c.AddHandler(girc.RPL_WELCOME, func(gc *girc.Client, e girc.Event) {
+		c.conn.setRegistered()
+		c.status.SetConnected()
+	})
+	c.AddHandler(girc.ALL_EVENTS, func(gc *girc.Client, e girc.Event) {
+		c.status.EventReceived()
+	})
+	c.AddHandler(girc.PRIVMSG, func(gc *girc.Client, e girc.Event) {
+		if e.IsFromChannel() && strings.EqualFold(e.Params[0], c.IRCSettings().Channel) {
+			c.status.MessageReceived()
+		}
+	})
+	servers, err := c.serversFor(c.IRCSettings())
+	if err != nil {
 		errChan <- err
-	} else {
-		errChan <- nil
+		return
+	}
+	if err := c.sts.load(c.IRCSettings().STSFile); err != nil {
+		errChan <- fmt.Errorf("could not load STS policies from %s: %w", c.IRCSettings().STSFile, err)
+		return
 	}
+	errChan <- c.connectLoop(servers)
+}
+
+/*
+serversFor checks that the bot can connect with settings, and returns the
+servers to connect to
+*/
+func (c Client) serversFor(settings *internal.IRCSettings) ([]server, error) {
+	if settings.SASLRequired && newSASLMech(settings) == nil {
+		return nil, errSASLUnconfigured
+	}
+	servers, err := serverList(settings)
+	if err != nil {
+		return nil, err
+	}
+	// Check the TLS settings up front, rather than on every connection
+	for _, s := range servers {
+		if !s.SSL {
+			continue
+		}
+		tlsConfig, err := newTLSConfig(settings, s.Host)
+		if err != nil {
+			return nil, err
+		}
+		if len(tlsConfig.Certificates) > 0 {
+			c.logger.LogInfo("Using IRC client certificate with SHA-512 fingerprint %s",
+				certFingerprint(tlsConfig.Certificates[0]))
+		}
+		break
+	}
+	return servers, nil
+}
+
+/*
+newDialer returns the dialer for the IRC connection to s, which connects
+through the configured proxy and from the configured bind address, over
+WebSocket if s is a ws:// or wss:// URL
+*/
+func (c Client) newDialer(s server) (proxy.Dialer, error) {
+	settings := c.IRCSettings()
+	// 10 second timeout for connection
+	forward := &net.Dialer{Timeout: 10 * time.Second}
+	if settings.BindAddress != "" {
+		local, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(settings.BindAddress, "0"))
+		if err != nil {
+			return nil, err
+		}
+		forward.LocalAddr = local
+	}
+	if settings.Proxy != "" && settings.Proxy != proxy.Direct {
+		c.logger.LogInfo("Connecting to IRC through proxy %s", proxy.Redacted(settings.Proxy))
+	}
+	dialer, err := proxy.NewDialer(settings.Proxy, forward)
+	if err != nil || s.URL == "" {
+		return dialer, err
+	}
+	wsDialer, err := websocket.NewDialer(s.URL, c.Config.TLSConfig, dialer)
+	if err != nil {
+		return nil, err
+	}
+	return wsDialer, nil
 }
 
 /*
@@ -122,36 +258,71 @@ func (c Client) SendToTg(msg string) {
 	c.sendToTg(msg)
 }
 
+/*
+RecordEvent stores a relayed event in the scrollback and the archive.
+Events outside of a channel, such as QUIT, belong to the bridged channel.
+*/
+func (c Client) RecordEvent(e archive.Event) {
+	c.History.Add(history.Entry{Time: e.Time, Side: history.IRC, Kind: e.Kind, Text: e.Text})
+	if c.Archive == nil {
+		return
+	}
+	if e.Chat == "" {
+		e.Chat = c.IRCSettings().Channel
+	}
+	if err := c.Archive.Add(e); err != nil {
+		c.logger.LogError("Could not archive event: %s", err)
+	}
+}
+
 /*
 IRCSettings returns the IRCSettings struct associated with this client
 */
 func (c Client) IRCSettings() *internal.IRCSettings {
-	return c.Settings
+	c.settings.mu.RLock()
+	defer c.settings.mu.RUnlock()
+	return c.settings.irc
 }
 
 /*
 TgSettings returns the TgSettings struct associated with this client
 */
 func (c Client) TgSettings() *internal.TelegramSettings {
-	return c.TelegramSettings
+	c.settings.mu.RLock()
+	defer c.settings.mu.RUnlock()
+	return c.settings.tg
 }
 
 /*
 SendMessage sends a message to the IRC channel specified in the
-settings
+settings, and writes it to the channel log as the bot's
 */
 func (c Client) SendMessage(msg string) {
-	c.Message(c.Settings.Channel, msg)
+	// girc drops messages while disconnected
+	if !c.IsConnected() {
+		metrics.SendErrors.Inc(metrics.IRC)
+		return
+	}
+	// girc holds back messages to stay under the flood limit
+	metrics.QueueDepth.Add(1, metrics.IRC)
+	defer metrics.QueueDepth.Add(-1, metrics.IRC)
+	channel := c.IRCSettings().Channel
+	c.Message(channel, msg)
+	if c.ChannelLog != nil {
+		c.writeChannelLog(chanlog.Line{Kind: chanlog.Message, Channel: channel, Nick: c.GetNick(), Text: msg})
+	}
 }
 
 /*
 addHandlers adds handlers for the client struct based on the settings
-that were passed in to NewClient
+that were passed in to NewClient, and logs the same events to the channel
+log
 */
 func (c Client) addHandlers() {
 	for eventType, handler := range getHandlerMapping() {
 		c.logger.LogDebug("Adding IRC event handler: %s", eventType)
 		c.AddHandler(eventType, handler(c))
+		c.addChannelLogHandler(eventType)
 	}
 }
 
@@ -162,8 +333,9 @@ Some servers may not report the QUIT message unless the bot
 was in the channel for a minimum amount of time.
 */
 func (c Client) Close() {
-	if c.IRCSettings().QuitMessage != "" {
-		c.Client.Quit(c.IRCSettings().QuitMessage)
+	c.conn.close()
+	if quitMessage := c.IRCSettings().QuitMessage; quitMessage != "" {
+		c.Client.Quit(quitMessage)
 	} else {
 		c.Client.Close()
 	}
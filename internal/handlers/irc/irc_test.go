@@ -1,12 +1,17 @@
 package irc
 
 import (
+	"crypto/tls"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/lrstanley/girc"
 	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/ritlug/teleirc/internal/testing/fakeproxy"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewClientBasic(t *testing.T) {
@@ -17,9 +22,7 @@ func TestNewClientBasic(t *testing.T) {
 		BotName:  "Alfred Pennyworth",
 		BotNick:  "alfred-p",
 	}
-	logger := internal.Debug{
-		DebugLevel: false,
-	}
+	logger := internal.Debug{}
 	client := NewClient(ircSettings, nil, logger)
 
 	expectedPing, _ := time.ParseDuration("20s")
@@ -32,8 +35,9 @@ func TestNewClientBasic(t *testing.T) {
 		User:        "alfred",
 		PingDelay:   expectedPing,
 		PingTimeout: expectedTimeout,
+		DisableSTS:  true,
 	}
-	assert.Equal(t, client.Settings, ircSettings, "Client settings should be properly set")
+	assert.Equal(t, client.IRCSettings(), ircSettings, "Client settings should be properly set")
 	assert.Equal(t, client.Config, expectedConfig, "girc config should be properly set")
 }
 
@@ -49,9 +53,7 @@ func TestNewClientFull(t *testing.T) {
 		NickServUser:     "irc_moderators",
 		NickServPassword: "ProtectGotham",
 	}
-	logger := internal.Debug{
-		DebugLevel: false,
-	}
+	logger := internal.Debug{}
 	client := NewClient(ircSettings, nil, logger)
 	expectedPing, _ := time.ParseDuration("20s")
 	expectedTimeout, _ := time.ParseDuration("60s")
@@ -69,7 +71,125 @@ func TestNewClientFull(t *testing.T) {
 			User: "irc_moderators",
 			Pass: "ProtectGotham",
 		},
+		DisableSTS: true,
 	}
-	assert.Equal(t, client.Settings, ircSettings, "Client settings should be properly set")
+	assert.Equal(t, client.IRCSettings(), ircSettings, "Client settings should be properly set")
 	assert.Equal(t, client.Config, expectedConfig, "girc config should be properly set")
 }
+
+func TestStartBotProxy(t *testing.T) {
+	proxies := map[string]func() (*fakeproxy.Server, error){
+		"socks5": fakeproxy.NewSOCKS5,
+		"http":   fakeproxy.NewHTTP,
+	}
+	for name, newProxy := range proxies {
+		t.Run(name, func(t *testing.T) {
+			s, err := fakeirc.New()
+			require.NoError(t, err)
+			defer s.Close()
+			p, err := newProxy()
+			require.NoError(t, err)
+			defer p.Close()
+			p.Username = "user"
+			p.Password = "secret"
+
+			settings := newTestSettings(s)
+			settings.Proxy = p.URL()
+			client, errChan := startClient(settings)
+			_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+			assert.True(t, ok, "client should connect through the proxy")
+			assert.Equal(t, []string{s.Addr()}, p.Targets())
+
+			client.Close()
+			assert.NoError(t, waitForError(t, errChan))
+		})
+	}
+}
+
+func TestStartBotProxyRejected(t *testing.T) {
+	p, err := fakeproxy.NewSOCKS5()
+	require.NoError(t, err)
+	defer p.Close()
+	p.Username = "user"
+	p.Password = "secret"
+
+	settings := &internal.IRCSettings{
+		Server:   "irc.example.com",
+		Port:     6667,
+		BotNick:  "teleirc",
+		BotIdent: "teleirc",
+		Proxy:    strings.Replace(p.URL(), "secret", "wrong", 1),
+	}
+	_, errChan := startClient(settings)
+	assert.Error(t, waitForError(t, errChan))
+}
+
+func TestNewClientWebSocket(t *testing.T) {
+	settings := &internal.IRCSettings{
+		Server:   "wss://irc.example.com/webirc",
+		Port:     6667,
+		UseSSL:   true,
+		BotIdent: "teleirc",
+		BotNick:  "teleirc",
+	}
+	client := NewClient(settings, &internal.TelegramSettings{}, internal.Debug{})
+	assert.Equal(t, "irc.example.com", client.Config.Server)
+	assert.Equal(t, 443, client.Config.Port)
+	assert.False(t, client.Config.SSL, "TLS is handled by the WebSocket dialer")
+	assert.True(t, client.Config.DisableSTS)
+
+	settings.Server = "ws://irc.example.com:8097/"
+	client = NewClient(settings, &internal.TelegramSettings{}, internal.Debug{})
+	assert.Equal(t, 8097, client.Config.Port)
+}
+
+func TestStartBotWebSocket(t *testing.T) {
+	s := fakeirc.NewWebSocket(nil)
+	defer s.Close()
+	p, err := fakeproxy.NewSOCKS5()
+	require.NoError(t, err)
+	defer p.Close()
+
+	settings := newTestSettings(s)
+	settings.Server = s.URL
+	settings.Proxy = p.URL()
+	client, errChan := startClient(settings)
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	assert.True(t, ok, "client should connect over WebSocket")
+	assert.Equal(t, []string{s.Addr()}, p.Targets())
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
+
+func TestStartBotWebSocketTLS(t *testing.T) {
+	ca := newTestCert(t, "Test CA", true, time.Now().Add(24*time.Hour), nil)
+	serverCert := newTestCert(t, "irc.example.com", false, time.Now().Add(24*time.Hour), ca)
+	s := fakeirc.NewWebSocket(&tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{serverCert.cert.Raw},
+			PrivateKey:  serverCert.key,
+		}},
+	})
+	defer s.Close()
+	require.True(t, strings.HasPrefix(s.URL, "wss://"))
+
+	settings := newTestSettings(s)
+	settings.Server = s.URL
+	settings.UseSSL = true
+	settings.TLSCAFile = writeCAFile(t, ca)
+	settings.TLSServerName = "irc.example.com"
+	client, errChan := startClient(settings)
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	assert.True(t, ok, "client should connect over secure WebSocket")
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+
+	// The certificate is checked against the URL's host without a server name
+	settings.TLSServerName = ""
+	_, errChan = startClient(settings)
+	err := waitForError(t, errChan)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "127.0.0.1")
+	}
+}
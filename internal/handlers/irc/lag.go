@@ -0,0 +1,194 @@
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal/metrics"
+)
+
+// lagCheckInterval is how often the lag is checked against its limits
+const lagCheckInterval = time.Second
+
+// defaultPingDelay is how often girc pings the server when the bot does not
+const defaultPingDelay = 20 * time.Second
+
+/*
+lagState tracks the PINGs the bot sends to measure the lag to the server
+*/
+type lagState struct {
+	mu sync.Mutex
+	// pending is the token of the PING awaiting a PONG, sent at sentAt
+	pending string
+	sentAt  time.Time
+	// warned is set while the lag is above IRC_LAG_WARNING
+	warned bool
+	// timedOut is why the bot dropped the connection, if it did
+	timedOut error
+}
+
+/*
+reset forgets the PING of the last connection
+*/
+func (l *lagState) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = ""
+	l.sentAt = time.Time{}
+	l.timedOut = nil
+}
+
+/*
+ping returns the token of a new PING to send, if none is pending and the
+last one was sent at least interval ago
+*/
+func (l *lagState) ping(now time.Time, interval time.Duration) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending != "" || now.Sub(l.sentAt) < interval {
+		return "", false
+	}
+	l.sentAt = now
+	l.pending = strconv.FormatInt(now.UnixNano(), 10)
+	return l.pending, true
+}
+
+/*
+waiting returns how long the pending PING has been waiting for its PONG
+*/
+func (l *lagState) waiting(now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending == "" {
+		return 0, false
+	}
+	return now.Sub(l.sentAt), true
+}
+
+/*
+pong clears the pending PING if token is its token
+*/
+func (l *lagState) pong(token string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if token == l.pending {
+		l.pending = ""
+	}
+}
+
+/*
+setWarned records whether the lag is above the warning level, and returns
+whether that changed
+*/
+func (l *lagState) setWarned(warned bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	changed := l.warned != warned
+	l.warned = warned
+	return changed
+}
+
+/*
+timeOut records why the bot dropped the connection
+*/
+func (l *lagState) timeOut(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timedOut = err
+}
+
+func (l *lagState) timeoutErr() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.timedOut
+}
+
+/*
+addLagHandlers adds the handler that measures the lag when the server
+answers a PING. Both girc and monitorLag send the time in nanoseconds with
+every PING, and the server echoes it.
+*/
+func (c Client) addLagHandlers() {
+	c.AddHandler(girc.PONG, func(gc *girc.Client, e girc.Event) {
+		token := e.Last()
+		sent, err := strconv.ParseInt(token, 10, 64)
+		if err != nil {
+			return
+		}
+		c.lag.pong(token)
+		c.recordLag(time.Since(time.Unix(0, sent)))
+	})
+}
+
+/*
+recordLag exposes a measured lag, and warns when it goes above or back
+below IRC_LAG_WARNING
+*/
+func (c Client) recordLag(lag time.Duration) {
+	metrics.IRCLag.Set(lag.Seconds())
+	c.status.SetLag(lag)
+	c.logger.LogDebug("IRC lag: %s", lag)
+
+	warning := c.IRCSettings().LagWarning
+	if warning <= 0 {
+		return
+	}
+	if lag < warning && c.lag.setWarned(false) {
+		c.logger.LogInfo("IRC lag is back to %s", lag.Round(time.Millisecond))
+		c.SendToTg(fmt.Sprintf("IRC lag is back to %s", lag.Round(time.Millisecond)))
+	}
+}
+
+/*
+warnLag warns once when a PING has been waiting for longer than
+IRC_LAG_WARNING
+*/
+func (c Client) warnLag(waiting time.Duration) {
+	warning := c.IRCSettings().LagWarning
+	if warning <= 0 || waiting < warning || !c.lag.setWarned(true) {
+		return
+	}
+	c.logger.LogWarning("IRC lag is over %s, messages to IRC are delayed", waiting.Round(time.Second))
+	c.SendToTg(fmt.Sprintf("IRC lag is over %s, messages to IRC are delayed", waiting.Round(time.Second)))
+}
+
+/*
+monitorLag pings the server every IRC_PING_INTERVAL once the bot is
+registered, until done is closed. A half-dead connection can stay open
+without the server sending anything, so when a PING gets no PONG within
+IRC_LAG_TIMEOUT, the connection is closed and the bot reconnects.
+*/
+func (c Client) monitorLag(done <-chan struct{}) {
+	ticker := time.NewTicker(lagCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+		settings := c.IRCSettings()
+		if settings.PingInterval <= 0 || !c.conn.wasRegistered() {
+			continue
+		}
+		now := time.Now()
+		if waiting, ok := c.lag.waiting(now); ok {
+			c.warnLag(waiting)
+			if settings.LagTimeout > 0 && waiting >= settings.LagTimeout {
+				err := fmt.Errorf("no PONG from the server for %s", waiting.Round(time.Second))
+				c.logger.LogError("%s, reconnecting", err)
+				c.lag.timeOut(err)
+				c.Client.Close()
+				return
+			}
+			continue
+		}
+		if token, ok := c.lag.ping(now, settings.PingInterval); ok {
+			// Sending waits while girc throttles messages, which counts as lag
+			go c.Cmd.Ping(token)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLagStatePing(t *testing.T) {
+	l := &lagState{}
+	now := time.Now()
+	token, ok := l.ping(now, time.Minute)
+	require.True(t, ok, "the first PING is sent at once")
+
+	// Only one PING is pending at a time
+	_, ok = l.ping(now.Add(2*time.Minute), time.Minute)
+	assert.False(t, ok)
+	waiting, ok := l.waiting(now.Add(5 * time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, waiting)
+
+	// A PONG to another PING, like girc's, does not count
+	l.pong("other")
+	_, ok = l.waiting(now)
+	assert.True(t, ok)
+	l.pong(token)
+	_, ok = l.waiting(now)
+	assert.False(t, ok)
+
+	_, ok = l.ping(now.Add(30*time.Second), time.Minute)
+	assert.False(t, ok, "PINGs are sent every interval")
+	_, ok = l.ping(now.Add(time.Minute), time.Minute)
+	assert.True(t, ok)
+}
+
+func TestLagStateWarned(t *testing.T) {
+	l := &lagState{}
+	assert.False(t, l.setWarned(false))
+	assert.True(t, l.setWarned(true))
+	assert.False(t, l.setWarned(true))
+	assert.True(t, l.setWarned(false))
+}
+
+func TestNewClientPing(t *testing.T) {
+	client := NewClient(&internal.IRCSettings{PingInterval: 30 * time.Second}, nil, internal.Debug{})
+	assert.Equal(t, time.Duration(-1), client.Config.PingDelay, "girc should not ping when the bot does")
+}
+
+func TestLagReconnect(t *testing.T) {
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+
+	settings := newTestSettings(s)
+	settings.PingInterval = time.Second
+	settings.LagWarning = time.Second
+	settings.LagTimeout = 2 * time.Second
+	client := NewClient(settings, &internal.TelegramSettings{}, internal.Debug{})
+	toTg := make(chan string, 10)
+	errChan := make(chan error, 1)
+	go client.StartBot(errChan, func(msg string) { toTg <- msg })
+	defer func() {
+		client.Close()
+		assert.NoError(t, waitForError(t, errChan))
+	}()
+
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	require.True(t, ok, "client should join the channel")
+	assert.Eventually(t, func() bool { return client.Status().LagSeconds > 0 }, 5*time.Second, 10*time.Millisecond)
+
+	s.Stall()
+	waitForTg := func(prefix string) {
+		t.Helper()
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case msg := <-toTg:
+				if strings.HasPrefix(msg, prefix) {
+					return
+				}
+			case <-timeout:
+				assert.Fail(t, "no message to Telegram", prefix)
+				return
+			}
+		}
+	}
+	waitForTg("IRC lag is over")
+
+	// The bot gives up on the stalled connection and connects again
+	joins := func() int {
+		n := 0
+		for _, e := range s.Received() {
+			if e.Command == girc.JOIN {
+				n++
+			}
+		}
+		return n
+	}
+	assert.Eventually(t, func() bool { return joins() == 2 }, 10*time.Second, 10*time.Millisecond)
+	waitForTg("IRC lag is back to")
+	assert.Contains(t, client.status.Snapshot().LastError, "no PONG from the server")
+}
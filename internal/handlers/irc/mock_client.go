@@ -9,6 +9,7 @@ import (
 	gomock "github.com/golang/mock/gomock"
 	girc "github.com/lrstanley/girc"
 	internal "github.com/ritlug/teleirc/internal"
+	archive "github.com/ritlug/teleirc/internal/archive"
 )
 
 // MockClientInterface is a mock of ClientInterface interface
@@ -96,6 +97,46 @@ func (mr *MockClientInterfaceMockRecorder) SendToTg(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendToTg", reflect.TypeOf((*MockClientInterface)(nil).SendToTg), arg0)
 }
 
+// RecordEvent mocks base method
+func (m *MockClientInterface) RecordEvent(arg0 archive.Event) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RecordEvent", arg0)
+}
+
+// RecordEvent indicates an expected call of RecordEvent
+func (mr *MockClientInterfaceMockRecorder) RecordEvent(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordEvent", reflect.TypeOf((*MockClientInterface)(nil).RecordEvent), arg0)
+}
+
+// SendHistory mocks base method
+func (m *MockClientInterface) SendHistory(arg0 string, arg1 int) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendHistory", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// SendHistory indicates an expected call of SendHistory
+func (mr *MockClientInterfaceMockRecorder) SendHistory(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendHistory", reflect.TypeOf((*MockClientInterface)(nil).SendHistory), arg0, arg1)
+}
+
+// InPlayback mocks base method
+func (m *MockClientInterface) InPlayback(arg0 girc.Event) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InPlayback", arg0)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// InPlayback indicates an expected call of InPlayback
+func (mr *MockClientInterfaceMockRecorder) InPlayback(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InPlayback", reflect.TypeOf((*MockClientInterface)(nil).InPlayback), arg0)
+}
+
 // IRCSettings mocks base method
 func (m *MockClientInterface) IRCSettings() *internal.IRCSettings {
 	m.ctrl.T.Helper()
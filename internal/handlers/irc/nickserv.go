@@ -0,0 +1,240 @@
+package irc
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+)
+
+// defaultNickServService is the service used when IRC_NICKSERV_SERVICE is empty
+const defaultNickServService = "NickServ"
+
+// identifyTimeout limits how long joining the channel waits for NickServ
+var identifyTimeout = 30 * time.Second
+
+var (
+	// Replies of common services (Atheme, Anope) to a successful IDENTIFY
+	identifiedRegex = regexp.MustCompile(`(?i)you are now (identified|logged in)|password accepted`)
+	// Replies of common services to a failed IDENTIFY
+	identifyFailedRegex = regexp.MustCompile(`(?i)invalid password|password (is )?incorrect|incorrect password|is not( a)? registered|isn't registered`)
+	// Replies of common services to a command they do not have
+	unknownCommandRegex = regexp.MustCompile(`(?i)unknown command|invalid command|not a valid command|no such command`)
+)
+
+/*
+nickServState tracks NickServ identification and nick recovery for the
+current connection
+*/
+type nickServState struct {
+	mu         sync.Mutex
+	connected  bool
+	identified bool
+	joined     bool
+	// recovering is the command sent to recover the bot's nick, REGAIN or
+	// GHOST, until the service replies
+	recovering string
+	timer      *time.Timer
+}
+
+/*
+usesNickServ returns whether NickServ credentials are configured, in which
+case joining the channel waits until the bot is identified
+*/
+func usesNickServ(settings *internal.IRCSettings) bool {
+	return settings.NickServUser != "" && settings.NickServPassword != ""
+}
+
+/*
+nickServService returns the nick of the service to identify with
+*/
+func (c Client) nickServService() string {
+	if c.IRCSettings().NickServService == "" {
+		return defaultNickServService
+	}
+	return c.IRCSettings().NickServService
+}
+
+/*
+addNickServHandlers adds the handlers that identify with NickServ when SASL
+did not log the bot in, recover the bot's nick, and join the channel once
+the bot is identified. They do nothing unless NickServ credentials are set,
+which a reload may change.
+*/
+func (c Client) addNickServHandlers() {
+	c.AddHandler(girc.CONNECTED, func(gc *girc.Client, e girc.Event) {
+		if !usesNickServ(c.IRCSettings()) {
+			return
+		}
+		c.nickserv.mu.Lock()
+		c.nickserv.connected = true
+		identified := c.nickserv.identified
+		c.nickserv.mu.Unlock()
+
+		c.auth.mu.Lock()
+		identified = identified || c.auth.authenticated
+		c.auth.mu.Unlock()
+
+		if identified {
+			c.identified()
+			return
+		}
+
+		c.logger.LogInfo("Identifying with %s", c.nickServService())
+		gc.Send(&girc.Event{
+			Command:   girc.PRIVMSG,
+			Params:    []string{c.nickServService(), "IDENTIFY " + c.IRCSettings().NickServUser + " " + c.IRCSettings().NickServPassword},
+			Sensitive: true,
+		})
+
+		c.nickserv.mu.Lock()
+		if !c.nickserv.joined {
+			c.nickserv.timer = time.AfterFunc(identifyTimeout, func() {
+				if c.claimJoin() {
+					c.logger.LogError("%s did not confirm identification within %s, joining anyway",
+						c.nickServService(), identifyTimeout)
+					joinChannel(c)
+				}
+			})
+		}
+		c.nickserv.mu.Unlock()
+	})
+
+	c.AddHandler(girc.DISCONNECTED, func(gc *girc.Client, e girc.Event) {
+		c.nickserv.mu.Lock()
+		defer c.nickserv.mu.Unlock()
+		if c.nickserv.timer != nil {
+			c.nickserv.timer.Stop()
+			c.nickserv.timer = nil
+		}
+		c.nickserv.connected = false
+		c.nickserv.identified = false
+		c.nickserv.joined = false
+		c.nickserv.recovering = ""
+	})
+
+	c.AddHandler(girc.RPL_LOGGEDIN, func(gc *girc.Client, e girc.Event) {
+		if usesNickServ(c.IRCSettings()) {
+			c.identified()
+		}
+	})
+
+	c.AddHandler(girc.MODE, func(gc *girc.Client, e girc.Event) {
+		// Most networks set user mode +r on identified users
+		if !usesNickServ(c.IRCSettings()) || len(e.Params) < 2 || !strings.EqualFold(e.Params[0], gc.GetNick()) {
+			return
+		}
+		if modeAdded(e.Params[1], 'r') {
+			c.identified()
+		}
+	})
+
+	c.AddHandler(girc.NOTICE, func(gc *girc.Client, e girc.Event) {
+		if !usesNickServ(c.IRCSettings()) || e.Source == nil || !strings.EqualFold(e.Source.Name, c.nickServService()) {
+			return
+		}
+		text := e.Last()
+		c.logger.LogDebug("%s: %s", c.nickServService(), text)
+
+		switch {
+		case identifiedRegex.MatchString(text):
+			c.identified()
+		case identifyFailedRegex.MatchString(text):
+			c.logger.LogError("Identifying with %s failed: %s", c.nickServService(), text)
+			if c.claimJoin() {
+				joinChannel(c)
+			}
+		default:
+			c.nickserv.mu.Lock()
+			recovering := c.nickserv.recovering
+			c.nickserv.recovering = ""
+			c.nickserv.mu.Unlock()
+			switch {
+			case recovering == "REGAIN" && unknownCommandRegex.MatchString(text):
+				// Older services only have GHOST
+				c.recoverNick("GHOST")
+			case recovering == "GHOST":
+				// Whatever the service replied to GHOST, the nick is either
+				// free now or will not become free
+				c.logger.LogInfo("Switching back to nick %s", c.IRCSettings().BotNick)
+				gc.Cmd.Nick(c.IRCSettings().BotNick)
+			}
+			// Services that have REGAIN change the nick themselves
+		}
+	})
+}
+
+/*
+identified marks the bot as identified. Once it is also connected, the
+configured nick is recovered if it was taken and the channel is joined.
+*/
+func (c Client) identified() {
+	c.nickserv.mu.Lock()
+	c.nickserv.identified = true
+	connected := c.nickserv.connected
+	c.nickserv.mu.Unlock()
+	if !connected || !c.claimJoin() {
+		return
+	}
+
+	c.logger.LogInfo("Identified as %s", c.IRCSettings().NickServUser)
+	if nick := c.GetNick(); !strings.EqualFold(nick, c.IRCSettings().BotNick) {
+		c.logger.LogInfo("Nick %s is taken, asking %s to recover it", c.IRCSettings().BotNick, c.nickServService())
+		c.recoverNick("REGAIN")
+	}
+	joinChannel(c)
+}
+
+/*
+recoverNick asks NickServ to recover the configured nick with command:
+REGAIN, which disconnects the session holding it and gives it to the bot,
+or GHOST, which only disconnects the session
+*/
+func (c Client) recoverNick(command string) {
+	c.nickserv.mu.Lock()
+	c.nickserv.recovering = command
+	c.nickserv.mu.Unlock()
+	c.Send(&girc.Event{
+		Command: girc.PRIVMSG,
+		Params:  []string{c.nickServService(), command + " " + c.IRCSettings().BotNick},
+	})
+}
+
+/*
+modeAdded returns whether a mode change such as "+iw-r" sets mode
+*/
+func modeAdded(modes string, mode rune) bool {
+	adding, added := true, false
+	for _, m := range modes {
+		switch m {
+		case '+':
+			adding = true
+		case '-':
+			adding = false
+		case mode:
+			added = adding
+		}
+	}
+	return added
+}
+
+/*
+claimJoin returns true if the channel should be joined now, which is only
+the case once per connection
+*/
+func (c Client) claimJoin() bool {
+	c.nickserv.mu.Lock()
+	defer c.nickserv.mu.Unlock()
+	if c.nickserv.joined {
+		return false
+	}
+	c.nickserv.joined = true
+	if c.nickserv.timer != nil {
+		c.nickserv.timer.Stop()
+		c.nickserv.timer = nil
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+newNickServServer starts a fake IRC server running NickServ, without SASL
+*/
+func newNickServServer(t *testing.T) *fakeirc.Server {
+	t.Helper()
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	s.NickServ = true
+	s.NoSASL = true
+	s.Accounts["teleirc"] = "secret"
+	return s
+}
+
+func newNickServSettings(s *fakeirc.Server) *internal.IRCSettings {
+	settings := newTestSettings(s)
+	settings.NickServUser = "teleirc"
+	settings.NickServPassword = "secret"
+	settings.NickServService = "NickServ"
+	return settings
+}
+
+/*
+receivedIndex returns the position of the first line the server received
+that matches, or -1
+*/
+func receivedIndex(s *fakeirc.Server, match func(girc.Event) bool) int {
+	for i, e := range s.Received() {
+		if match(e) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isIdentify(e girc.Event) bool {
+	return e.Command == girc.PRIVMSG && len(e.Params) == 2 &&
+		e.Params[0] == "NickServ" && strings.HasPrefix(e.Params[1], "IDENTIFY ")
+}
+
+func isJoin(e girc.Event) bool {
+	return e.Command == girc.JOIN
+}
+
+func TestNickServIdentifyBeforeJoin(t *testing.T) {
+	s := newNickServServer(t)
+	s.SetRegisteredOnly("#bridge")
+
+	client, errChan := startClient(newNickServSettings(s))
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	assert.True(t, ok, "client should join the channel")
+
+	identify := receivedIndex(s, isIdentify)
+	assert.NotEqual(t, -1, identify, "client should identify with NickServ")
+	assert.Less(t, identify, receivedIndex(s, isJoin), "client should identify before joining")
+	assert.Equal(t, "IDENTIFY teleirc secret", s.Received()[identify].Params[1])
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
+
+func TestModeAdded(t *testing.T) {
+	assert.True(t, modeAdded("+r", 'r'))
+	assert.True(t, modeAdded("+iwr", 'r'))
+	assert.True(t, modeAdded("-i+r", 'r'))
+	assert.False(t, modeAdded("+i-r", 'r'))
+	assert.False(t, modeAdded("-r", 'r'))
+	assert.False(t, modeAdded("+iw", 'r'))
+	assert.False(t, modeAdded("+r-r", 'r'))
+}
+
+func TestNickServRegain(t *testing.T) {
+	s := newNickServServer(t)
+	// A stale session holds the bot's nick
+	s.Join("teleirc", "#elsewhere")
+
+	client, errChan := startClient(newNickServSettings(s))
+	_, ok := s.WaitFor(func(e girc.Event) bool {
+		return e.Command == girc.PRIVMSG && len(e.Params) == 2 && e.Params[1] == "REGAIN teleirc"
+	}, 5*time.Second)
+	assert.True(t, ok, "client should regain its nick")
+
+	assert.Eventually(t, func() bool {
+		return client.GetNick() == "teleirc"
+	}, 5*time.Second, 10*time.Millisecond, "client should get its nick back")
+	assert.Equal(t, -1, receivedIndex(s, func(e girc.Event) bool {
+		return e.Command == girc.PRIVMSG && len(e.Params) == 2 && strings.HasPrefix(e.Params[1], "GHOST ")
+	}), "client should not ghost when REGAIN worked")
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
+
+func TestNickServGhost(t *testing.T) {
+	s := newNickServServer(t)
+	s.NoRegain = true
+	// A stale session holds the bot's nick
+	s.Join("teleirc", "#elsewhere")
+
+	client, errChan := startClient(newNickServSettings(s))
+	_, ok := s.WaitForCommand(girc.PRIVMSG, "NickServ", 5*time.Second)
+	assert.True(t, ok, "client should identify with NickServ")
+
+	_, ok = s.WaitFor(func(e girc.Event) bool {
+		return e.Command == girc.PRIVMSG && len(e.Params) == 2 && e.Params[1] == "GHOST teleirc"
+	}, 5*time.Second)
+	assert.True(t, ok, "client should ghost the stale session without REGAIN")
+
+	assert.Eventually(t, func() bool {
+		return client.GetNick() == "teleirc"
+	}, 5*time.Second, 10*time.Millisecond, "client should switch back to its nick")
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
+
+func TestNickServIdentifyFailed(t *testing.T) {
+	s := newNickServServer(t)
+	settings := newNickServSettings(s)
+	settings.NickServPassword = "wrong"
+
+	client, errChan := startClient(settings)
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	assert.True(t, ok, "client should join anyway after failing to identify")
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
+
+func TestNickServTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { identifyTimeout = timeout }(identifyTimeout)
+	identifyTimeout = 100 * time.Millisecond
+
+	s := newNickServServer(t)
+	// Nobody answers the IDENTIFY
+	s.NickServ = false
+
+	client, errChan := startClient(newNickServSettings(s))
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	assert.True(t, ok, "client should join once identification times out")
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
+
+func TestNickServSkippedAfterSASL(t *testing.T) {
+	s := newNickServServer(t)
+	s.NoSASL = false
+
+	client, errChan := startClient(newNickServSettings(s))
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	assert.True(t, ok, "client should join the channel")
+	assert.Equal(t, -1, receivedIndex(s, isIdentify), "client should not identify again after SASL")
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
+
+func TestNickServReload(t *testing.T) {
+	s := newNickServServer(t)
+	settings := newTestSettings(s)
+	client, errChan := startClient(settings)
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	require.True(t, ok, "client should join the channel")
+	identifies := func() int {
+		count := 0
+		for _, e := range s.Received() {
+			if isIdentify(e) {
+				count++
+			}
+		}
+		return count
+	}
+	assert.Equal(t, 0, identifies())
+
+	// Credentials added by a reload are used on the new connection
+	withNickServ := newNickServSettings(s)
+	_, err := client.Reload(withNickServ, &internal.TelegramSettings{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return countJoins(s, "#bridge") == 2 }, 5*time.Second, 10*time.Millisecond,
+		"client should join the channel again")
+	assert.Equal(t, 1, identifies(), "client should identify after the reload")
+
+	// and no longer once they are removed
+	_, err = client.Reload(newTestSettings(s), &internal.TelegramSettings{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return countJoins(s, "#bridge") == 3 }, 5*time.Second, 10*time.Millisecond,
+		"client should join the channel again")
+	assert.Equal(t, 1, identifies(), "client should not identify without credentials")
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
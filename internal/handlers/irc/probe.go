@@ -0,0 +1,66 @@
+package irc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+/*
+ProbeResult is what Probe learned about the IRC connection
+*/
+type ProbeResult struct {
+	Server string
+	// Authenticated is set if the bot logged in to its account with SASL
+	Authenticated bool
+}
+
+/*
+Probe connects to the first IRC server, registers and authenticates the way
+StartBot does, then disconnects. It returns why the bot could not register.
+*/
+func (c Client) Probe(timeout time.Duration) (*ProbeResult, error) {
+	c.addAuthHandlers()
+	c.addSTSHandlers()
+	var mu sync.Mutex
+	registered := false
+	c.AddHandler(girc.RPL_WELCOME, func(gc *girc.Client, e girc.Event) {
+		mu.Lock()
+		registered = true
+		mu.Unlock()
+		gc.Close()
+	})
+	servers, err := c.serversFor(c.IRCSettings())
+	if err != nil {
+		return nil, err
+	}
+	if err := c.sts.load(c.IRCSettings().STSFile); err != nil {
+		return nil, fmt.Errorf("could not load STS policies from %s: %w", c.IRCSettings().STSFile, err)
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		c.conn.close()
+		c.Client.Close()
+	})
+	defer timer.Stop()
+	err = c.connectTo(servers[0])
+
+	result := &ProbeResult{Server: c.conn.currentServer().String()}
+	if authErr := c.authErr(); authErr != nil {
+		return result, authErr
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !registered {
+		if err == nil {
+			err = fmt.Errorf("no welcome from the server within %s", timeout)
+		}
+		return result, err
+	}
+	c.auth.mu.Lock()
+	result.Authenticated = c.auth.authenticated
+	c.auth.mu.Unlock()
+	return result, nil
+}
@@ -0,0 +1,57 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+	s.Accounts["teleirc"] = "secret"
+
+	settings := newTestSettings(s)
+	settings.NickServUser = "teleirc"
+	settings.NickServPassword = "secret"
+	client := NewClient(settings, &internal.TelegramSettings{}, internal.Debug{})
+	result, err := client.Probe(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, s.Addr(), result.Server)
+	assert.True(t, result.Authenticated)
+	assert.Equal(t, 0, countJoins(s, "#bridge"), "the probe should not join the channel")
+}
+
+func TestProbeAuthFailure(t *testing.T) {
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+	s.Accounts["teleirc"] = "secret"
+
+	settings := newTestSettings(s)
+	settings.NickServUser = "teleirc"
+	settings.NickServPassword = "wrong"
+	client := NewClient(settings, &internal.TelegramSettings{}, internal.Debug{})
+	_, err = client.Probe(5 * time.Second)
+	var authErr *AuthError
+	if assert.ErrorAs(t, err, &authErr) {
+		assert.Equal(t, girc.ERR_SASLFAIL, authErr.Numeric)
+	}
+}
+
+func TestProbeRejected(t *testing.T) {
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+	s.Password = "secret"
+
+	client := NewClient(newTestSettings(s), &internal.TelegramSettings{}, internal.Debug{})
+	_, err = client.Probe(time.Second)
+	assert.Error(t, err, "a server that rejects the bot should fail the probe")
+}
@@ -0,0 +1,84 @@
+package irc
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/ritlug/teleirc/internal"
+)
+
+/*
+liveSettings holds the settings in use. Reload replaces them while handlers
+run, so they are read through IRCSettings and TgSettings.
+*/
+type liveSettings struct {
+	mu  sync.RWMutex
+	irc *internal.IRCSettings
+	tg  *internal.TelegramSettings
+}
+
+/*
+Reload switches the client to new settings. Settings that change how
+messages are relayed take effect right away. If a setting of the connection
+changed, such as the server or the nick, the client reconnects with the new
+settings and Reload returns true. Settings the bot cannot connect with are
+rejected, and the old ones stay in use.
+*/
+func (c Client) Reload(settings *internal.IRCSettings, telegramSettings *internal.TelegramSettings) (bool, error) {
+	if _, err := c.serversFor(settings); err != nil {
+		return false, err
+	}
+	if settings.STSFile != c.IRCSettings().STSFile {
+		if err := c.sts.load(settings.STSFile); err != nil {
+			return false, fmt.Errorf("could not load STS policies from %s: %w", settings.STSFile, err)
+		}
+	}
+
+	c.settings.mu.Lock()
+	reconnect := connectionChanged(c.settings.irc, settings)
+	c.settings.irc = settings
+	c.settings.tg = telegramSettings
+	c.settings.mu.Unlock()
+
+	if reconnect {
+		c.conn.requestReconnect()
+		c.Client.Close()
+	}
+	return reconnect, nil
+}
+
+/*
+connectionChanged returns whether the settings differ in a way that needs
+a new connection to take effect
+*/
+func connectionChanged(old, new *internal.IRCSettings) bool {
+	return old.Server != new.Server ||
+		!slices.Equal(old.Servers, new.Servers) ||
+		old.Port != new.Port ||
+		old.UseSSL != new.UseSSL ||
+		old.ServerPass != new.ServerPass ||
+		old.BindAddress != new.BindAddress ||
+		old.Proxy != new.Proxy ||
+		old.TLSAllowSelfSigned != new.TLSAllowSelfSigned ||
+		old.TLSAllowCertExpired != new.TLSAllowCertExpired ||
+		old.TLSCAFile != new.TLSCAFile ||
+		old.TLSServerName != new.TLSServerName ||
+		!slices.Equal(old.TLSPins, new.TLSPins) ||
+		old.TLSMinVersion != new.TLSMinVersion ||
+		old.TLSClientCert != new.TLSClientCert ||
+		old.TLSClientKey != new.TLSClientKey ||
+		old.Channel != new.Channel ||
+		old.ChannelKey != new.ChannelKey ||
+		old.BotIdent != new.BotIdent ||
+		old.BotName != new.BotName ||
+		old.BotNick != new.BotNick ||
+		old.NickServUser != new.NickServUser ||
+		old.NickServPassword != new.NickServPassword ||
+		old.NickServService != new.NickServService ||
+		old.SASLRequired != new.SASLRequired ||
+		// draft/chathistory is only requested when playback is on
+		(old.ChatHistoryLimit > 0) != (new.ChatHistoryLimit > 0) ||
+		// girc only pings the server when the bot does not, see configure
+		(old.PingInterval > 0) != (new.PingInterval > 0)
+}
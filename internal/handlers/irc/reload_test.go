@@ -0,0 +1,40 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/ritlug/teleirc/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionChanged(t *testing.T) {
+	old := &internal.IRCSettings{Server: "irc.example.com", BotNick: "teleirc", Prefix: "<"}
+	live := *old
+	live.Prefix = "["
+	live.IRCBlacklist = []string{"spammer"}
+	assert.False(t, connectionChanged(old, &live))
+
+	nick := *old
+	nick.BotNick = "teleirc2"
+	assert.True(t, connectionChanged(old, &nick))
+}
+
+func TestReloadRejectsInvalidSettings(t *testing.T) {
+	settings := &internal.IRCSettings{Server: "irc.example.com", BotNick: "teleirc"}
+	client := NewClient(settings, &internal.TelegramSettings{}, internal.Debug{})
+
+	invalid := *settings
+	invalid.BotNick = "teleirc2"
+	invalid.SASLRequired = true
+	reconnect, err := client.Reload(&invalid, &internal.TelegramSettings{})
+	assert.Error(t, err)
+	assert.False(t, reconnect)
+	assert.Same(t, settings, client.IRCSettings(), "the old settings should stay in use")
+
+	valid := *settings
+	valid.Prefix = "["
+	reconnect, err = client.Reload(&valid, &internal.TelegramSettings{})
+	assert.NoError(t, err)
+	assert.False(t, reconnect)
+	assert.Same(t, &valid, client.IRCSettings())
+}
@@ -0,0 +1,162 @@
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+)
+
+/*
+AuthError is returned by StartBot when the IRC server rejected the bot's
+account authentication
+*/
+type AuthError struct {
+	Mechanism string
+	// Numeric is the reply the server rejected authentication with
+	Numeric string
+	Reason  string
+	// Mechanisms lists the SASL mechanisms the server supports, if it said so
+	Mechanisms string
+}
+
+func (e *AuthError) Error() string {
+	msg := fmt.Sprintf("SASL %s authentication failed", e.Mechanism)
+	switch e.Numeric {
+	case girc.ERR_SASLFAIL:
+		switch {
+		case e.Mechanisms != "":
+			msg += fmt.Sprintf(": the server only supports %s", e.Mechanisms)
+		case e.Mechanism == "EXTERNAL":
+			msg += ": is the fingerprint of IRC_CLIENT_CERT added to the account?"
+		default:
+			msg += ": check IRC_NICKSERV_USER and IRC_NICKSERV_PASS"
+		}
+	case girc.ERR_SASLTOOLONG:
+		msg += ": the credentials are too long"
+	case girc.ERR_SASLABORTED:
+		msg += ": the server aborted the authentication"
+	case girc.RPL_NICKLOCKED:
+		msg += ": the account is locked"
+	}
+	if e.Reason != "" {
+		msg += fmt.Sprintf(" (%s: %s)", e.Numeric, e.Reason)
+	}
+	return msg
+}
+
+// errAuthRequired is returned when authentication is required but did not happen
+var errAuthRequired = errors.New("the IRC server did not authenticate the bot, " +
+	"but IRC_SASL_REQUIRED is set")
+
+// errSASLUnconfigured is returned when authentication is required but not configured
+var errSASLUnconfigured = errors.New("IRC_SASL_REQUIRED is set, but neither IRC_CLIENT_CERT " +
+	"nor IRC_NICKSERV_USER and IRC_NICKSERV_PASS are")
+
+/*
+authState tracks the account authentication of the current connection
+*/
+type authState struct {
+	mu            sync.Mutex
+	authenticated bool
+	mechanisms    string
+	err           error
+}
+
+/*
+newSASLMech returns the SASL mechanism to authenticate with. A client
+certificate is used with SASL EXTERNAL, otherwise the NickServ credentials
+are used with SASL PLAIN.
+*/
+func newSASLMech(settings *internal.IRCSettings) girc.SASLMech {
+	if settings.TLSClientCert != "" {
+		return &girc.SASLExternal{}
+	}
+	if settings.NickServUser != "" && settings.NickServPassword != "" {
+		return &girc.SASLPlain{
+			User: settings.NickServUser,
+			Pass: settings.NickServPassword,
+		}
+	}
+	return nil
+}
+
+/*
+reset clears the authentication state before a new connection
+*/
+func (a *authState) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.authenticated = false
+	a.mechanisms = ""
+	a.err = nil
+}
+
+/*
+authErr returns the error that ended the last connection attempt because
+of authentication, if any
+*/
+func (c Client) authErr() error {
+	c.auth.mu.Lock()
+	defer c.auth.mu.Unlock()
+	return c.auth.err
+}
+
+/*
+addAuthHandlers adds the handlers that track SASL authentication. girc
+disconnects by itself when authentication fails; these handlers record why.
+*/
+func (c Client) addAuthHandlers() {
+	c.AddHandler(girc.RPL_SASLSUCCESS, func(gc *girc.Client, e girc.Event) {
+		c.auth.mu.Lock()
+		c.auth.authenticated = true
+		c.auth.mu.Unlock()
+		c.logger.LogInfo("Authenticated to IRC with SASL %s", c.Config.SASL.Method())
+	})
+
+	c.AddHandler(girc.RPL_SASLMECHS, func(gc *girc.Client, e girc.Event) {
+		if len(e.Params) < 2 {
+			return
+		}
+		c.auth.mu.Lock()
+		c.auth.mechanisms = e.Params[1]
+		c.auth.mu.Unlock()
+	})
+
+	failed := func(gc *girc.Client, e girc.Event) {
+		if c.Config.SASL == nil {
+			return
+		}
+		c.auth.mu.Lock()
+		defer c.auth.mu.Unlock()
+		authErr := &AuthError{
+			Mechanism:  c.Config.SASL.Method(),
+			Numeric:    e.Command,
+			Reason:     e.Last(),
+			Mechanisms: c.auth.mechanisms,
+		}
+		c.auth.err = authErr
+		c.logger.LogError("%s", authErr)
+	}
+	for _, numeric := range []string{girc.ERR_SASLFAIL, girc.ERR_SASLTOOLONG, girc.ERR_SASLABORTED, girc.RPL_NICKLOCKED} {
+		c.AddHandler(numeric, failed)
+	}
+
+	c.AddHandler(girc.RPL_WELCOME, func(gc *girc.Client, e girc.Event) {
+		if !c.IRCSettings().SASLRequired {
+			return
+		}
+		c.auth.mu.Lock()
+		authenticated := c.auth.authenticated
+		if !authenticated {
+			c.auth.err = errAuthRequired
+		}
+		c.auth.mu.Unlock()
+		if !authenticated {
+			c.logger.LogError("%s", errAuthRequired)
+			gc.Close()
+		}
+	})
+}
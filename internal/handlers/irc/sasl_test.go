@@ -0,0 +1,242 @@
+package irc
+
+import (
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+newTLSServer starts a fake IRC server over TLS that asks clients for a
+certificate
+*/
+func newTLSServer(t *testing.T) *fakeirc.Server {
+	t.Helper()
+	serverCert := newTestCert(t, "irc.example.com", false, time.Now().Add(24*time.Hour), nil)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{serverCert.cert.Raw},
+			PrivateKey:  serverCert.key,
+		}},
+		ClientAuth: tls.RequestClientCert,
+	})
+	require.NoError(t, err)
+	s := fakeirc.NewWithListener(listener)
+	t.Cleanup(s.Close)
+	return s
+}
+
+/*
+writeClientCert writes a new client certificate and its key to one PEM file,
+returning the path and the certificate's SHA-512 fingerprint
+*/
+func writeClientCert(t *testing.T) (string, string) {
+	t.Helper()
+	cert := newTestCert(t, "teleirc", false, time.Now().Add(24*time.Hour), nil)
+	key, err := x509.MarshalECPrivateKey(cert.key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "client.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.cert.Raw})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key})...)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	hash := sha512.Sum512(cert.cert.Raw)
+	return path, hex.EncodeToString(hash[:])
+}
+
+func newTestSettings(s *fakeirc.Server) *internal.IRCSettings {
+	return &internal.IRCSettings{
+		Server:   s.Host,
+		Port:     s.Port,
+		Channel:  "#bridge",
+		BotIdent: "teleirc",
+		BotName:  "TeleIRC",
+		BotNick:  "teleirc",
+	}
+}
+
+/*
+startClient starts a client with the settings and returns the channel
+StartBot reports its result on
+*/
+func startClient(settings *internal.IRCSettings) (Client, chan error) {
+	client := NewClient(settings, &internal.TelegramSettings{}, internal.Debug{})
+	errChan := make(chan error, 1)
+	go client.StartBot(errChan, func(string) {})
+	return client, errChan
+}
+
+func waitForError(t *testing.T, errChan chan error) error {
+	t.Helper()
+	select {
+	case err := <-errChan:
+		return err
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "client did not stop")
+		return nil
+	}
+}
+
+func TestSASLMechanism(t *testing.T) {
+	assert.Nil(t, newSASLMech(&internal.IRCSettings{}))
+	assert.Equal(t, &girc.SASLPlain{User: "bot", Pass: "secret"},
+		newSASLMech(&internal.IRCSettings{NickServUser: "bot", NickServPassword: "secret"}))
+	assert.Equal(t, &girc.SASLExternal{}, newSASLMech(&internal.IRCSettings{
+		TLSClientCert:    "client.pem",
+		NickServUser:     "bot",
+		NickServPassword: "secret",
+	}), "a client certificate should be preferred")
+}
+
+func TestSASLExternalCertFP(t *testing.T) {
+	s := newTLSServer(t)
+	certFile, fingerprint := writeClientCert(t)
+	s.CertAccounts = map[string]string{fingerprint: "teleirc"}
+
+	settings := newTestSettings(s)
+	settings.UseSSL = true
+	settings.TLSAllowSelfSigned = true
+	settings.TLSServerName = "irc.example.com"
+	settings.TLSClientCert = certFile
+	settings.SASLRequired = true
+
+	client, errChan := startClient(settings)
+	_, ok := s.WaitForCommand(girc.AUTHENTICATE, "EXTERNAL", 5*time.Second)
+	assert.True(t, ok, "client should authenticate with EXTERNAL")
+	_, ok = s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	assert.True(t, ok, "client should join after authenticating")
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
+
+func TestSASLExternalUnknownCert(t *testing.T) {
+	s := newTLSServer(t)
+	certFile, _ := writeClientCert(t)
+	s.CertAccounts = map[string]string{"0123": "someone"}
+
+	settings := newTestSettings(s)
+	settings.UseSSL = true
+	settings.TLSAllowSelfSigned = true
+	settings.TLSServerName = "irc.example.com"
+	settings.TLSClientCert = certFile
+
+	_, errChan := startClient(settings)
+	err := waitForError(t, errChan)
+	var authErr *AuthError
+	if assert.True(t, errors.As(err, &authErr), "expected an AuthError, got %v", err) {
+		assert.Equal(t, "EXTERNAL", authErr.Mechanism)
+		assert.Equal(t, girc.ERR_SASLFAIL, authErr.Numeric)
+		assert.Contains(t, err.Error(), "IRC_CLIENT_CERT")
+	}
+}
+
+func TestSASLUnsupportedMechanism(t *testing.T) {
+	s := newTLSServer(t)
+	certFile, _ := writeClientCert(t)
+	s.Accounts["teleirc"] = "secret"
+
+	settings := newTestSettings(s)
+	settings.UseSSL = true
+	settings.TLSAllowSelfSigned = true
+	settings.TLSServerName = "irc.example.com"
+	settings.TLSClientCert = certFile
+
+	_, errChan := startClient(settings)
+	err := waitForError(t, errChan)
+	var authErr *AuthError
+	if assert.True(t, errors.As(err, &authErr), "expected an AuthError, got %v", err) {
+		assert.Equal(t, "PLAIN", authErr.Mechanisms)
+		assert.Contains(t, err.Error(), "only supports PLAIN")
+	}
+}
+
+func TestSASLPlainWrongPassword(t *testing.T) {
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+	s.Accounts["teleirc"] = "secret"
+
+	settings := newTestSettings(s)
+	settings.NickServUser = "teleirc"
+	settings.NickServPassword = "wrong"
+
+	_, errChan := startClient(settings)
+	err = waitForError(t, errChan)
+	var authErr *AuthError
+	if assert.True(t, errors.As(err, &authErr), "expected an AuthError, got %v", err) {
+		assert.Equal(t, "PLAIN", authErr.Mechanism)
+		assert.Contains(t, err.Error(), "IRC_NICKSERV_PASS")
+	}
+}
+
+func TestSASLRequired(t *testing.T) {
+	defer func(timeout time.Duration) { identifyTimeout = timeout }(identifyTimeout)
+	// The server has no NickServ to identify with either
+	identifyTimeout = 100 * time.Millisecond
+
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+
+	settings := newTestSettings(s)
+	settings.NickServUser = "teleirc"
+	settings.NickServPassword = "secret"
+
+	// Without SASL support on the server, the bot connects anyway by default
+	client, errChan := startClient(settings)
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	assert.True(t, ok, "client should join without authentication")
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+
+	settings.SASLRequired = true
+	_, errChan = startClient(settings)
+	assert.Equal(t, errAuthRequired, waitForError(t, errChan))
+}
+
+func TestSASLRequiredWithoutCredentials(t *testing.T) {
+	settings := &internal.IRCSettings{Server: "irc.example.com", SASLRequired: true}
+	_, errChan := startClient(settings)
+	err := waitForError(t, errChan)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "IRC_SASL_REQUIRED")
+	}
+}
+
+func TestAuthErrorMessages(t *testing.T) {
+	tests := []struct {
+		err      AuthError
+		expected string
+	}{
+		{
+			AuthError{Mechanism: "PLAIN", Numeric: girc.ERR_SASLTOOLONG, Reason: "SASL message too long"},
+			"SASL PLAIN authentication failed: the credentials are too long (905: SASL message too long)",
+		},
+		{
+			AuthError{Mechanism: "EXTERNAL", Numeric: girc.ERR_SASLABORTED},
+			"SASL EXTERNAL authentication failed: the server aborted the authentication",
+		},
+		{
+			AuthError{Mechanism: "PLAIN", Numeric: girc.RPL_NICKLOCKED, Reason: "Account is locked"},
+			"SASL PLAIN authentication failed: the account is locked (902: Account is locked)",
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.err.Error())
+	}
+}
@@ -0,0 +1,343 @@
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/metrics"
+	"github.com/ritlug/teleirc/internal/websocket"
+)
+
+// defaultPort is used for servers without a port when IRC_PORT is unset
+const defaultPort = 6667
+
+/*
+server is an IRC server the bot can connect to
+*/
+type server struct {
+	Host string
+	Port int
+	SSL  bool
+	// URL is set for IRC over WebSocket
+	URL string
+}
+
+func (s server) String() string {
+	if s.URL != "" {
+		return s.URL
+	}
+	port := strconv.Itoa(s.Port)
+	if s.SSL {
+		port = "+" + port
+	}
+	return net.JoinHostPort(s.Host, port)
+}
+
+/*
+parseServer parses a server setting: a host, host:port, host:+port for TLS,
+or a ws:// or wss:// URL. Servers without a port use port, and servers
+without a + use TLS if useSSL is set.
+*/
+func parseServer(setting string, port int, useSSL bool) (server, error) {
+	if websocket.IsURL(setting) {
+		u, err := websocket.ParseURL(setting)
+		if err != nil {
+			return server{}, err
+		}
+		p, _ := strconv.Atoi(websocket.Port(u))
+		return server{Host: u.Hostname(), Port: p, SSL: u.Scheme == "wss", URL: setting}, nil
+	}
+
+	if port == 0 {
+		port = defaultPort
+	}
+	s := server{Host: setting, Port: port, SSL: useSSL}
+	host, portStr, err := net.SplitHostPort(setting)
+	if err != nil {
+		// A host without a port
+		return s, nil
+	}
+	if strings.HasPrefix(portStr, "+") {
+		s.SSL = true
+		portStr = portStr[1:]
+	}
+	s.Host = host
+	s.Port, err = strconv.Atoi(portStr)
+	if err != nil || s.Port < 1 || s.Port > 65535 {
+		return server{}, fmt.Errorf("invalid port in IRC server %q", setting)
+	}
+	return s, nil
+}
+
+/*
+serverList returns the servers to connect to: IRC_SERVER followed by
+IRC_SERVERS, shuffled if IRC_SERVERS_SHUFFLE is set
+*/
+func serverList(settings *internal.IRCSettings) ([]server, error) {
+	var servers []server
+	for _, setting := range append([]string{settings.Server}, settings.Servers...) {
+		if setting == "" {
+			continue
+		}
+		s, err := parseServer(setting, settings.Port, settings.UseSSL)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, s)
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no IRC server configured, set IRC_SERVER")
+	}
+	if settings.ShuffleServers {
+		rand.Shuffle(len(servers), func(i, j int) {
+			servers[i], servers[j] = servers[j], servers[i]
+		})
+	}
+	return servers, nil
+}
+
+/*
+applyServer points the girc configuration at s. The WebSocket dialer
+handles TLS itself, and STS is handled by the bot, see sts.go.
+*/
+func applyServer(config *girc.Config, s server) {
+	config.Server = s.Host
+	config.Port = s.Port
+	config.SSL = s.SSL && s.URL == ""
+	config.DisableSTS = true
+}
+
+/*
+connState tracks the connection to the current server
+*/
+type connState struct {
+	mu      sync.Mutex
+	current server
+	// registered is set once the server welcomed the bot
+	registered bool
+	// upgradePort is the port the server asked to reconnect to with TLS
+	upgradePort int
+	closing     bool
+	closed      chan struct{}
+	// reconnect is signalled when the settings changed, see Reload
+	reconnect chan struct{}
+}
+
+func newConnState() *connState {
+	return &connState{closed: make(chan struct{}), reconnect: make(chan struct{}, 1)}
+}
+
+/*
+start records that a connection to s is being made
+*/
+func (s *connState) start(current server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = current
+	s.registered = false
+	s.upgradePort = 0
+}
+
+func (s *connState) currentServer() server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+func (s *connState) setRegistered() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registered = true
+}
+
+func (s *connState) wasRegistered() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.registered
+}
+
+func (s *connState) setUpgrade(port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upgradePort = port
+}
+
+/*
+takeUpgrade returns the port to reconnect to with TLS, if the server asked
+for it, and clears it
+*/
+func (s *connState) takeUpgrade() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	port := s.upgradePort
+	s.upgradePort = 0
+	return port
+}
+
+/*
+requestReconnect asks for a reconnect with the new settings
+*/
+func (s *connState) requestReconnect() {
+	select {
+	case s.reconnect <- struct{}{}:
+	default:
+	}
+}
+
+/*
+takeReconnect returns whether a reconnect was asked for, and clears it
+*/
+func (s *connState) takeReconnect() bool {
+	select {
+	case <-s.reconnect:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+close marks the client as closing, so it does not reconnect
+*/
+func (s *connState) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closing {
+		s.closing = true
+		close(s.closed)
+	}
+}
+
+func (s *connState) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+/*
+connectLoop connects to the servers in turn until the client is closed.
+After a connection fails or drops, the next server is tried. The loop gives
+up when every server failed in a row, or at once on an authentication
+error, since another server of the network would reject the bot too.
+When Reload changed the connection settings, it starts over with the new
+servers right away.
+*/
+func (c Client) connectLoop(servers []server) error {
+	failures := 0
+	// restart starts over with the servers of the new settings
+	restart := func() (err error) {
+		c.logger.LogInfo("Reconnecting to IRC with the new settings...")
+		failures = 0
+		servers, err = serverList(c.IRCSettings())
+		return err
+	}
+	for i, attempts := 0, 0; ; i, attempts = (i+1)%len(servers), attempts+1 {
+		if attempts > 0 {
+			metrics.Reconnects.Inc(metrics.IRC)
+		}
+		err := c.connectTo(servers[i])
+		c.status.SetDisconnected(err)
+		if c.conn.isClosing() {
+			return nil
+		}
+		if c.conn.takeReconnect() {
+			if err := restart(); err != nil {
+				return err
+			}
+			i = -1
+			continue
+		}
+		if authErr := c.authErr(); authErr != nil {
+			return authErr
+		}
+
+		if c.conn.wasRegistered() {
+			failures = 0
+			c.logger.LogError("Lost connection to IRC server %s: %v", servers[i], err)
+		} else {
+			failures++
+			c.logger.LogError("Could not connect to IRC server %s: %v", servers[i], err)
+		}
+		if failures >= len(servers) {
+			return err
+		}
+
+		if delay := c.IRCSettings().ReconnectDelay; delay > 0 {
+			c.logger.LogInfo("Reconnecting to IRC in %s...", delay)
+			select {
+			case <-time.After(delay):
+			case <-c.conn.closed:
+				return nil
+			case <-c.conn.reconnect:
+				if err := restart(); err != nil {
+					return err
+				}
+				i = -1
+			}
+		}
+	}
+}
+
+/*
+connectTo connects to s and returns when the connection ends. A known STS
+policy for the host, or a server asking for an upgrade, makes it connect
+with TLS instead.
+*/
+func (c Client) connectTo(s server) error {
+	for {
+		if policy, ok := c.sts.policy(s.Host); ok && !s.SSL && s.URL == "" {
+			c.logger.LogInfo("%s has an STS policy, connecting with TLS on port %d", s.Host, policy.Port)
+			s.SSL = true
+			s.Port = policy.Port
+		}
+		err := c.dial(s)
+		port := c.conn.takeUpgrade()
+		if port == 0 || c.conn.isClosing() {
+			return err
+		}
+		s.SSL = true
+		s.Port = port
+	}
+}
+
+/*
+dial makes a single connection to s, with the current settings, while
+monitorLag watches it
+*/
+func (c Client) dial(s server) error {
+	settings := c.IRCSettings()
+	c.conn.start(s)
+	c.auth.reset()
+	configure(&c.Config, settings)
+	applyServer(&c.Config, s)
+	c.Config.TLSConfig = nil
+	if s.SSL {
+		tlsConfig, err := newTLSConfig(settings, s.Host)
+		if err != nil {
+			return err
+		}
+		c.Config.TLSConfig = tlsConfig
+	}
+	dialer, err := c.newDialer(s)
+	if err != nil {
+		return err
+	}
+	c.logger.LogInfo("Connecting to IRC server %s...", s)
+	c.lag.reset()
+	done := make(chan struct{})
+	go c.monitorLag(done)
+	err = c.ConnectDialer(dialer)
+	close(done)
+	if timedOut := c.lag.timeoutErr(); timedOut != nil {
+		return timedOut
+	}
+	return err
+}
@@ -0,0 +1,172 @@
+package irc
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+closedAddr returns the host and port of a localhost port nothing listens on
+*/
+func closedAddr(t *testing.T) (string, int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close()
+	return addr.IP.String(), addr.Port
+}
+
+/*
+countJoins returns how often clients joined the channel on s
+*/
+func countJoins(s *fakeirc.Server, channel string) int {
+	count := 0
+	for _, e := range s.Received() {
+		if e.Command == girc.JOIN && len(e.Params) > 0 && e.Params[0] == channel {
+			count++
+		}
+	}
+	return count
+}
+
+func TestParseServer(t *testing.T) {
+	tests := []struct {
+		setting  string
+		expected server
+	}{
+		{"irc.example.com", server{Host: "irc.example.com", Port: 6667}},
+		{"irc.example.com:7000", server{Host: "irc.example.com", Port: 7000}},
+		{"irc.example.com:+6697", server{Host: "irc.example.com", Port: 6697, SSL: true}},
+		{"[2001:db8::1]:+6697", server{Host: "2001:db8::1", Port: 6697, SSL: true}},
+		{"wss://irc.example.com/webirc", server{Host: "irc.example.com", Port: 443, SSL: true, URL: "wss://irc.example.com/webirc"}},
+	}
+	for _, test := range tests {
+		s, err := parseServer(test.setting, 0, false)
+		assert.NoError(t, err, test.setting)
+		assert.Equal(t, test.expected, s, test.setting)
+	}
+
+	s, err := parseServer("irc.example.com", 6697, true)
+	assert.NoError(t, err)
+	assert.Equal(t, server{Host: "irc.example.com", Port: 6697, SSL: true}, s,
+		"IRC_PORT and IRC_USE_SSL should apply to servers without a port")
+	assert.Equal(t, "irc.example.com:+6697", s.String())
+
+	for _, setting := range []string{"irc.example.com:port", "irc.example.com:+70000", "wss://"} {
+		_, err := parseServer(setting, 0, false)
+		assert.Error(t, err, setting)
+	}
+}
+
+func TestServerList(t *testing.T) {
+	settings := &internal.IRCSettings{
+		Server:  "irc.example.com",
+		Port:    6697,
+		UseSSL:  true,
+		Servers: []string{"irc2.example.com:6667", "irc3.example.com"},
+	}
+	servers, err := serverList(settings)
+	require.NoError(t, err)
+	assert.Equal(t, []server{
+		{Host: "irc.example.com", Port: 6697, SSL: true},
+		{Host: "irc2.example.com", Port: 6667, SSL: true},
+		{Host: "irc3.example.com", Port: 6697, SSL: true},
+	}, servers)
+
+	settings.ShuffleServers = true
+	shuffled, err := serverList(settings)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, servers, shuffled)
+
+	_, err = serverList(&internal.IRCSettings{})
+	assert.Error(t, err)
+	_, err = serverList(&internal.IRCSettings{Server: "irc.example.com", Servers: []string{"irc2:bad"}})
+	assert.Error(t, err)
+}
+
+func TestFailover(t *testing.T) {
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+
+	settings := newTestSettings(s)
+	settings.Server, settings.Port = closedAddr(t)
+	settings.Servers = []string{s.Addr()}
+	client, errChan := startClient(settings)
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	assert.True(t, ok, "client should fail over to the second server")
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
+
+func TestFailoverGivesUp(t *testing.T) {
+	host, port := closedAddr(t)
+	_, other := closedAddr(t)
+	settings := &internal.IRCSettings{
+		Server:   host,
+		Port:     port,
+		Servers:  []string{net.JoinHostPort(host, strconv.Itoa(other))},
+		BotIdent: "teleirc",
+		BotNick:  "teleirc",
+	}
+	_, errChan := startClient(settings)
+	assert.Error(t, waitForError(t, errChan), "client should give up when every server failed")
+}
+
+func TestReconnect(t *testing.T) {
+	first, err := fakeirc.New()
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := fakeirc.New()
+	require.NoError(t, err)
+	defer second.Close()
+
+	settings := newTestSettings(first)
+	settings.Servers = []string{second.Addr()}
+	client, errChan := startClient(settings)
+	_, ok := first.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	require.True(t, ok, "client should connect to the first server")
+
+	// A dropped connection moves on to the next server
+	first.Disconnect()
+	_, ok = second.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	assert.True(t, ok, "client should reconnect to the second server")
+
+	// and back to the first one after that
+	second.Disconnect()
+	assert.Eventually(t, func() bool { return countJoins(first, "#bridge") == 2 },
+		5*time.Second, 10*time.Millisecond, "client should cycle back to the first server")
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
+
+func TestReconnectDelay(t *testing.T) {
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+
+	settings := newTestSettings(s)
+	settings.ReconnectDelay = time.Hour
+	client, errChan := startClient(settings)
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	require.True(t, ok)
+
+	s.Disconnect()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, countJoins(s, "#bridge"), "client should wait before reconnecting")
+
+	// Closing the client stops the wait
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
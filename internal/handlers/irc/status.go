@@ -0,0 +1,48 @@
+package irc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ritlug/teleirc/internal"
+)
+
+/*
+Status reports the state of the IRC connection. The bot is ready once it
+is registered with the server and in the bridged channel, while the server
+keeps sending something. The server is pinged regularly, so a connection
+without any line for longer than stallTimeout is stuck: the bot should have
+given up on it already.
+*/
+func (c Client) Status() internal.SideStatus {
+	status := c.status.Snapshot()
+	channel := c.IRCSettings().Channel
+	switch {
+	case !c.IsConnected() || !c.conn.wasRegistered() || status.ConnectedSince == nil:
+		status.Reason = "not connected to IRC"
+	case c.LookupChannel(channel) == nil:
+		status.Reason = "not in " + channel
+	default:
+		last := *status.ConnectedSince
+		if status.LastEvent != nil && status.LastEvent.After(last) {
+			last = *status.LastEvent
+		}
+		if since := time.Since(last); since > c.stallTimeout() {
+			status.Reason = fmt.Sprintf("nothing received from IRC for %s", since.Round(time.Second))
+		} else {
+			status.Ready = true
+		}
+	}
+	return status
+}
+
+/*
+stallTimeout returns how long the server may stay silent before the
+connection counts as stuck
+*/
+func (c Client) stallTimeout() time.Duration {
+	if settings := c.IRCSettings(); settings.PingInterval > 0 {
+		return settings.PingInterval + settings.LagTimeout + lagCheckInterval
+	}
+	return c.Config.PingDelay + c.Config.PingTimeout
+}
@@ -0,0 +1,42 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatus(t *testing.T) {
+	s, err := fakeirc.New()
+	require.NoError(t, err)
+	defer s.Close()
+
+	settings := newTestSettings(s)
+	client := NewClient(settings, &internal.TelegramSettings{}, internal.Debug{})
+	status := client.Status()
+	assert.False(t, status.Ready)
+	assert.Equal(t, "not connected to IRC", status.Reason)
+	assert.Nil(t, status.ConnectedSince)
+
+	errChan := make(chan error, 1)
+	go client.StartBot(errChan, func(string) {})
+	_, ok := s.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	require.True(t, ok, "client should join the channel")
+	assert.Eventually(t, func() bool { return client.Status().Ready }, 5*time.Second, 10*time.Millisecond)
+	assert.NotNil(t, client.Status().ConnectedSince)
+
+	s.Say("robin", "#bridge", "hi")
+	assert.Eventually(t, func() bool { return client.Status().LastMessage != nil }, 5*time.Second, 10*time.Millisecond)
+
+	client.Cmd.Part("#bridge")
+	assert.Eventually(t, func() bool { return client.Status().Reason == "not in #bridge" }, 5*time.Second, 10*time.Millisecond)
+
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+	assert.False(t, client.Status().Ready)
+}
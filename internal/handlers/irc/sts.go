@@ -0,0 +1,197 @@
+package irc
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal"
+)
+
+/*
+stsPolicy is an IRCv3 Strict Transport Security persistence policy: until
+it expires, the host may only be connected to with TLS on Port
+*/
+type stsPolicy struct {
+	Port int `json:"port"`
+	// Duration is how long the policy lasts after the last connection, in seconds
+	Duration int       `json:"duration"`
+	Expires  time.Time `json:"expires"`
+}
+
+/*
+stsStore holds the STS policies of IRC hosts, and saves them to a file if
+IRC_STS_FILE is set
+*/
+type stsStore struct {
+	mu       sync.Mutex
+	path     string
+	policies map[string]stsPolicy
+}
+
+func newSTSStore() *stsStore {
+	return &stsStore{policies: map[string]stsPolicy{}}
+}
+
+/*
+load reads the policies saved at path, which later changes are saved to.
+A missing file is not an error.
+*/
+func (s *stsStore) load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.policies = map[string]stsPolicy{}
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.policies)
+}
+
+/*
+policy returns the unexpired policy of host
+*/
+func (s *stsStore) policy(host string) (stsPolicy, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	policy, ok := s.policies[strings.ToLower(host)]
+	if !ok || time.Now().After(policy.Expires) {
+		return stsPolicy{}, false
+	}
+	return policy, true
+}
+
+/*
+update records the policy a host sent over TLS. A duration of 0 removes the
+host's policy.
+*/
+func (s *stsStore) update(host string, port, duration int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host = strings.ToLower(host)
+	if duration <= 0 {
+		if _, ok := s.policies[host]; !ok {
+			return nil
+		}
+		delete(s.policies, host)
+	} else {
+		s.policies[host] = stsPolicy{
+			Port:     port,
+			Duration: duration,
+			Expires:  time.Now().Add(time.Duration(duration) * time.Second),
+		}
+	}
+	return s.save()
+}
+
+/*
+refresh restarts the duration of the host's policy, which the STS
+specification requires when disconnecting
+*/
+func (s *stsStore) refresh(host string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host = strings.ToLower(host)
+	policy, ok := s.policies[host]
+	if !ok {
+		return nil
+	}
+	policy.Expires = time.Now().Add(time.Duration(policy.Duration) * time.Second)
+	s.policies[host] = policy
+	return s.save()
+}
+
+/*
+save writes the policies to the file, if there is one. Callers must hold mu.
+*/
+func (s *stsStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.policies, "", "  ")
+	if err != nil {
+		return err
+	}
+	return internal.WriteFileAtomic(s.path, data)
+}
+
+/*
+parseSTS returns the keys of an sts capability value, such as
+"port=6697,duration=300"
+*/
+func parseSTS(value string) map[string]string {
+	keys := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, _ := strings.Cut(pair, "=")
+		keys[key] = val
+	}
+	return keys
+}
+
+/*
+addSTSHandlers adds the handlers that enforce STS. A server that offers
+sts over plaintext is reconnected to with TLS on the port it names, and the
+policy it sends over TLS is remembered until it expires. girc's own STS
+support is disabled, since it forgets policies when the bot restarts.
+*/
+func (c Client) addSTSHandlers() {
+	c.AddHandler(girc.CAP, func(gc *girc.Client, e girc.Event) {
+		if len(e.Params) < 2 || (e.Params[1] != girc.CAP_LS && e.Params[1] != girc.CAP_NEW) {
+			return
+		}
+		var value string
+		found := false
+		for _, capability := range strings.Fields(e.Last()) {
+			if name, val, _ := strings.Cut(capability, "="); name == "sts" {
+				value, found = val, true
+			}
+		}
+		current := c.conn.currentServer()
+		if !found || current.URL != "" {
+			return
+		}
+		keys := parseSTS(value)
+
+		if !current.SSL {
+			port, err := strconv.Atoi(keys["port"])
+			if err != nil || port < 1 || port > 65535 {
+				c.logger.LogWarning("Ignoring invalid STS policy from %s: %s", current.Host, value)
+				return
+			}
+			c.logger.LogInfo("%s requires TLS (STS), reconnecting on port %d", current.Host, port)
+			c.conn.setUpgrade(port)
+			gc.Close()
+			return
+		}
+
+		duration, err := strconv.Atoi(keys["duration"])
+		if err != nil {
+			c.logger.LogWarning("Ignoring invalid STS policy from %s: %s", current.Host, value)
+			return
+		}
+		if err := c.sts.update(current.Host, current.Port, duration); err != nil {
+			c.logger.LogError("could not save STS policy to %s: %s", c.IRCSettings().STSFile, err)
+		}
+	})
+
+	c.AddHandler(girc.DISCONNECTED, func(gc *girc.Client, e girc.Event) {
+		current := c.conn.currentServer()
+		if !current.SSL || current.URL != "" {
+			return
+		}
+		if err := c.sts.refresh(current.Host); err != nil {
+			c.logger.LogError("could not save STS policy to %s: %s", c.IRCSettings().STSFile, err)
+		}
+	})
+}
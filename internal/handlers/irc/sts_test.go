@@ -0,0 +1,101 @@
+package irc
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/ritlug/teleirc/internal/testing/fakeirc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSTS(t *testing.T) {
+	assert.Equal(t, map[string]string{"port": "6697", "duration": "300", "preload": ""},
+		parseSTS("port=6697,duration=300,preload"))
+}
+
+func TestSTSStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sts.json")
+	store := newSTSStore()
+	require.NoError(t, store.load(path))
+	_, ok := store.policy("irc.example.com")
+	assert.False(t, ok)
+
+	require.NoError(t, store.update("IRC.example.com", 6697, 300))
+	policy, ok := store.policy("irc.example.com")
+	if assert.True(t, ok) {
+		assert.Equal(t, 6697, policy.Port)
+		assert.WithinDuration(t, time.Now().Add(300*time.Second), policy.Expires, time.Minute)
+	}
+
+	// Policies survive a restart
+	loaded := newSTSStore()
+	require.NoError(t, loaded.load(path))
+	_, ok = loaded.policy("irc.example.com")
+	assert.True(t, ok)
+
+	// A duration of 0 removes the policy
+	require.NoError(t, loaded.update("irc.example.com", 6697, 0))
+	_, ok = loaded.policy("irc.example.com")
+	assert.False(t, ok)
+	require.NoError(t, store.load(path))
+	_, ok = store.policy("irc.example.com")
+	assert.False(t, ok)
+}
+
+func TestSTSStoreExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sts.json")
+	data, err := json.Marshal(map[string]stsPolicy{
+		"irc.example.com": {Port: 6697, Duration: 300, Expires: time.Now().Add(-time.Minute)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	store := newSTSStore()
+	require.NoError(t, store.load(path))
+	_, ok := store.policy("irc.example.com")
+	assert.False(t, ok, "an expired policy should allow plaintext again")
+}
+
+func TestSTSUpgrade(t *testing.T) {
+	plain, err := fakeirc.New()
+	require.NoError(t, err)
+	defer plain.Close()
+	secure := newTLSServer(t)
+	sts := "port=" + strconv.Itoa(secure.Port) + ",duration=300"
+	plain.Caps["sts"] = sts
+	secure.Caps["sts"] = sts
+
+	settings := newTestSettings(plain)
+	settings.TLSAllowSelfSigned = true
+	settings.TLSServerName = "irc.example.com"
+	settings.STSFile = filepath.Join(t.TempDir(), "sts.json")
+
+	client, errChan := startClient(settings)
+	_, ok := secure.WaitForCommand(girc.JOIN, "#bridge", 5*time.Second)
+	assert.True(t, ok, "client should reconnect with TLS on the STS port")
+	assert.Equal(t, 0, countJoins(plain, "#bridge"), "client should not join over plaintext")
+	client.Close()
+	require.NoError(t, waitForError(t, errChan))
+
+	data, err := os.ReadFile(settings.STSFile)
+	require.NoError(t, err)
+	policies := map[string]stsPolicy{}
+	require.NoError(t, json.Unmarshal(data, &policies))
+	assert.Equal(t, secure.Port, policies[plain.Host].Port)
+
+	// After a restart, the saved policy is used without asking the
+	// plaintext server first
+	received := len(plain.Received())
+	client, errChan = startClient(settings)
+	assert.Eventually(t, func() bool { return countJoins(secure, "#bridge") == 2 },
+		5*time.Second, 10*time.Millisecond, "client should connect with TLS right away")
+	assert.Len(t, plain.Received(), received, "client should not connect over plaintext")
+	client.Close()
+	assert.NoError(t, waitForError(t, errChan))
+}
@@ -0,0 +1,206 @@
+package irc
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ritlug/teleirc/internal"
+)
+
+// tlsVersions maps the IRC_TLS_MIN_VERSION values to TLS versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// defaultTLSMinVersion is used when no minimum TLS version is configured
+const defaultTLSMinVersion = "1.2"
+
+/*
+newTLSConfig builds the TLS configuration for the IRC connection to host
+from the settings
+*/
+func newTLSConfig(settings *internal.IRCSettings, host string) (*tls.Config, error) {
+	minVersion := settings.TLSMinVersion
+	if minVersion == "" {
+		minVersion = defaultTLSMinVersion
+	}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q", minVersion)
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if settings.TLSCAFile != "" {
+		pem, err := os.ReadFile(settings.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", settings.TLSCAFile)
+		}
+	}
+
+	var certificates []tls.Certificate
+	if settings.TLSClientCert != "" {
+		keyFile := settings.TLSClientKey
+		if keyFile == "" {
+			// The key may be kept in the same file as the certificate
+			keyFile = settings.TLSClientCert
+		}
+		certificate, err := tls.LoadX509KeyPair(settings.TLSClientCert, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load IRC client certificate: %w", err)
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	pins := map[string]bool{}
+	for _, pin := range settings.TLSPins {
+		if pin == "" {
+			continue
+		}
+		pins[strings.TrimPrefix(pin, "sha256/")] = true
+	}
+
+	serverName := settings.TLSServerName
+	if serverName == "" {
+		serverName = host
+	}
+
+	verifier := &certVerifier{
+		roots:        roots,
+		serverName:   serverName,
+		allowSelf:    settings.TLSAllowSelfSigned,
+		allowExpired: settings.TLSAllowCertExpired,
+		pins:         pins,
+	}
+
+	return &tls.Config{
+		ServerName:   serverName,
+		MinVersion:   version,
+		Certificates: certificates,
+		// The standard verification can't relax single checks, so it is
+		// replaced by certVerifier
+		InsecureSkipVerify: true, //nolint:gosec
+		VerifyConnection:   verifier.verify,
+	}, nil
+}
+
+/*
+certVerifier checks the certificate chain of the IRC server, relaxing the
+checks the settings allow
+*/
+type certVerifier struct {
+	roots        *x509.CertPool
+	serverName   string
+	allowSelf    bool
+	allowExpired bool
+	pins         map[string]bool
+}
+
+/*
+verify checks the certificates presented by the server during the handshake
+*/
+func (v *certVerifier) verify(state tls.ConnectionState) error {
+	certs := state.PeerCertificates
+	if len(certs) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		DNSName:       v.serverName,
+	}
+
+	err := v.verifyChain(certs, opts)
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) && v.allowSelf {
+		// Trust the chain the server presented, but keep every other check
+		opts.Roots = x509.NewCertPool()
+		opts.Roots.AddCert(certs[len(certs)-1])
+		err = v.verifyChain(certs, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(v.pins) > 0 {
+		for _, cert := range certs {
+			if v.pins[spkiHash(cert)] {
+				return nil
+			}
+		}
+		return fmt.Errorf("no certificate matches the pinned public keys (server presented %s)",
+			spkiHash(certs[0]))
+	}
+	return nil
+}
+
+/*
+verifyChain verifies the chain and, if expired certificates are allowed,
+retries at a time every certificate in the chain was valid
+*/
+func (v *certVerifier) verifyChain(certs []*x509.Certificate, opts x509.VerifyOptions) error {
+	_, err := certs[0].Verify(opts)
+	var invalid x509.CertificateInvalidError
+	if !errors.As(err, &invalid) || invalid.Reason != x509.Expired || !v.allowExpired {
+		return err
+	}
+
+	notBefore, notAfter := certs[0].NotBefore, certs[0].NotAfter
+	for _, cert := range certs[1:] {
+		if cert.NotBefore.After(notBefore) {
+			notBefore = cert.NotBefore
+		}
+		if cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	if notAfter.Before(notBefore) {
+		return err
+	}
+	opts.CurrentTime = notAfter.Add(-time.Second)
+	if opts.CurrentTime.Before(notBefore) {
+		opts.CurrentTime = notBefore
+	}
+	_, err = certs[0].Verify(opts)
+	return err
+}
+
+/*
+spkiHash returns the base64 encoded SHA-256 hash of the certificate's
+public key, the format used for IRC_CERT_PINS
+*/
+func spkiHash(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+/*
+certFingerprint returns the hex encoded SHA-512 fingerprint of a client
+certificate, the form most networks expect when adding it to an account
+*/
+func certFingerprint(certificate tls.Certificate) string {
+	hash := sha512.Sum512(certificate.Certificate[0])
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,234 @@
+package irc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ritlug/teleirc/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+/*
+newTestCert creates a certificate for host, signed by parent or self-signed
+if parent is nil
+*/
+func newTestCert(t *testing.T, host string, isCA bool, notAfter time.Time, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: host},
+		NotBefore:             notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if !isCA {
+		template.DNSNames = []string{host}
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+/*
+writeCAFile writes the certificate to a PEM file and returns its path
+*/
+func writeCAFile(t *testing.T, ca *testCert) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+/*
+handshake runs a TLS handshake between a server presenting chain and a
+client using the configuration built from settings
+*/
+func handshake(t *testing.T, settings *internal.IRCSettings, serverConfig *tls.Config, chain ...*testCert) error {
+	t.Helper()
+	config, err := newTLSConfig(settings, settings.Server)
+	require.NoError(t, err)
+
+	var certificate tls.Certificate
+	for _, c := range chain {
+		certificate.Certificate = append(certificate.Certificate, c.cert.Raw)
+	}
+	certificate.PrivateKey = chain[0].key
+	if serverConfig == nil {
+		serverConfig = &tls.Config{}
+	}
+	serverConfig.Certificates = []tls.Certificate{certificate}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	client := tls.Client(conn, config)
+	err = client.Handshake()
+	client.Close()
+	<-serverDone
+	return err
+}
+
+func TestTLSConfigDefaults(t *testing.T) {
+	config, err := newTLSConfig(&internal.IRCSettings{}, "irc.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "irc.example.com", config.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+
+	config, err = newTLSConfig(&internal.IRCSettings{
+		TLSServerName: "irc.example.com",
+		TLSMinVersion: "1.3",
+	}, "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "irc.example.com", config.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	_, err := newTLSConfig(&internal.IRCSettings{TLSMinVersion: "2.0"}, "irc.example.com")
+	assert.Error(t, err)
+
+	_, err = newTLSConfig(&internal.IRCSettings{TLSCAFile: filepath.Join(t.TempDir(), "missing.pem")}, "irc.example.com")
+	assert.Error(t, err)
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("not a certificate"), 0o600))
+	_, err = newTLSConfig(&internal.IRCSettings{TLSCAFile: empty}, "irc.example.com")
+	assert.Error(t, err)
+}
+
+func TestTLSCustomCA(t *testing.T) {
+	valid := time.Now().Add(24 * time.Hour)
+	ca := newTestCert(t, "Test CA", true, valid, nil)
+	leaf := newTestCert(t, "irc.example.com", false, valid, ca)
+
+	err := handshake(t, &internal.IRCSettings{Server: "irc.example.com"}, nil, leaf, ca)
+	assert.Error(t, err, "unknown CA should be rejected")
+
+	settings := &internal.IRCSettings{Server: "irc.example.com", TLSCAFile: writeCAFile(t, ca)}
+	assert.NoError(t, handshake(t, settings, nil, leaf, ca))
+
+	settings.Server = "irc.other.com"
+	assert.Error(t, handshake(t, settings, nil, leaf, ca), "hostname mismatch should be rejected")
+}
+
+func TestTLSAllowSelfSigned(t *testing.T) {
+	self := newTestCert(t, "irc.example.com", false, time.Now().Add(24*time.Hour), nil)
+	expired := newTestCert(t, "irc.example.com", false, time.Now().Add(-time.Hour), nil)
+
+	settings := &internal.IRCSettings{Server: "irc.example.com"}
+	assert.Error(t, handshake(t, settings, nil, self))
+
+	settings.TLSAllowSelfSigned = true
+	assert.NoError(t, handshake(t, settings, nil, self))
+	assert.Error(t, handshake(t, settings, nil, expired), "expiry should still be checked")
+
+	settings.Server = "irc.other.com"
+	assert.Error(t, handshake(t, settings, nil, self), "hostname should still be checked")
+}
+
+func TestTLSAllowExpired(t *testing.T) {
+	ca := newTestCert(t, "Test CA", true, time.Now().Add(24*time.Hour), nil)
+	expired := newTestCert(t, "irc.example.com", false, time.Now().Add(-time.Hour), ca)
+	self := newTestCert(t, "irc.example.com", false, time.Now().Add(24*time.Hour), nil)
+
+	settings := &internal.IRCSettings{Server: "irc.example.com", TLSCAFile: writeCAFile(t, ca)}
+	assert.Error(t, handshake(t, settings, nil, expired, ca))
+
+	settings.TLSAllowCertExpired = true
+	assert.NoError(t, handshake(t, settings, nil, expired, ca))
+	assert.Error(t, handshake(t, settings, nil, self), "unknown CA should still be rejected")
+
+	settings.TLSAllowSelfSigned = true
+	selfExpired := newTestCert(t, "irc.example.com", false, time.Now().Add(-time.Hour), nil)
+	assert.NoError(t, handshake(t, settings, nil, selfExpired))
+}
+
+func TestTLSPins(t *testing.T) {
+	valid := time.Now().Add(24 * time.Hour)
+	ca := newTestCert(t, "Test CA", true, valid, nil)
+	leaf := newTestCert(t, "irc.example.com", false, valid, ca)
+	other := newTestCert(t, "irc.example.com", false, valid, nil)
+
+	settings := &internal.IRCSettings{
+		Server:    "irc.example.com",
+		TLSCAFile: writeCAFile(t, ca),
+		TLSPins:   []string{"sha256/" + spkiHash(leaf.cert)},
+	}
+	assert.NoError(t, handshake(t, settings, nil, leaf, ca))
+
+	settings.TLSPins = []string{spkiHash(ca.cert)}
+	assert.NoError(t, handshake(t, settings, nil, leaf, ca), "pinning the CA should work")
+
+	settings.TLSPins = []string{spkiHash(other.cert)}
+	assert.Error(t, handshake(t, settings, nil, leaf, ca))
+}
+
+func TestTLSServerNameAndVersion(t *testing.T) {
+	self := newTestCert(t, "irc.example.com", false, time.Now().Add(24*time.Hour), nil)
+
+	var sni string
+	serverConfig := &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni = hello.ServerName
+			return nil, nil
+		},
+	}
+	settings := &internal.IRCSettings{
+		Server:             "127.0.0.1",
+		TLSServerName:      "irc.example.com",
+		TLSAllowSelfSigned: true,
+	}
+	assert.NoError(t, handshake(t, settings, serverConfig, self))
+	assert.Equal(t, "irc.example.com", sni)
+
+	settings.TLSMinVersion = "1.3"
+	serverConfig = &tls.Config{MaxVersion: tls.VersionTLS12}
+	assert.Error(t, handshake(t, settings, serverConfig, self))
+}
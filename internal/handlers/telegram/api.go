@@ -0,0 +1,118 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/metrics"
+	"github.com/ritlug/teleirc/internal/proxy"
+)
+
+// defaultServerURL is the official Telegram Bot API server
+const defaultServerURL = "https://api.telegram.org"
+
+/*
+apiOptions returns the bot options that point the client at the configured
+Bot API server, using an HTTP client built from the settings
+*/
+func (tg *Client) apiOptions() ([]tgbotapi.Option, error) {
+	settings := tg.settings()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if settings.HTTPProxy == proxy.Direct {
+		transport.Proxy = nil
+	} else if settings.HTTPProxy != "" {
+		proxyURL, err := url.Parse(settings.HTTPProxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	client := &http.Client{
+		Timeout:   settings.HTTPTimeout,
+		Transport: pollTimer{transport: transport, status: tg.status},
+	}
+
+	opts := []tgbotapi.Option{
+		// Long polling requests have to finish before the HTTP client gives up
+		tgbotapi.WithHTTPClient(settings.HTTPTimeout, client),
+	}
+	if settings.APIURL != "" {
+		opts = append(opts, tgbotapi.WithServerURL(tg.serverURL()))
+	}
+	return opts, nil
+}
+
+/*
+serverURL returns the base URL of the Bot API server in use
+*/
+func (tg *Client) serverURL() string {
+	apiURL := tg.settings().APIURL
+	if apiURL == "" {
+		return defaultServerURL
+	}
+	return strings.TrimSuffix(apiURL, "/")
+}
+
+/*
+fileDownloadLink returns the URL a file can be downloaded from. Bot API servers
+in local mode return the absolute path of the file on their disk, below a
+directory named after the bot token. That part of the path is what the
+server's file endpoint expects. Files elsewhere on the server's disk cannot
+be downloaded.
+*/
+func (tg *Client) fileDownloadLink(f *models.File) (string, error) {
+	filePath := f.FilePath
+	if path.IsAbs(filePath) {
+		tokenDir := "/" + tg.settings().Token + "/"
+		i := strings.Index(filePath, tokenDir)
+		if i < 0 {
+			return "", fmt.Errorf("file %s is outside of the Bot API server's directory for the bot", filePath)
+		}
+		filePath = filePath[i+len(tokenDir):]
+	}
+	return tg.serverURL() + "/file/bot" + tg.settings().Token + "/" + filePath, nil
+}
+
+/*
+FileDownloadLink looks up a file by its ID and returns the URL it can be
+downloaded from
+*/
+func (tg *Client) FileDownloadLink(ctx context.Context, fileID string) (string, error) {
+	f, err := tg.api().GetFile(ctx, &tgbotapi.GetFileParams{FileID: fileID})
+	if err != nil {
+		return "", err
+	}
+	return tg.fileDownloadLink(f)
+}
+
+/*
+pollTimer records how long getUpdates requests take, and when one last
+succeeded
+*/
+type pollTimer struct {
+	transport http.RoundTripper
+	status    *internal.Status
+}
+
+func (t pollTimer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(req.URL.Path, "/getUpdates") {
+		return t.transport.RoundTrip(req)
+	}
+	start := time.Now()
+	resp, err := t.transport.RoundTrip(req)
+	metrics.TelegramPollDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		t.status.SetError(err)
+	} else if resp.StatusCode == http.StatusOK {
+		t.status.Polled()
+	}
+	return resp, err
+}
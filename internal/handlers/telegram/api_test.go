@@ -0,0 +1,127 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/proxy"
+	"github.com/ritlug/teleirc/internal/testing/fakeproxy"
+	"github.com/ritlug/teleirc/internal/testing/faketelegram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "000000000:AAAAAAaAAa2AaAAaoAAAA-a_aaAAaAaaaAA"
+
+func TestFileDownloadLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		apiURL   string
+		filePath string
+		expected string
+	}{
+		{
+			name:     "official",
+			filePath: "photos/file_1.jpg",
+			expected: "https://api.telegram.org/file/bot" + testToken + "/photos/file_1.jpg",
+		},
+		{
+			name:     "self-hosted",
+			apiURL:   "http://localhost:8081/",
+			filePath: "photos/file_1.jpg",
+			expected: "http://localhost:8081/file/bot" + testToken + "/photos/file_1.jpg",
+		},
+		{
+			name:     "local mode",
+			apiURL:   "http://localhost:8081",
+			filePath: "/var/lib/telegram-bot-api/" + testToken + "/photos/file_1.jpg",
+			expected: "http://localhost:8081/file/bot" + testToken + "/photos/file_1.jpg",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &Client{Settings: &internal.TelegramSettings{Token: testToken, APIURL: test.apiURL}}
+			link, err := client.fileDownloadLink(&models.File{FilePath: test.filePath})
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, link)
+		})
+	}
+
+	// A local mode server outside of the token directory has no URL for it
+	client := &Client{Settings: &internal.TelegramSettings{Token: testToken, APIURL: "http://localhost:8081"}}
+	link, err := client.fileDownloadLink(&models.File{FilePath: "/tmp/file_1.jpg"})
+	assert.ErrorContains(t, err, "/tmp/file_1.jpg")
+	assert.Empty(t, link)
+}
+
+func TestAPIOptionsServerURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/bot"+testToken+"/getFile", r.URL.Path)
+		fmt.Fprint(w, `{"ok":true,"result":{"file_id":"abc","file_path":"documents/file_2.pdf"}}`)
+	}))
+	defer server.Close()
+
+	client := &Client{Settings: &internal.TelegramSettings{
+		Token:       testToken,
+		APIURL:      server.URL,
+		HTTPTimeout: 5 * time.Second,
+	}}
+	opts, err := client.apiOptions()
+	assert.NoError(t, err)
+	client.API, err = tgbotapi.New(testToken, append(opts, tgbotapi.WithSkipGetMe())...)
+	assert.NoError(t, err)
+
+	link, err := client.FileDownloadLink(context.Background(), "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/file/bot"+testToken+"/documents/file_2.pdf", link)
+}
+
+func TestAPIOptionsInvalidProxy(t *testing.T) {
+	client := &Client{Settings: &internal.TelegramSettings{HTTPProxy: "://nope"}}
+	_, err := client.apiOptions()
+	assert.Error(t, err)
+}
+
+func TestAPIOptionsProxy(t *testing.T) {
+	proxies := map[string]func() (*fakeproxy.Server, error){
+		"socks5": fakeproxy.NewSOCKS5,
+		"http":   fakeproxy.NewHTTP,
+	}
+	for name, newProxy := range proxies {
+		t.Run(name, func(t *testing.T) {
+			fake := faketelegram.New(testToken)
+			defer fake.Close()
+			fakeURL, err := url.Parse(fake.URL)
+			require.NoError(t, err)
+			p, err := newProxy()
+			require.NoError(t, err)
+			defer p.Close()
+			p.Username = "user"
+			p.Password = "secret"
+
+			client := newFakeClient(t, fake)
+			client.Settings.HTTPProxy = p.URL()
+			connectFakeClient(t, client)
+			client.SendMessage("<robin> hi")
+
+			assert.Len(t, fake.CallsTo("sendMessage"), 1)
+			assert.Equal(t, []string{fakeURL.Host}, p.Targets())
+		})
+	}
+}
+
+func TestAPIOptionsDirect(t *testing.T) {
+	client := &Client{Settings: &internal.TelegramSettings{HTTPProxy: proxy.Direct}}
+	opts, err := client.apiOptions()
+	assert.NoError(t, err)
+	assert.Len(t, opts, 1)
+}
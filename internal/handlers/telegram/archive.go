@@ -0,0 +1,54 @@
+package telegram
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/ritlug/teleirc/internal/archive"
+)
+
+/*
+messageEvent describes a message for the archive. The kind and the text
+are filled in by relay.
+*/
+func messageEvent(msg *models.Message) archive.Event {
+	e := archive.Event{
+		Time:       time.Unix(int64(msg.Date), 0),
+		Chat:       strconv.FormatInt(msg.Chat.ID, 10),
+		SenderName: GetSenderName(false, msg),
+		MessageID:  strconv.Itoa(msg.ID),
+	}
+	switch {
+	case msg.SenderChat != nil:
+		e.SenderID = strconv.FormatInt(msg.SenderChat.ID, 10)
+	case msg.From != nil:
+		e.SenderID = strconv.FormatInt(msg.From.ID, 10)
+	}
+	if msg.ReplyToMessage != nil {
+		e.ReplyTo = strconv.Itoa(msg.ReplyToMessage.ID)
+	}
+	if msg.EditDate > 0 {
+		e.Time = time.Unix(int64(msg.EditDate), 0)
+		e.EditOf = e.MessageID
+	}
+	switch {
+	case msg.Document != nil:
+		e.Media = msg.Document.FileID
+	case msg.Location != nil:
+		e.Media = "geo:" + strconv.FormatFloat(msg.Location.Latitude, 'f', -1, 64) +
+			"," + strconv.FormatFloat(msg.Location.Longitude, 'f', -1, 64)
+	}
+	return e
+}
+
+/*
+userEvent describes something a user did in the group, such as joining,
+for the archive
+*/
+func userEvent(user *models.User) archive.Event {
+	return archive.Event{
+		SenderID:   strconv.FormatInt(user.ID, 10),
+		SenderName: GetFullUsername(false, user),
+	}
+}
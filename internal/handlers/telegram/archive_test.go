@@ -0,0 +1,133 @@
+package telegram
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/archive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+newArchivingClient returns a client for chat 100 that archives what it
+relays
+*/
+func newArchivingClient(t *testing.T) *Client {
+	a, err := archive.Open(filepath.Join(t.TempDir(), "archive.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { a.Close() })
+	return &Client{
+		Settings: &internal.TelegramSettings{
+			Prefix:      "<",
+			Suffix:      ">",
+			ReplyPrefix: "[",
+			ReplySuffix: "]",
+			ReplyLength: 15,
+			ChatID:      100,
+		},
+		IRCSettings: &internal.IRCSettings{
+			ShowJoinMessage:     true,
+			ShowLocationMessage: true,
+		},
+		Archive:   a,
+		sendToIrc: func(string) {},
+	}
+}
+
+func archived(t *testing.T, client *Client) []archive.Event {
+	events, err := client.Archive.Search(archive.Query{})
+	require.NoError(t, err)
+	for i := range events {
+		events[i].ID = 0
+	}
+	return events
+}
+
+func TestArchiveReply(t *testing.T) {
+	client := newArchivingClient(t)
+	date := time.Now().Truncate(time.Second)
+	messageHandler(client)(client.ctx, client.API, &models.Update{
+		Message: &models.Message{
+			ID:   11,
+			Date: int(date.Unix()),
+			From: &models.User{ID: 2, Username: "batman"},
+			Text: "on it",
+			Chat: models.Chat{ID: 100},
+			ReplyToMessage: &models.Message{
+				ID:   10,
+				From: &models.User{ID: 1, Username: "robin"},
+				Text: "deploy failed",
+				Chat: models.Chat{ID: 100},
+			},
+		},
+	})
+
+	events := archived(t, client)
+	if assert.Len(t, events, 1) {
+		assert.True(t, date.Equal(events[0].Time))
+		events[0].Time = time.Time{}
+		assert.Equal(t, archive.Event{
+			Side:       archive.Telegram,
+			Chat:       "100",
+			Kind:       "reply",
+			SenderID:   "2",
+			SenderName: "batman",
+			Text:       "<batman> [Re robin: deploy failed] on it",
+			MessageID:  "11",
+			ReplyTo:    "10",
+		}, events[0])
+	}
+}
+
+func TestArchiveEdit(t *testing.T) {
+	client := newArchivingClient(t)
+	messageHandler(client)(client.ctx, client.API, &models.Update{
+		EditedMessage: &models.Message{
+			ID:       12,
+			Date:     int(time.Now().Add(-time.Hour).Unix()),
+			EditDate: int(time.Now().Unix()),
+			From:     &models.User{ID: 2, Username: "batman"},
+			Text:     "fixed",
+			Chat:     models.Chat{ID: 100},
+		},
+	})
+
+	events := archived(t, client)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "edit", events[0].Kind)
+		assert.Equal(t, "12", events[0].EditOf)
+		assert.WithinDuration(t, time.Now(), events[0].Time, 2*time.Second)
+	}
+}
+
+func TestArchiveJoin(t *testing.T) {
+	client := newArchivingClient(t)
+	joinHandler(client, &[]models.User{{ID: 3, FirstName: "Alfred", Username: "alfred"}})
+
+	events := archived(t, client)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "100", events[0].Chat)
+		assert.Equal(t, "3", events[0].SenderID)
+		assert.Equal(t, "Alfred (@alfred)", events[0].SenderName)
+		assert.Equal(t, "join", events[0].Kind)
+	}
+}
+
+func TestArchiveLocation(t *testing.T) {
+	client := newArchivingClient(t)
+	locationHandler(client, &models.Message{
+		ID:       13,
+		Chat:     models.Chat{ID: 100},
+		From:     &models.User{ID: 2, Username: "batman"},
+		Location: &models.Location{Latitude: 43.0845274, Longitude: -77.6781174},
+	})
+
+	events := archived(t, client)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "geo:43.0845274,-77.6781174", events[0].Media)
+	}
+}
@@ -9,6 +9,9 @@ import (
 
 	tgbotapi "github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
+	"github.com/ritlug/teleirc/internal/archive"
+	"github.com/ritlug/teleirc/internal/history"
+	"github.com/ritlug/teleirc/internal/metrics"
 )
 
 /*
@@ -31,29 +34,66 @@ func messageHandler(tg *Client) tgbotapi.HandlerFunc {
 			msg = u.Message
 		case u.EditedMessage != nil:
 			msg = u.EditedMessage
+		case u.ChannelPost != nil:
+			msg = u.ChannelPost
 		default:
 			tg.logger.LogWarning("received empty message from server")
 			return
 		}
 
+		// The group was upgraded to a supergroup. Telegram sends the old
+		// group a message pointing to the new chat and vice versa.
+		if msg.MigrateToChatID != 0 {
+			tg.migrateChat(msg.Chat.ID, msg.MigrateToChatID)
+			return
+		}
+		if msg.MigrateFromChatID != 0 {
+			tg.migrateChat(msg.MigrateFromChatID, msg.Chat.ID)
+			return
+		}
+
 		if msg.EditDate == 0 {
 			// edited message might be old, so only check for new messages
 			date := time.Unix(int64(msg.Date), 0)
 			if since := time.Since(date); since > time.Minute {
-				tg.logger.LogWarning("received message was %s old, ignoring sending", since)
+				metrics.MessagesDropped.Inc(metrics.TelegramToIRC, metrics.Stale)
+				tg.logger.With("event", "message", "chat_id", msg.Chat.ID).
+					LogWarning("received message was %s old, ignoring sending", since)
+				return
+			}
+		}
+
+		if msg.Chat.Type == models.ChatTypePrivate && tg.History != nil {
+			if n, ok := history.ParseCommand(msg.Text, historyCommand, history.DefaultLines); ok {
+				historyHandler(ctx, tg, msg, n)
 				return
 			}
 		}
 
-		username := GetUsername(tg.IRCSettings.ShowZWSP, msg.From)
+		username := GetSenderName(tg.ircSettings().ShowZWSP, msg)
 
-		if tg.IRCSettings.NoForwardPrefix != "" && strings.HasPrefix(msg.Text, tg.IRCSettings.NoForwardPrefix) {
+		if tg.ircSettings().NoForwardPrefix != "" && strings.HasPrefix(msg.Text, tg.ircSettings().NoForwardPrefix) {
+			metrics.MessagesDropped.Inc(metrics.TelegramToIRC, metrics.NoForwardPrefix)
+			return
+		}
+
+		if u.ChannelPost != nil {
+			channelPostHandler(tg, msg)
 			return
 		}
 
 		// Don't forward messages to IRC that didn't come from the
 		// chat we're bridging
-		if msg.Chat.ID != tg.Settings.ChatID {
+		if msg.Chat.ID != tg.chatID() {
+			metrics.MessagesDropped.Inc(metrics.TelegramToIRC, metrics.OtherChat)
+			return
+		}
+		tg.status.MessageReceived()
+
+		// Posts of the linked channel are automatically forwarded to the
+		// discussion group. They were already relayed as a channel post.
+		if msg.IsAutomaticForward && msg.SenderChat != nil &&
+			tg.settings().ChannelID != 0 && msg.SenderChat.ID == tg.settings().ChannelID {
 			return
 		}
 
@@ -69,27 +109,50 @@ func messageHandler(tg *Client) tgbotapi.HandlerFunc {
 		}
 
 		formatted = formatted + fmt.Sprintf("%s%s%s %s",
-			tg.Settings.Prefix,
+			tg.settings().Prefix,
 			username,
-			tg.Settings.Suffix,
+			tg.settings().Suffix,
 			// Trim unexpected trailing whitespace
 			strings.Trim(msg.Text, " "))
 
-		tg.sendToIrc(formatted)
+		kind := "text"
+		if msg.EditDate > 0 {
+			kind = "edit"
+		}
+		tg.relay(messageEvent(msg), kind, formatted)
 	}
 }
 
+/*
+relay sends a message about a Telegram update of the given kind to IRC,
+and keeps the update described by e in the scrollback and the archive
+*/
+func (tg *Client) relay(e archive.Event, kind, msg string) {
+	metrics.MessagesRelayed.Inc(metrics.TelegramToIRC, kind)
+	tg.History.Add(history.Entry{Time: e.Time, Side: history.Telegram, Kind: kind, Text: msg})
+	if tg.Archive != nil {
+		e.Side, e.Kind, e.Text = archive.Telegram, kind, msg
+		if e.Chat == "" {
+			e.Chat = strconv.FormatInt(tg.chatID(), 10)
+		}
+		if err := tg.Archive.Add(e); err != nil {
+			tg.logger.LogError("Could not archive update: %s", err)
+		}
+	}
+	tg.sendToIrc(msg)
+}
+
 /*
 replyHandler handles when users reply to a Telegram message
 */
 func replyHandler(tg *Client, msg *models.Message) {
 	replyText := strings.Trim(msg.ReplyToMessage.Text, " ")
-	username := GetUsername(tg.IRCSettings.ShowZWSP, msg.From)
-	replyUser := GetUsername(tg.IRCSettings.ShowZWSP, msg.ReplyToMessage.From)
+	username := GetSenderName(tg.ircSettings().ShowZWSP, msg)
+	replyUser := GetSenderName(tg.ircSettings().ShowZWSP, msg.ReplyToMessage)
 
 	// Only show a portion of the reply text
-	if replyTextAsRunes := []rune(replyText); len(replyTextAsRunes) > tg.Settings.ReplyLength {
-		replyText = string(replyTextAsRunes[:tg.Settings.ReplyLength]) + "…"
+	if replyTextAsRunes := []rune(replyText); len(replyTextAsRunes) > tg.settings().ReplyLength {
+		replyText = string(replyTextAsRunes[:tg.settings().ReplyLength]) + "…"
 	}
 
 	var replyMsg string
@@ -99,49 +162,70 @@ func replyHandler(tg *Client, msg *models.Message) {
 		if msg.ReplyToMessage.ForumTopicCreated != nil {
 			// It was directly to topic, ie. not a reply
 			replyMsg = fmt.Sprintf("%sTopic: %s%s",
-				tg.Settings.ReplyPrefix,
+				tg.settings().ReplyPrefix,
 				msg.ReplyToMessage.ForumTopicCreated.Name,
-				tg.Settings.ReplySuffix)
+				tg.settings().ReplySuffix)
 		} else {
 			// It was a reply in topic so we do not know the topic name
 			replyMsg = fmt.Sprintf("%sTopic Re %s: %s%s",
-				tg.Settings.ReplyPrefix,
+				tg.settings().ReplyPrefix,
 				replyUser,
 				replyText,
-				tg.Settings.ReplySuffix)
+				tg.settings().ReplySuffix)
 		}
 	} else {
 		// Reply in generic channel
 		replyMsg = fmt.Sprintf("%sRe %s: %s%s",
-			tg.Settings.ReplyPrefix,
+			tg.settings().ReplyPrefix,
 			replyUser,
 			replyText,
-			tg.Settings.ReplySuffix)
+			tg.settings().ReplySuffix)
 	}
 	formatted := ""
 	if msg.EditDate > 0 {
 		formatted = "edit: "
 	}
 	formatted = formatted + fmt.Sprintf("%s%s%s %s %s",
-		tg.Settings.Prefix,
+		tg.settings().Prefix,
 		username,
-		tg.Settings.Suffix,
+		tg.settings().Suffix,
 		replyMsg,
 		msg.Text)
 
-	tg.sendToIrc(formatted)
+	tg.relay(messageEvent(msg), "reply", formatted)
+}
+
+/*
+channelPostHandler handles posts in the linked broadcast channel, which are
+relayed to IRC as announcements
+*/
+func channelPostHandler(tg *Client, msg *models.Message) {
+	if tg.settings().ChannelID == 0 || msg.Chat.ID != tg.settings().ChannelID {
+		return
+	}
+
+	text := msg.Text
+	if text == "" {
+		text = msg.Caption
+	}
+	if text == "" {
+		return
+	}
+
+	formatted := fmt.Sprintf("Announcement from %s: %s", msg.Chat.Title, strings.Trim(text, " "))
+	tg.relay(messageEvent(msg), "channel_post", formatted)
 }
 
 /*
 joinHandler handles when users join the Telegram group
 */
 func joinHandler(tg *Client, users *[]models.User) {
-	if tg.IRCSettings.ShowJoinMessage {
+	if tg.ircSettings().ShowJoinMessage {
 		for _, user := range *users {
 			user := user
-			username := GetFullUsername(tg.IRCSettings.ShowZWSP, &user)
+			username := GetFullUsername(tg.ircSettings().ShowZWSP, &user)
 			formatted := username + " has joined the Telegram Group!"
-			tg.sendToIrc(formatted)
+			tg.relay(userEvent(&user), "join", formatted)
 		}
 	}
 }
@@ -150,11 +234,11 @@ func joinHandler(tg *Client, users *[]models.User) {
 partHandler handles when users leave the Telegram group
 */
 func partHandler(tg *Client, user *models.User) {
-	if tg.IRCSettings.ShowLeaveMessage {
-		username := GetFullUsername(tg.IRCSettings.ShowZWSP, user)
+	if tg.ircSettings().ShowLeaveMessage {
+		username := GetFullUsername(tg.ircSettings().ShowZWSP, user)
 		formatted := username + " has left the Telegram Group!"
 
-		tg.sendToIrc(formatted)
+		tg.relay(userEvent(user), "part", formatted)
 	}
 }
 
@@ -163,13 +247,13 @@ stickerHandler handles the Message.Sticker Telegram Object, which formats the
 Telegram message into its base Emoji unicode character.
 */
 func stickerHandler(tg *Client, u models.Update) {
-	username := GetUsername(tg.IRCSettings.ShowZWSP, u.Message.From)
+	username := GetSenderName(tg.ircSettings().ShowZWSP, u.Message)
 	formatted := fmt.Sprintf("%s%s%s %s",
-		tg.Settings.Prefix,
+		tg.settings().Prefix,
 		username,
-		tg.Settings.Suffix,
+		tg.settings().Suffix,
 		u.Message.Sticker.Emoji)
-	tg.sendToIrc(formatted)
+	tg.relay(messageEvent(u.Message), "sticker", formatted)
 }
 
 /*
@@ -179,7 +263,7 @@ exists, and sends notification to IRC
 // TODO: Not working yet
 // func photoHandler(tg *Client, u models.Update) {
 // 	link := uploadImage(tg, u)
-// 	username := GetUsername(tg.IRCSettings.ShowZWSP, u.Message.From)
+// 	username := GetUsername(tg.ircSettings().ShowZWSP, u.Message.From)
 // 	caption := u.Message.Caption
 // 	if caption == "" {
 // 		caption = "No caption provided."
@@ -199,7 +283,7 @@ documentHandler receives a document object from Telegram, and sends
 a notification to IRC.
 */
 func documentHandler(tg *Client, u *models.Message) {
-	username := GetUsername(tg.IRCSettings.ShowZWSP, u.From)
+	username := GetSenderName(tg.ircSettings().ShowZWSP, u)
 	formatted := username + " shared a file"
 	if u.Document.MimeType != "" {
 		formatted += " (" + u.Document.MimeType + ")"
@@ -211,7 +295,7 @@ func documentHandler(tg *Client, u *models.Message) {
 		formatted += " on Telegram with title: " + "'" + u.Document.FileName + "'."
 	}
 
-	tg.sendToIrc(formatted)
+	tg.relay(messageEvent(u), "document", formatted)
 }
 
 /*
@@ -219,11 +303,11 @@ locationHandler receivers a location object from Telegram, and sends
 a notification to IRC.
 */
 func locationHandler(tg *Client, u *models.Message) {
-	if !tg.IRCSettings.ShowLocationMessage {
+	if !tg.ircSettings().ShowLocationMessage {
 		return
 	}
 
-	username := GetUsername(tg.IRCSettings.ShowZWSP, u.From)
+	username := GetSenderName(tg.ircSettings().ShowZWSP, u)
 	formatted := username + " shared their location: ("
 
 	// f means do not use an exponent.
@@ -234,5 +318,5 @@ func locationHandler(tg *Client, u *models.Message) {
 	formatted += strconv.FormatFloat(u.Location.Longitude, 'f', -1, 64)
 	formatted += ")."
 
-	tg.sendToIrc(formatted)
+	tg.relay(messageEvent(u), "location", formatted)
 }
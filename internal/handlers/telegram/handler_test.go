@@ -2,8 +2,10 @@ package telegram
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-telegram/bot/models"
 	"github.com/kyokomi/emoji"
@@ -614,6 +616,7 @@ func TestMessageRandomWithUsername(t *testing.T) {
 
 	updateObj := &models.Update{
 		Message: &models.Message{
+			Date: int(time.Now().Unix()),
 			From: testUser,
 			Text: "Random Text",
 			Chat: testChat,
@@ -653,6 +656,7 @@ func TestMessageRandomWithoutUsername(t *testing.T) {
 
 	updateObj := &models.Update{
 		Message: &models.Message{
+			Date: int(time.Now().Unix()),
 			From: testUser,
 			Text: "Random Text",
 			Chat: testChat,
@@ -687,6 +691,7 @@ func TestMessageRandomWithNoForward(t *testing.T) {
 
 	updateObj := &models.Update{
 		Message: &models.Message{
+			Date: int(time.Now().Unix()),
 			From: testUser,
 			Text: "[off] Random Text",
 			Chat: testChat,
@@ -724,6 +729,7 @@ func TestMessageZwsp(t *testing.T) {
 
 	updateObj := &models.Update{
 		Message: &models.Message{
+			Date: int(time.Now().Unix()),
 			From: testUser,
 			Text: "Random Text",
 			Chat: testChat,
@@ -773,6 +779,7 @@ func TestMessageReply(t *testing.T) {
 			updateFn: func() *models.Update {
 				return &models.Update{
 					Message: &models.Message{
+						Date: int(time.Now().Unix()),
 						From: replyUser,
 						Text: "Response Text",
 						Chat: testChat,
@@ -791,6 +798,7 @@ func TestMessageReply(t *testing.T) {
 			updateFn: func() *models.Update {
 				return &models.Update{
 					Message: &models.Message{
+						Date: int(time.Now().Unix()),
 						From: replyUser,
 						Text: "Response Text",
 						Chat: testChat,
@@ -809,6 +817,7 @@ func TestMessageReply(t *testing.T) {
 			updateFn: func() *models.Update {
 				return &models.Update{
 					Message: &models.Message{
+						Date: int(time.Now().Unix()),
 						From: replyUser,
 						Text: "Response Text",
 						Chat: testChat,
@@ -827,6 +836,7 @@ func TestMessageReply(t *testing.T) {
 			updateFn: func() *models.Update {
 				return &models.Update{
 					Message: &models.Message{
+						Date: int(time.Now().Unix()),
 						From: replyUser,
 						Text: "Response Text",
 						Chat: testChat,
@@ -890,6 +900,7 @@ func TestMessageReplyZwsp(t *testing.T) {
 
 	updateObj := &models.Update{
 		Message: &models.Message{
+			Date:           int(time.Now().Unix()),
 			From:           replyUser,
 			Text:           "Response Text",
 			Chat:           testChat,
@@ -929,6 +940,7 @@ func TestMessageFromWrongTelegramChat(t *testing.T) {
 
 	updateObj := &models.Update{
 		Message: &models.Message{
+			Date: int(time.Now().Unix()),
 			From: testUser,
 			Text: "Random Text",
 			Chat: testChat,
@@ -951,6 +963,217 @@ func TestMessageFromWrongTelegramChat(t *testing.T) {
 	messageHandler(clientObj)(clientObj.ctx, clientObj.API, updateObj)
 }
 
+/*
+TestMessageAnonymousAdmin tests that messages without a sending user are
+relayed using the name of the sender chat
+*/
+func TestMessageAnonymousAdmin(t *testing.T) {
+	correct := "<Gotham> Random Text"
+	updateObj := &models.Update{
+		Message: &models.Message{
+			Date:       int(time.Now().Unix()),
+			SenderChat: &models.Chat{ID: 100, Title: "Gotham"},
+			Text:       "Random Text",
+			Chat:       models.Chat{ID: 100, Title: "Gotham"},
+		},
+	}
+	clientObj := &Client{
+		Settings: &internal.TelegramSettings{
+			Prefix: "<",
+			Suffix: ">",
+			ChatID: 100,
+		},
+		IRCSettings: &internal.IRCSettings{},
+		sendToIrc: func(s string) {
+			assert.Equal(t, correct, s)
+		},
+	}
+
+	messageHandler(clientObj)(clientObj.ctx, clientObj.API, updateObj)
+}
+
+/*
+TestMessageChannelPost tests that posts in the linked channel are relayed
+as announcements
+*/
+func TestMessageChannelPost(t *testing.T) {
+	correct := "Announcement from Gotham News: Random Text"
+	updateObj := &models.Update{
+		ChannelPost: &models.Message{
+			Date: int(time.Now().Unix()),
+			Text: "Random Text",
+			Chat: models.Chat{ID: 200, Title: "Gotham News"},
+		},
+	}
+	sent := false
+	clientObj := &Client{
+		Settings: &internal.TelegramSettings{
+			ChatID:    100,
+			ChannelID: 200,
+		},
+		IRCSettings: &internal.IRCSettings{},
+		sendToIrc: func(s string) {
+			sent = true
+			assert.Equal(t, correct, s)
+		},
+	}
+
+	messageHandler(clientObj)(clientObj.ctx, clientObj.API, updateObj)
+	assert.True(t, sent, "channel post should be relayed")
+}
+
+/*
+TestMessageChannelPostDisabled tests that channel posts are ignored when
+no channel is configured
+*/
+func TestMessageChannelPostDisabled(t *testing.T) {
+	updateObj := &models.Update{
+		ChannelPost: &models.Message{
+			Date: int(time.Now().Unix()),
+			Text: "Random Text",
+			Chat: models.Chat{ID: 200, Title: "Gotham News"},
+		},
+	}
+	clientObj := &Client{
+		Settings: &internal.TelegramSettings{
+			ChatID: 100,
+		},
+		IRCSettings: &internal.IRCSettings{},
+		sendToIrc: func(s string) {
+			assert.Fail(t, "channel posts should not be relayed")
+		},
+	}
+
+	messageHandler(clientObj)(clientObj.ctx, clientObj.API, updateObj)
+}
+
+/*
+TestMessageAutomaticForward tests that automatic forwards of relayed
+channel posts are not relayed twice
+*/
+func TestMessageAutomaticForward(t *testing.T) {
+	updateObj := &models.Update{
+		Message: &models.Message{
+			Date:               int(time.Now().Unix()),
+			From:               &models.User{ID: 777000, FirstName: "Telegram"},
+			SenderChat:         &models.Chat{ID: 200, Title: "Gotham News"},
+			IsAutomaticForward: true,
+			Text:               "Random Text",
+			Chat:               models.Chat{ID: 100},
+		},
+	}
+	clientObj := &Client{
+		Settings: &internal.TelegramSettings{
+			ChatID:    100,
+			ChannelID: 200,
+		},
+		IRCSettings: &internal.IRCSettings{},
+		sendToIrc: func(s string) {
+			assert.Fail(t, "automatic forwards should not be relayed twice")
+		},
+	}
+
+	messageHandler(clientObj)(clientObj.ctx, clientObj.API, updateObj)
+}
+
+/*
+TestMessageMigrateToSupergroup tests that the bridge follows a group
+that was upgraded to a supergroup and saves the new chat ID
+*/
+func TestMessageMigrateToSupergroup(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	updateObj := &models.Update{
+		Message: &models.Message{
+			Date:            int(time.Now().Unix()),
+			Chat:            models.Chat{ID: -100},
+			MigrateToChatID: -1000000000100,
+		},
+	}
+	clientObj := &Client{
+		Settings: &internal.TelegramSettings{
+			ChatID:    -100,
+			StateFile: stateFile,
+		},
+		IRCSettings: &internal.IRCSettings{},
+		logger:      internal.Debug{},
+		sendToIrc: func(s string) {
+			assert.Fail(t, "migration messages should not be relayed")
+		},
+	}
+
+	messageHandler(clientObj)(clientObj.ctx, clientObj.API, updateObj)
+
+	assert.Equal(t, int64(-1000000000100), clientObj.Settings.ChatID)
+	state, err := internal.LoadState(stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, &internal.State{TelegramChatID: -1000000000100, TelegramOldChatID: -100}, state)
+}
+
+/*
+TestMessageMigrateFromGroup tests that the bridge follows a group when the
+new supergroup reports which chat it was migrated from
+*/
+func TestMessageMigrateFromGroup(t *testing.T) {
+	correct := "<test> Random Text"
+	testUser := &models.User{
+		ID:       1,
+		Username: "test",
+	}
+	clientObj := &Client{
+		Settings: &internal.TelegramSettings{
+			Prefix: "<",
+			Suffix: ">",
+			ChatID: -100,
+		},
+		IRCSettings: &internal.IRCSettings{},
+		logger:      internal.Debug{},
+		sendToIrc: func(s string) {
+			assert.Equal(t, correct, s)
+		},
+	}
+
+	messageHandler(clientObj)(clientObj.ctx, clientObj.API, &models.Update{
+		Message: &models.Message{
+			Date:              int(time.Now().Unix()),
+			Chat:              models.Chat{ID: -1000000000100},
+			MigrateFromChatID: -100,
+		},
+	})
+	assert.Equal(t, int64(-1000000000100), clientObj.Settings.ChatID)
+
+	messageHandler(clientObj)(clientObj.ctx, clientObj.API, &models.Update{
+		Message: &models.Message{
+			Date: int(time.Now().Unix()),
+			From: testUser,
+			Text: "Random Text",
+			Chat: models.Chat{ID: -1000000000100},
+		},
+	})
+}
+
+/*
+TestMessageMigrateOtherChat tests that migrations of chats other than
+the bridged one are ignored
+*/
+func TestMessageMigrateOtherChat(t *testing.T) {
+	clientObj := &Client{
+		Settings: &internal.TelegramSettings{
+			ChatID: -100,
+		},
+		IRCSettings: &internal.IRCSettings{},
+		logger:      internal.Debug{},
+	}
+
+	messageHandler(clientObj)(clientObj.ctx, clientObj.API, &models.Update{
+		Message: &models.Message{
+			Date:            int(time.Now().Unix()),
+			Chat:            models.Chat{ID: -200},
+			MigrateToChatID: -1000000000200,
+		},
+	})
+	assert.Equal(t, int64(-100), clientObj.Settings.ChatID)
+}
+
 func TestLocationHandlerWithLocationEnabled(t *testing.T) {
 	testUser := &models.User{
 		ID:        1,
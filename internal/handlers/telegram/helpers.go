@@ -17,6 +17,27 @@ func GetUsername(showZWSP bool, u *models.User) string {
 	return u.Username
 }
 
+/*
+GetSenderName takes showZWSP condition and a message then returns the name of
+whoever sent it. Messages sent by anonymous group admins, by a linked channel
+or on behalf of a channel have a sender chat, in which case the author
+signature or the chat title is used instead of the user.
+*/
+func GetSenderName(showZWSP bool, msg *models.Message) string {
+	switch {
+	case msg.SenderChat != nil && msg.AuthorSignature != "":
+		return msg.AuthorSignature
+	case msg.SenderChat != nil && msg.SenderChat.Title != "":
+		return msg.SenderChat.Title
+	case msg.From != nil:
+		return GetUsername(showZWSP, msg.From)
+	case msg.AuthorSignature != "":
+		return msg.AuthorSignature
+	default:
+		return msg.Chat.Title
+	}
+}
+
 /*
 GetFullUsername takes showZWSP condition and user then returns full username with or without ​.
 */
@@ -61,7 +82,7 @@ returns a string with the Imgur URL.
 // 	photo := (u.Message.Photo)[len(u.Message.Photo)-1]
 
 // 	// Get Telegram file URL
-// 	tgLink, err := tg.api.FileDownloadLink(photo.FileID)
+// 	tgLink, err := tg.FileDownloadLink(tg.ctx, photo.FileID)
 // 	if err != nil {
 // 		tg.logger.LogError("Could not get Telegram Photo URL:", err)
 // 	}
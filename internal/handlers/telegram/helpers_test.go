@@ -100,3 +100,56 @@ func TestZwspUsername(t *testing.T) {
 		})
 	}
 }
+
+func TestGetSenderName(t *testing.T) {
+	anonymousBot := &models.User{ID: 1087968824, FirstName: "Group", Username: "GroupAnonymousBot"}
+	tests := []struct {
+		name     string
+		msg      *models.Message
+		expected string
+	}{
+		{
+			name:     "user",
+			msg:      &models.Message{From: &models.User{ID: 1, FirstName: "John", Username: "jsmith"}},
+			expected: "jsmith",
+		},
+		{
+			name: "anonymous admin",
+			msg: &models.Message{
+				From:       anonymousBot,
+				SenderChat: &models.Chat{ID: -100, Title: "Gotham"},
+			},
+			expected: "Gotham",
+		},
+		{
+			name: "anonymous admin with signature",
+			msg: &models.Message{
+				From:            anonymousBot,
+				SenderChat:      &models.Chat{ID: -100, Title: "Gotham"},
+				AuthorSignature: "Commissioner",
+			},
+			expected: "Commissioner",
+		},
+		{
+			name: "channel",
+			msg: &models.Message{
+				SenderChat: &models.Chat{ID: -200, Title: "Gotham News"},
+			},
+			expected: "Gotham News",
+		},
+		{
+			name: "channel post",
+			msg: &models.Message{
+				Chat: models.Chat{ID: -200, Title: "Gotham News"},
+			},
+			expected: "Gotham News",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := GetSenderName(false, test.msg)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
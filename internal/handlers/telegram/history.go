@@ -0,0 +1,70 @@
+package telegram
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/ritlug/teleirc/internal/history"
+)
+
+// historyCommand asks the bot for the last messages from IRC
+const historyCommand = "/history"
+
+// historyCooldown is how long a user waits between two /history
+const historyCooldown = 30 * time.Second
+
+/*
+historyHandler answers /history in a private chat with the last messages
+from IRC. Only members of the bridged group may read them. Users asking
+again within historyCooldown get nothing.
+*/
+func historyHandler(ctx context.Context, tg *Client, msg *models.Message, n int) {
+	if msg.From == nil || !tg.historyCooldown.Allow(strconv.FormatInt(msg.From.ID, 10), time.Now()) {
+		return
+	}
+	logger := tg.logger.With("event", "history", "chat_id", msg.Chat.ID)
+	reply := func(text string) {
+		if _, err := tg.api().SendMessage(ctx, &tgbotapi.SendMessageParams{ChatID: msg.Chat.ID, Text: text}); err != nil {
+			logger.LogError("Could not send history: %s", err)
+		}
+	}
+
+	member, err := tg.api().GetChatMember(ctx, &tgbotapi.GetChatMemberParams{
+		ChatID: tg.chatID(),
+		UserID: msg.From.ID,
+	})
+	if err != nil {
+		logger.LogError("Could not check membership for history: %s", err)
+		reply("Could not check that you are in the group, try again later")
+		return
+	}
+	if !isMember(member) {
+		reply("Only members of the group can read its history")
+		return
+	}
+
+	lines := history.Lines(tg.History.Last(min(n, history.MaxLines), history.IRC), history.MaxLines)
+	if len(lines) == 0 {
+		reply("No messages from IRC yet")
+		return
+	}
+	reply(strings.Join(lines, "\n"))
+}
+
+/*
+isMember returns whether member is in the chat
+*/
+func isMember(member *models.ChatMember) bool {
+	switch member.Type {
+	case models.ChatMemberTypeOwner, models.ChatMemberTypeAdministrator, models.ChatMemberTypeMember:
+		return true
+	case models.ChatMemberTypeRestricted:
+		return member.Restricted.IsMember
+	default:
+		return false
+	}
+}
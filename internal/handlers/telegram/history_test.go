@@ -0,0 +1,77 @@
+package telegram
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/ritlug/teleirc/internal/history"
+	"github.com/ritlug/teleirc/internal/testing/faketelegram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+askHistory sends text to the bot in a private chat from user, and returns
+the answer
+*/
+func askHistory(t *testing.T, fake *faketelegram.Server, user *models.User, text string) string {
+	t.Helper()
+	before := len(fake.CallsTo("sendMessage"))
+	fake.AddUpdate(models.Update{Message: &models.Message{
+		ID:   1,
+		From: user,
+		Chat: models.Chat{ID: user.ID, Type: models.ChatTypePrivate},
+		Date: int(time.Now().Unix()),
+		Text: text,
+	}})
+	calls := fake.WaitForCalls("sendMessage", before+1, 5*time.Second)
+	require.Len(t, calls, before+1, "the bot should answer")
+	assert.Equal(t, strconv.FormatInt(user.ID, 10), calls[before].Params["chat_id"])
+	return calls[before].Params["text"]
+}
+
+func TestHistory(t *testing.T) {
+	fake := faketelegram.New(testToken)
+	defer fake.Close()
+	fake.AddChat(models.ChatFullInfo{ID: -100, Type: models.ChatTypeSupergroup}, models.ChatMemberTypeAdministrator)
+	batman := &models.User{ID: 2, Username: "batman"}
+	fake.AddMember(-100, batman.ID, models.ChatMemberTypeMember)
+
+	client := newFakeClient(t, fake)
+	client.History = history.New(10)
+	received := make(chan string, 10)
+	errChan := make(chan error, 1)
+	go client.StartBot(errChan, func(s string) { received <- s })
+	defer func() {
+		client.Close()
+		assert.NoError(t, <-errChan)
+	}()
+
+	assert.Equal(t, "Only members of the group can read its history",
+		askHistory(t, fake, &models.User{ID: 3, Username: "joker"}, "/history"))
+
+	client.History.Add(history.Entry{Side: history.IRC, Kind: "text", Text: "<alice> one"})
+	client.History.Add(history.Entry{Side: history.Telegram, Kind: "text", Text: "<batman> two"})
+	client.History.Add(history.Entry{Side: history.IRC, Kind: "action", Text: "* alice three"})
+	answer := askHistory(t, fake, batman, "/history 5")
+	assert.Regexp(t, `^\[\d\d:\d\d\] <alice> one\n\[\d\d:\d\d\] \* alice three$`, answer)
+
+	// Too soon, the question is ignored rather than relayed
+	fake.AddUpdate(models.Update{Message: &models.Message{
+		ID: 2, From: batman, Chat: models.Chat{ID: batman.ID, Type: models.ChatTypePrivate},
+		Date: int(time.Now().Unix()), Text: "/history",
+	}})
+	fake.AddMessage(-100, batman, "hello")
+	select {
+	case msg := <-received:
+		assert.Equal(t, "<batman> hello", msg)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "message was not relayed")
+	}
+	assert.Len(t, fake.CallsTo("sendMessage"), 2)
+
+	// Relayed messages are kept
+	assert.Equal(t, "<batman> hello", client.History.Last(1, "")[0].Text)
+}
@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+
+	"github.com/ritlug/teleirc/internal"
 )
 
 type imgurDataWrapper struct {
@@ -56,46 +58,46 @@ func getImgurLink(tg *Client, tgLink string) string {
 	payload := &bytes.Buffer{}
 	writer := multipart.NewWriter(payload)
 	_ = writer.WriteField("image", tgLink)
-	if tg.ImgurSettings.ImgurAlbumHash != "" {
-		_ = writer.WriteField("album", tg.ImgurSettings.ImgurAlbumHash)
+	if tg.imgurSettings().ImgurAlbumHash != "" {
+		_ = writer.WriteField("album", tg.imgurSettings().ImgurAlbumHash)
 	}
 	err := writer.Close()
 	if err != nil {
-		tg.logger.LogError("Could not close Writer:", err)
+		tg.logger.LogError("Could not close Writer: %s", err)
 	}
 
 	client := &http.Client{}
 	req, err := http.NewRequest(method, url, payload)
 
 	if err != nil {
-		tg.logger.LogError("Could not build HTTP request:", err)
+		tg.logger.LogError("Could not build HTTP request: %s", err)
 	}
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-	if tg.ImgurSettings.ImgurRefreshToken != "" {
-		if tg.ImgurSettings.ImgurAccessToken == "" {
+	if tg.imgurSettings().ImgurRefreshToken != "" {
+		if tg.imgurAccessToken() == "" {
 			getImgurAccessToken(tg)
 		}
-		req.Header.Add("Authorization", "Bearer "+tg.ImgurSettings.ImgurAccessToken)
+		req.Header.Add("Authorization", "Bearer "+tg.imgurAccessToken())
 	} else {
-		clientID := tg.ImgurSettings.ImgurClientID
+		clientID := tg.imgurSettings().ImgurClientID
 		req.Header.Add("Authorization", "Client-ID "+clientID)
 	}
 
 	result, clientErr := client.Do(req)
 	if err != nil {
-		tg.logger.LogError("Could not send imgur request:", clientErr)
+		tg.logger.LogError("Could not send imgur request: %s", clientErr)
 	}
 	defer result.Body.Close()
 
 	body, err := ioutil.ReadAll(result.Body)
 	if err != nil {
-		tg.logger.LogError("Could not read imgur response:", err)
+		tg.logger.LogError("Could not read imgur response: %s", err)
 	}
 
 	var resp imgurDataWrapper
 	err = json.Unmarshal([]byte(body), &resp)
 	if err != nil {
-		tg.logger.LogError("Could not retrieve imgur json data:", err)
+		tg.logger.LogError("Could not retrieve imgur json data: %s", err)
 	}
 
 	if resp.Data == nil {
@@ -105,14 +107,14 @@ func getImgurLink(tg *Client, tgLink string) string {
 
 	if resp.Data.Deletehash != "" {
 		deleteLink := "https://imgur.com/delete/" + resp.Data.Deletehash
-		tg.logger.LogInfo("Deletion link for", resp.Data.Link, "is", deleteLink)
+		tg.logger.LogInfo("Deletion link for %s is %s", resp.Data.Link, deleteLink)
 	}
 
 	return resp.Data.Link
 }
 
 func getImgurAccessToken(tg *Client) {
-	if tg.ImgurSettings.ImgurClientID == "" || tg.ImgurSettings.ImgurRefreshToken == "" {
+	if tg.imgurSettings().ImgurClientID == "" || tg.imgurSettings().ImgurRefreshToken == "" {
 		tg.logger.LogError("Imgur client secret and refresh token must be set")
 		return
 	}
@@ -122,43 +124,55 @@ func getImgurAccessToken(tg *Client) {
 
 	payload := &bytes.Buffer{}
 	writer := multipart.NewWriter(payload)
-	_ = writer.WriteField("refresh_token", tg.ImgurSettings.ImgurRefreshToken)
-	_ = writer.WriteField("client_id", tg.ImgurSettings.ImgurClientID)
-	_ = writer.WriteField("client_secret", tg.ImgurSettings.ImgurClientSecret)
+	_ = writer.WriteField("refresh_token", tg.imgurSettings().ImgurRefreshToken)
+	_ = writer.WriteField("client_id", tg.imgurSettings().ImgurClientID)
+	_ = writer.WriteField("client_secret", tg.imgurSettings().ImgurClientSecret)
 	_ = writer.WriteField("grant_type", "refresh_token")
 	err := writer.Close()
 	if err != nil {
-		tg.logger.LogError("Could not close Writer:", err)
+		tg.logger.LogError("Could not close Writer: %s", err)
 		return
 	}
 
 	client := &http.Client{}
 	req, err := http.NewRequest(method, url, payload)
 	if err != nil {
-		tg.logger.LogError("Could not build HTTP request:", err)
+		tg.logger.LogError("Could not build HTTP request: %s", err)
 		return
 	}
 
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	res, err := client.Do(req)
 	if err != nil {
-		tg.logger.LogError("Could not send request:", err)
+		tg.logger.LogError("Could not send request: %s", err)
 		return
 	}
 	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		tg.logger.LogError("Could not read response:", err)
+		tg.logger.LogError("Could not read response: %s", err)
 		return
 	}
 
 	var data accessToken
 	err = json.Unmarshal([]byte(body), &data)
 	if err != nil {
-		tg.logger.LogError("Couldn't unmarshal json:", err)
+		tg.logger.LogError("Couldn't unmarshal json: %s", err)
 		return
 	}
 
+	internal.AddSecret(data.AccessToken)
+	tg.mu.Lock()
 	tg.ImgurSettings.ImgurAccessToken = data.AccessToken
+	tg.mu.Unlock()
+}
+
+/*
+imgurAccessToken returns the access token from the last refresh, if any
+*/
+func (tg *Client) imgurAccessToken() string {
+	tg.mu.RLock()
+	defer tg.mu.RUnlock()
+	return tg.ImgurSettings.ImgurAccessToken
 }
@@ -0,0 +1,67 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+
+	tgbotapi "github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+/*
+ProbeResult is what Probe learned about the bot and the bridged chat. The
+fields are set as far as the probe got before an error.
+*/
+type ProbeResult struct {
+	Bot  *models.User
+	Chat *models.ChatFullInfo
+	// Status is the bot's membership status in the chat
+	Status models.ChatMemberType
+}
+
+/*
+CanReadMessages returns whether the bot receives every message of the chat.
+Bots in privacy mode only receive commands and replies, unless they are
+an administrator.
+*/
+func (r *ProbeResult) CanReadMessages() bool {
+	return r.Bot.CanReadAllGroupMessages ||
+		r.Status == models.ChatMemberTypeOwner ||
+		r.Status == models.ChatMemberTypeAdministrator
+}
+
+/*
+Probe checks the settings against the Bot API without starting the bot:
+that the token is valid, and that the chat exists and the bot is in it
+*/
+func (tg *Client) Probe(ctx context.Context) (*ProbeResult, error) {
+	opts, err := tg.apiOptions()
+	if err != nil {
+		return nil, err
+	}
+	api, err := tgbotapi.New(tg.settings().Token, append(opts, tgbotapi.WithSkipGetMe())...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProbeResult{}
+	me, err := api.GetMe(ctx)
+	if err != nil {
+		return result, fmt.Errorf("getMe: %w", err)
+	}
+	result.Bot = me
+	chat, err := api.GetChat(ctx, &tgbotapi.GetChatParams{ChatID: tg.chatID()})
+	if err != nil {
+		return result, fmt.Errorf("getChat: %w", err)
+	}
+	result.Chat = chat
+	member, err := api.GetChatMember(ctx, &tgbotapi.GetChatMemberParams{
+		ChatID: tg.chatID(),
+		UserID: result.Bot.ID,
+	})
+	if err != nil {
+		return result, fmt.Errorf("getChatMember: %w", err)
+	}
+	result.Status = member.Type
+	return result, nil
+}
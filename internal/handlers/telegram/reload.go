@@ -0,0 +1,60 @@
+package telegram
+
+import (
+	"github.com/ritlug/teleirc/internal"
+)
+
+/*
+Reload switches the client to new settings. Settings that change how
+messages are relayed take effect right away. If a setting of the connection
+to Telegram changed, such as the token, the bot restarts with the new
+settings and Reload returns true.
+*/
+func (tg *Client) Reload(settings *internal.TelegramSettings, ircSettings *internal.IRCSettings, imgur *internal.ImgurSettings) bool {
+	tg.mu.Lock()
+	restart := connectionChanged(tg.Settings, settings)
+	// Stay in the supergroup the group migrated to, unless the config
+	// names another chat now
+	if tg.migratedFrom != 0 && settings.ChatID == tg.migratedFrom {
+		settings.ChatID = tg.Settings.ChatID
+	}
+	// The Imgur access token is refreshed at runtime, keep it
+	if old := tg.ImgurSettings; old != nil && imgur != nil &&
+		old.ImgurClientID == imgur.ImgurClientID &&
+		old.ImgurClientSecret == imgur.ImgurClientSecret &&
+		old.ImgurRefreshToken == imgur.ImgurRefreshToken {
+		imgur.ImgurAccessToken = old.ImgurAccessToken
+	}
+	tg.Settings = settings
+	tg.IRCSettings = ircSettings
+	tg.ImgurSettings = imgur
+
+	cancel := tg.runCancel
+	if restart {
+		tg.restart = true
+	}
+	tg.mu.Unlock()
+
+	if restart && cancel != nil {
+		cancel()
+	}
+	return restart
+}
+
+/*
+connectionChanged returns whether the settings differ in a way that needs
+a restart of the bot to take effect
+*/
+func connectionChanged(old, new *internal.TelegramSettings) bool {
+	return old.Token != new.Token ||
+		old.APIURL != new.APIURL ||
+		old.HTTPTimeout != new.HTTPTimeout ||
+		old.HTTPProxy != new.HTTPProxy ||
+		old.WebhookURL != new.WebhookURL ||
+		old.WebhookListen != new.WebhookListen ||
+		old.WebhookPath != new.WebhookPath ||
+		old.WebhookSecret != new.WebhookSecret ||
+		old.WebhookTLSCert != new.WebhookTLSCert ||
+		old.WebhookTLSKey != new.WebhookTLSKey ||
+		old.DebugEnabled != new.DebugEnabled
+}
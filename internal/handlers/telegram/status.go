@@ -0,0 +1,38 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ritlug/teleirc/internal"
+)
+
+// pollGrace is how much longer than TELEGRAM_HTTP_TIMEOUT a getUpdates
+// request may take before the bot counts as stuck
+const pollGrace = 30 * time.Second
+
+/*
+Status reports the state of the bot. It is ready while connected and, when
+polling, while getUpdates succeeded recently.
+*/
+func (tg *Client) Status() internal.SideStatus {
+	status := tg.status.Snapshot()
+	settings := tg.settings()
+	if status.ConnectedSince == nil {
+		status.Reason = "not connected to Telegram"
+		return status
+	}
+	if settings.WebhookURL == "" {
+		// A long polling request waits up to TELEGRAM_HTTP_TIMEOUT for updates
+		last := *status.ConnectedSince
+		if status.LastPoll != nil && status.LastPoll.After(last) {
+			last = *status.LastPoll
+		}
+		if since := time.Since(last); since > settings.HTTPTimeout+pollGrace {
+			status.Reason = fmt.Sprintf("no successful getUpdates for %s", since.Round(time.Second))
+			return status
+		}
+	}
+	status.Ready = true
+	return status
+}
@@ -0,0 +1,40 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/ritlug/teleirc/internal/testing/faketelegram"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	fake := faketelegram.New(testToken)
+	defer fake.Close()
+	client := newFakeClient(t, fake)
+
+	status := client.Status()
+	assert.False(t, status.Ready)
+	assert.Equal(t, "not connected to Telegram", status.Reason)
+
+	received := make(chan string, 1)
+	errChan := make(chan error, 1)
+	go client.StartBot(errChan, func(s string) { received <- s })
+	fake.AddMessage(-100, &models.User{ID: 2, Username: "batman"}, "hello")
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "message was not relayed")
+	}
+
+	status = client.Status()
+	assert.True(t, status.Ready)
+	assert.NotNil(t, status.ConnectedSince)
+	assert.NotNil(t, status.LastMessage)
+	assert.NotNil(t, status.LastPoll)
+
+	client.Close()
+	assert.NoError(t, <-errChan)
+	assert.False(t, client.Status().Ready)
+}
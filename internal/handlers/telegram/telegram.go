@@ -3,9 +3,14 @@ package telegram
 
 import (
 	"context"
+	"errors"
+	"sync"
 
 	tgbotapi "github.com/go-telegram/bot"
 	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/archive"
+	"github.com/ritlug/teleirc/internal/history"
+	"github.com/ritlug/teleirc/internal/metrics"
 )
 
 /*
@@ -20,6 +25,23 @@ type Client struct {
 	logger        internal.DebugLogger
 	sendToIrc     func(string)
 
+	// Archive stores the relayed updates, if set
+	Archive *archive.Archive
+	// History keeps the last relayed messages for /history, if set
+	History         *history.Buffer
+	historyCooldown *history.Cooldown
+
+	status *internal.Status
+
+	// mu guards the settings, which Reload replaces, Settings.ChatID, which
+	// changes when the group migrates, and API, which a restart replaces
+	mu sync.RWMutex
+	// migratedFrom is the chat ID the group had before it migrated
+	migratedFrom int64
+	// runCancel stops the bot when it restarts with new settings
+	runCancel context.CancelFunc
+	restart   bool
+
 	ctx       context.Context
 	ctxCancel context.CancelFunc
 }
@@ -28,65 +50,235 @@ type Client struct {
 NewClient creates a new Telegram bot client
 */
 func NewClient(settings *internal.TelegramSettings, ircsettings *internal.IRCSettings, imgur *internal.ImgurSettings, logger internal.DebugLogger) *Client {
+	logger = logger.With("side", "telegram")
 	logger.LogInfo("Creating new Telegram bot client...")
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Client{ctx: ctx, ctxCancel: cancel, Settings: settings, IRCSettings: ircsettings, ImgurSettings: imgur, logger: logger}
+	return &Client{
+		ctx:           ctx,
+		ctxCancel:     cancel,
+		Settings:      settings,
+		IRCSettings:   ircsettings,
+		ImgurSettings: imgur,
+		logger:        logger,
+		status:        &internal.Status{},
+
+		historyCooldown: history.NewCooldown(historyCooldown),
+	}
 }
 
 /*
 SendMessage sends a message to the Telegram channel specified in the settings
 */
 func (tg *Client) SendMessage(msg string) {
-	tg.logger.LogDebug("tg send message: %s", msg)
+	chatID := tg.chatID()
+	tg.logger.With("event", "send", "chat_id", chatID).LogDebug("tg send message: %s", msg)
 	newMsg := &tgbotapi.SendMessageParams{
-		ChatID: tg.Settings.ChatID,
+		ChatID: chatID,
 		Text:   msg,
 	}
+	metrics.QueueDepth.Add(1, metrics.Telegram)
+	defer metrics.QueueDepth.Add(-1, metrics.Telegram)
 
-	if _, err := tg.API.SendMessage(tg.ctx, newMsg); err != nil {
+	if _, err := tg.api().SendMessage(tg.ctx, newMsg); err != nil {
 		var attempts int = 0
 		// Try resending 3 times if the message is successfully sent
 		for err != nil && attempts < 3 {
 			attempts++
-			tg.logger.LogError("send failure #%d: %s", err, attempts)
-			_, err = tg.API.SendMessage(tg.ctx, newMsg)
+			metrics.SendErrors.Inc(metrics.Telegram)
+			tg.status.SetError(err)
+			tg.logger.With("event", "send", "chat_id", chatID).LogError("send failure #%d: %s", attempts, err)
+			// The group was upgraded to a supergroup, resend to the new chat
+			var migrateErr *tgbotapi.MigrateError
+			if errors.As(err, &migrateErr) {
+				tg.migrateChat(chatID, int64(migrateErr.MigrateToChatID))
+				chatID = tg.chatID()
+				newMsg.ChatID = chatID
+			}
+			_, err = tg.api().SendMessage(tg.ctx, newMsg)
+		}
+		if tgbotapi.IsTooManyRequestsError(err) {
+			metrics.MessagesDropped.Inc(metrics.IRCToTelegram, metrics.RateLimited)
 		}
 	}
 }
 
+/*
+chatID returns the ID of the bridged Telegram chat
+*/
+func (tg *Client) chatID() int64 {
+	tg.mu.RLock()
+	defer tg.mu.RUnlock()
+	return tg.Settings.ChatID
+}
+
+/*
+settings returns the Telegram settings in use
+*/
+func (tg *Client) settings() *internal.TelegramSettings {
+	tg.mu.RLock()
+	defer tg.mu.RUnlock()
+	return tg.Settings
+}
+
+/*
+ircSettings returns the IRC settings in use
+*/
+func (tg *Client) ircSettings() *internal.IRCSettings {
+	tg.mu.RLock()
+	defer tg.mu.RUnlock()
+	return tg.IRCSettings
+}
+
+/*
+imgurSettings returns the Imgur settings in use
+*/
+func (tg *Client) imgurSettings() *internal.ImgurSettings {
+	tg.mu.RLock()
+	defer tg.mu.RUnlock()
+	return tg.ImgurSettings
+}
+
+/*
+api returns the Bot API client of the running bot
+*/
+func (tg *Client) api() *tgbotapi.Bot {
+	tg.mu.RLock()
+	defer tg.mu.RUnlock()
+	return tg.API
+}
+
+/*
+migrateChat switches the bridged chat from one chat ID to another after
+Telegram upgraded a group to a supergroup. The new ID is saved to the
+state file, if one is configured, so it is still used after a restart.
+*/
+func (tg *Client) migrateChat(from, to int64) {
+	tg.mu.Lock()
+	if tg.Settings.ChatID != from || to == 0 {
+		tg.mu.Unlock()
+		return
+	}
+	tg.Settings.ChatID = to
+	tg.migratedFrom = from
+	stateFile := tg.Settings.StateFile
+	tg.mu.Unlock()
+
+	tg.logger.With("event", "migrate", "chat_id", to).LogInfo("Telegram group %d was migrated to supergroup %d, "+
+		"please update TELEGRAM_CHAT_ID in your config", from, to)
+
+	if stateFile == "" {
+		return
+	}
+	state := &internal.State{TelegramChatID: to, TelegramOldChatID: from}
+	if err := state.Save(stateFile); err != nil {
+		tg.logger.LogError("could not save state file %s: %s", stateFile, err)
+	}
+}
+
 /*
 StartBot adds necessary handlers to the client and then connects,
-returning any errors that occur
+returning any errors that occur. When Reload changed the connection
+settings, the bot is restarted with them.
 */
 func (tg *Client) StartBot(errChan chan<- error, sendMessage func(string)) {
 	tg.logger.LogInfo("Starting up Telegram bot...")
-	var err error
+	tg.sendToIrc = sendMessage
+	for {
+		ctx, cancel := context.WithCancel(tg.ctx)
+		tg.mu.Lock()
+		tg.runCancel = cancel
+		tg.mu.Unlock()
 
-	opts := []tgbotapi.Option{
+		webhook := tg.settings().WebhookURL != ""
+		err := tg.run(ctx)
+		cancel()
+		tg.status.SetDisconnected(err)
+		if !tg.takeRestart() || tg.ctx.Err() != nil {
+			errChan <- err
+			return
+		}
+		// Telegram does not deliver updates by polling while a webhook is set
+		if webhook {
+			tg.deleteWebhook()
+		}
+		tg.logger.LogInfo("Restarting Telegram bot with the new settings...")
+		metrics.Reconnects.Inc(metrics.Telegram)
+	}
+}
+
+/*
+run connects with the current settings and receives updates until ctx is
+done
+*/
+func (tg *Client) run(ctx context.Context) error {
+	settings := tg.settings()
+	opts, err := tg.apiOptions()
+	if err != nil {
+		return err
+	}
+	opts = append(opts,
 		tgbotapi.WithDefaultHandler(messageHandler(tg)),
 		tgbotapi.WithSkipGetMe(),
-	}
-	if tg.Settings.DebugEnabled {
+		// The library logs through ours, which scrubs the token and other secrets
+		tgbotapi.WithDebugHandler(tg.logger.LogDebug),
+		tgbotapi.WithErrorsHandler(func(err error) { tg.logger.LogError("%s", err) }),
+	)
+	if settings.DebugEnabled {
 		opts = append(opts, tgbotapi.WithDebug())
 	}
+	// webhookSecret is sent by Telegram with every webhook request
+	webhookSecret := settings.WebhookSecret
+	if settings.WebhookURL != "" {
+		if webhookSecret == "" {
+			webhookSecret = defaultWebhookSecret(settings.Token)
+			internal.AddSecret(webhookSecret)
+		}
+		opts = append(opts, tgbotapi.WithWebhookSecretToken(webhookSecret))
+	}
 
-	tg.API, err = tgbotapi.New(tg.Settings.Token, opts...)
+	api, err := tgbotapi.New(settings.Token, opts...)
 	if err != nil {
-		errChan <- err
+		return err
 	}
+	tg.mu.Lock()
+	tg.API = api
+	tg.mu.Unlock()
 
-	me, err := tg.API.GetMe(tg.ctx)
+	me, err := api.GetMe(ctx)
 	if err != nil {
-		errChan <- err
+		return err
 	}
 	tg.logger.LogInfo("Authorized on account %s", me.Username)
-	tg.sendToIrc = sendMessage
+	metrics.Connected.Set(1, metrics.Telegram)
+	defer metrics.Connected.Set(0, metrics.Telegram)
+	tg.status.SetConnected()
+
+	if settings.WebhookURL != "" {
+		return tg.startWebhook(ctx, webhookSecret)
+	}
 
-	tg.API.Start(tg.ctx)
+	api.Start(ctx)
 
-	errChan <- nil
+	return nil
 }
 
+/*
+takeRestart returns whether Reload asked for a restart, and clears it
+*/
+func (tg *Client) takeRestart() bool {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	restart := tg.restart
+	tg.restart = false
+	return restart
+}
+
+/*
+Close stops the bot. In webhook mode the webhook is removed first.
+*/
 func (tg *Client) Close() {
+	if tg.settings().WebhookURL != "" && tg.api() != nil {
+		tg.deleteWebhook()
+	}
 	tg.ctxCancel()
 }
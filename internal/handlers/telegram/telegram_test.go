@@ -2,8 +2,12 @@ package telegram
 
 import (
 	"testing"
+	"time"
 
+	tgbotapi "github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
 	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/testing/faketelegram"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,9 +28,7 @@ func TestNewClientBasic(t *testing.T) {
 	imgurSettings := &internal.ImgurSettings{
 		ImgurClientID: "7d6b00b87043f58",
 	}
-	logger := internal.Debug{
-		DebugLevel: false,
-	}
+	logger := internal.Debug{}
 	// var tgapi *tgbotapi.Bot
 	client := NewClient(tgRequiredSettings, nil, imgurSettings, logger)
 	// client.API = &tgbotapi.Bot{}
@@ -55,11 +57,115 @@ func TestNewClientFull(t *testing.T) {
 	imgurSettings := &internal.ImgurSettings{
 		ImgurClientID: "7d6b00b87043f58",
 	}
-	logger := internal.Debug{
-		DebugLevel: false,
-	}
+	logger := internal.Debug{}
 
 	client := NewClient(tgSettings, nil, imgurSettings, logger)
 	assert.Equal(t, client.Settings, tgSettings, "All client settings should be properly set")
 	assert.NotEqual(t, client.Settings, tgDefaultSettings, "tgSettings should override defaults")
 }
+
+/*
+newFakeClient returns a client that talks to a fake Bot API server
+*/
+func newFakeClient(t *testing.T, fake *faketelegram.Server) *Client {
+	settings := &internal.TelegramSettings{
+		Token:       testToken,
+		ChatID:      -100,
+		Prefix:      "<",
+		Suffix:      ">",
+		APIURL:      fake.URL,
+		HTTPTimeout: 2 * time.Second,
+	}
+	return NewClient(settings, &internal.IRCSettings{}, &internal.ImgurSettings{}, internal.Debug{})
+}
+
+/*
+connectFakeClient creates the bot API of a client without starting it
+*/
+func connectFakeClient(t *testing.T, client *Client) {
+	opts, err := client.apiOptions()
+	assert.NoError(t, err)
+	client.API, err = tgbotapi.New(client.Settings.Token, append(opts, tgbotapi.WithSkipGetMe())...)
+	assert.NoError(t, err)
+}
+
+func TestStartBotRelaysMessages(t *testing.T) {
+	fake := faketelegram.New(testToken)
+	defer fake.Close()
+	client := newFakeClient(t, fake)
+
+	received := make(chan string, 1)
+	errChan := make(chan error, 1)
+	go client.StartBot(errChan, func(s string) { received <- s })
+
+	fake.AddMessage(-100, &models.User{ID: 2, Username: "batman"}, "hello")
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "<batman> hello", msg)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "message was not relayed")
+	}
+
+	client.Close()
+	assert.NoError(t, <-errChan)
+	assert.Len(t, fake.CallsTo("getMe"), 1)
+}
+
+func TestSendMessage(t *testing.T) {
+	fake := faketelegram.New(testToken)
+	defer fake.Close()
+	client := newFakeClient(t, fake)
+	connectFakeClient(t, client)
+
+	client.SendMessage("<robin> hi")
+
+	calls := fake.CallsTo("sendMessage")
+	if assert.Len(t, calls, 1) {
+		assert.Equal(t, "-100", calls[0].Params["chat_id"])
+		assert.Equal(t, "<robin> hi", calls[0].Params["text"])
+	}
+}
+
+func TestSendMessageMigrated(t *testing.T) {
+	fake := faketelegram.New(testToken)
+	defer fake.Close()
+	client := newFakeClient(t, fake)
+	connectFakeClient(t, client)
+	fake.FailNext("sendMessage", faketelegram.MigrateToChat(-1000000000100))
+
+	client.SendMessage("<robin> hi")
+
+	calls := fake.CallsTo("sendMessage")
+	if assert.Len(t, calls, 2) {
+		assert.Equal(t, "-100", calls[0].Params["chat_id"])
+		assert.Equal(t, "-1000000000100", calls[1].Params["chat_id"])
+	}
+	assert.Equal(t, int64(-1000000000100), client.Settings.ChatID)
+}
+
+func TestSendMessageRetries(t *testing.T) {
+	fake := faketelegram.New(testToken)
+	defer fake.Close()
+	client := newFakeClient(t, fake)
+	connectFakeClient(t, client)
+	fake.FailNext("sendMessage", faketelegram.TooManyRequests(1))
+
+	client.SendMessage("<robin> hi")
+
+	assert.Len(t, fake.CallsTo("sendMessage"), 2, "Message should be resent once")
+}
+
+func TestSendMessageGivesUp(t *testing.T) {
+	fake := faketelegram.New(testToken)
+	defer fake.Close()
+	client := newFakeClient(t, fake)
+	connectFakeClient(t, client)
+	for i := 0; i < 5; i++ {
+		fake.FailNext("sendMessage", faketelegram.Forbidden())
+	}
+
+	client.SendMessage("<robin> hi")
+
+	assert.Len(t, fake.CallsTo("sendMessage"), 4, "Message should be resent 3 times")
+}
@@ -0,0 +1,135 @@
+package telegram
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	tgbotapi "github.com/go-telegram/bot"
+)
+
+// webhookShutdownTimeout limits how long stopping the webhook listener may take
+const webhookShutdownTimeout = 5 * time.Second
+
+/*
+defaultWebhookSecret derives the secret token for Telegram to send along
+with every webhook request from the bot token. It stays the same across
+restarts and between instances sharing the token, so none of them rejects
+updates meant for another.
+*/
+func defaultWebhookSecret(token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte("teleirc webhook secret"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+webhookPath returns the path webhook updates are served on. Unless it is
+configured explicitly, the path of the public webhook URL is used.
+*/
+func (tg *Client) webhookPath() (string, error) {
+	settings := tg.settings()
+	if settings.WebhookPath != "" {
+		return settings.WebhookPath, nil
+	}
+	u, err := url.Parse(settings.WebhookURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Path == "" {
+		return "/", nil
+	}
+	return u.Path, nil
+}
+
+/*
+secretTokenHandler rejects requests that do not carry the webhook secret token
+in the X-Telegram-Bot-Api-Secret-Token header
+*/
+func secretTokenHandler(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+startWebhook registers the webhook with Telegram, which sends secret with
+every request, and serves updates until ctx is done, returning any errors
+that occur
+*/
+func (tg *Client) startWebhook(ctx context.Context, secret string) error {
+	settings := tg.settings()
+	path, err := tg.webhookPath()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tg.api().SetWebhook(ctx, &tgbotapi.SetWebhookParams{
+		URL:         settings.WebhookURL,
+		SecretToken: secret,
+	}); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, secretTokenHandler(secret, tg.api().WebhookHandler()))
+	server := &http.Server{
+		Addr:              settings.WebhookListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if settings.WebhookTLSCert != "" && settings.WebhookTLSKey != "" {
+			serveErr <- server.ListenAndServeTLS(settings.WebhookTLSCert, settings.WebhookTLSKey)
+		} else {
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+	go tg.api().StartWebhook(ctx)
+
+	tg.logger.LogInfo("Listening for Telegram webhook updates on %s%s", settings.WebhookListen, path)
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+		err = shutdownErr
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+/*
+deleteWebhook removes the webhook from Telegram so that the bot can be used
+with long polling again
+*/
+func (tg *Client) deleteWebhook() {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+	if _, err := tg.api().DeleteWebhook(ctx, &tgbotapi.DeleteWebhookParams{}); err != nil {
+		tg.logger.LogError("could not delete webhook: %s", err)
+	}
+}
@@ -0,0 +1,142 @@
+package telegram
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/ritlug/teleirc/internal"
+	"github.com/ritlug/teleirc/internal/testing/faketelegram"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretTokenHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		token    string
+		expected int
+		called   bool
+	}{
+		{name: "valid", method: http.MethodPost, token: "s3cret", expected: http.StatusOK, called: true},
+		{name: "missing", method: http.MethodPost, token: "", expected: http.StatusUnauthorized},
+		{name: "wrong", method: http.MethodPost, token: "guess", expected: http.StatusUnauthorized},
+		{name: "wrong method", method: http.MethodGet, token: "s3cret", expected: http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called := false
+			handler := secretTokenHandler("s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+
+			req := httptest.NewRequest(test.method, "/telegram", nil)
+			if test.token != "" {
+				req.Header.Set("X-Telegram-Bot-Api-Secret-Token", test.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.expected, rec.Code)
+			assert.Equal(t, test.called, called)
+		})
+	}
+}
+
+func TestWebhookPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings internal.TelegramSettings
+		expected string
+	}{
+		{
+			name:     "from url",
+			settings: internal.TelegramSettings{WebhookURL: "https://bridge.example.com/teleirc/hook"},
+			expected: "/teleirc/hook",
+		},
+		{
+			name:     "url without path",
+			settings: internal.TelegramSettings{WebhookURL: "https://bridge.example.com"},
+			expected: "/",
+		},
+		{
+			name: "explicit",
+			settings: internal.TelegramSettings{
+				WebhookURL:  "https://bridge.example.com/teleirc/hook",
+				WebhookPath: "/hook",
+			},
+			expected: "/hook",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &Client{Settings: &test.settings}
+			path, err := client.webhookPath()
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, path)
+		})
+	}
+}
+
+func TestDefaultWebhookSecret(t *testing.T) {
+	secret := defaultWebhookSecret(testToken)
+	assert.Regexp(t, "^[A-Za-z0-9_-]{1,256}$", secret, "Secret should be accepted by Telegram")
+	assert.Equal(t, secret, defaultWebhookSecret(testToken), "Secret should not change between starts")
+	assert.NotEqual(t, secret, defaultWebhookSecret(testToken+"x"))
+	assert.NotContains(t, secret, testToken)
+}
+
+func TestStartBotWebhook(t *testing.T) {
+	fake := faketelegram.New(testToken)
+	defer fake.Close()
+	client := newFakeClient(t, fake)
+
+	// Find a free port for the webhook listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	client.Settings.WebhookURL = "http://" + addr + "/hook"
+	client.Settings.WebhookListen = addr
+
+	received := make(chan string, 1)
+	errChan := make(chan error, 1)
+	go client.StartBot(errChan, func(s string) { received <- s })
+
+	calls := fake.WaitForCalls("setWebhook", 1, 5*time.Second)
+	if !assert.Len(t, calls, 1) {
+		return
+	}
+	assert.Equal(t, client.Settings.WebhookURL, calls[0].Params["url"])
+	assert.NotEmpty(t, calls[0].Params["secret_token"])
+
+	update := models.Update{Message: &models.Message{
+		From: &models.User{ID: 2, Username: "batman"},
+		Chat: models.Chat{ID: -100},
+		Date: int(time.Now().Unix()),
+		Text: "hello",
+	}}
+	var status int
+	// The listener may not be up yet right after setWebhook
+	assert.Eventually(t, func() bool {
+		status, err = fake.DeliverWebhook(update)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, http.StatusOK, status)
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "<batman> hello", msg)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "message was not relayed")
+	}
+
+	client.Close()
+	assert.NoError(t, <-errChan)
+	assert.Len(t, fake.CallsTo("deleteWebhook"), 1)
+}
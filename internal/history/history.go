@@ -0,0 +1,199 @@
+/*
+Package history keeps the last messages relayed across the bridge in memory,
+so people who just arrived on either side can catch up
+*/
+package history
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sides of the bridge a message can come from
+const (
+	IRC      = "irc"
+	Telegram = "telegram"
+)
+
+// Limits of a request for history
+const (
+	// MaxLines is the most messages sent for one request
+	MaxLines = 20
+	// DefaultLines are sent when the request does not say how many
+	DefaultLines = 10
+)
+
+// presence are the kinds of events about people coming and going, which
+// are not part of the conversation
+var presence = map[string]bool{
+	"join":       true,
+	"part":       true,
+	"quit":       true,
+	"nick":       true,
+	"disconnect": true,
+}
+
+/*
+Entry is a relayed message
+*/
+type Entry struct {
+	Time time.Time
+	// Side is where the message was sent, IRC or Telegram
+	Side string
+	// Kind is the kind of event, as in the teleirc_messages_relayed_total
+	// metric
+	Kind string
+	// Text is the message as it was relayed
+	Text string
+}
+
+/*
+Buffer is a ring buffer of the last relayed messages. A nil Buffer keeps
+nothing.
+*/
+type Buffer struct {
+	mu      sync.Mutex
+	entries []Entry
+	// next is where the next entry goes once the buffer is full
+	next int
+}
+
+/*
+New returns a Buffer of the last size messages, or nil if size is not
+positive
+*/
+func New(size int) *Buffer {
+	if size <= 0 {
+		return nil
+	}
+	return &Buffer{entries: make([]Entry, 0, size)}
+}
+
+/*
+Add stores a message, unless it is about someone joining or leaving.
+Messages without a time were sent now.
+*/
+func (b *Buffer) Add(e Entry) {
+	if b == nil || presence[e.Kind] {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.entries) < cap(b.entries) {
+		b.entries = append(b.entries, e)
+		return
+	}
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % len(b.entries)
+}
+
+/*
+Last returns the last n messages from side, or from both sides if side is
+empty, oldest first
+*/
+func (b *Buffer) Last(n int, side string) []Entry {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var last []Entry
+	for i := len(b.entries) - 1; i >= 0 && len(last) < n; i-- {
+		e := b.entries[(b.next+i)%len(b.entries)]
+		if side == "" || e.Side == side {
+			last = append(last, e)
+		}
+	}
+	for i, j := 0, len(last)-1; i < j; i, j = i+1, j-1 {
+		last[i], last[j] = last[j], last[i]
+	}
+	return last
+}
+
+/*
+Lines formats entries for sending, one line per line of text, each with
+the time it was sent. Only the last max lines are returned.
+*/
+func Lines(entries []Entry, max int) []string {
+	var lines []string
+	for _, e := range entries {
+		stamp := "[" + e.Time.Local().Format("15:04") + "] "
+		for line := range strings.SplitSeq(e.Text, "\n") {
+			if line != "" {
+				lines = append(lines, stamp+line)
+			}
+		}
+	}
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return lines
+}
+
+/*
+ParseCommand parses a request for history such as "!history 10", where
+command is "!history". Telegram adds the bot's username to commands in
+groups, as in "/history@teleirc_bot 10". Without a number, it returns
+fallback.
+*/
+func ParseCommand(text, command string, fallback int) (int, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, false
+	}
+	name, _, _ := strings.Cut(fields[0], "@")
+	if !strings.EqualFold(name, command) {
+		return 0, false
+	}
+	if len(fields) == 1 {
+		return fallback, true
+	}
+	n, err := strconv.Atoi(fields[1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+/*
+Cooldown lets each requester have history once per interval, so it cannot
+be used to make the bot flood
+*/
+type Cooldown struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+/*
+NewCooldown returns a Cooldown of interval
+*/
+func NewCooldown(interval time.Duration) *Cooldown {
+	return &Cooldown{interval: interval, last: map[string]time.Time{}}
+}
+
+/*
+Allow returns whether key may have history at now, and if so starts its
+cooldown
+*/
+func (c *Cooldown) Allow(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.last[key]; ok && now.Sub(last) < c.interval {
+		return false
+	}
+	// Forget the requesters whose cooldown is over
+	for k, last := range c.last {
+		if now.Sub(last) >= c.interval {
+			delete(c.last, k)
+		}
+	}
+	c.last[key] = now
+	return true
+}
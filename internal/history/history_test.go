@@ -0,0 +1,90 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func texts(entries []Entry) []string {
+	var texts []string
+	for _, e := range entries {
+		texts = append(texts, e.Text)
+	}
+	return texts
+}
+
+func TestBuffer(t *testing.T) {
+	b := New(3)
+	assert.Empty(t, b.Last(5, ""))
+	b.Add(Entry{Side: IRC, Kind: "text", Text: "one"})
+	b.Add(Entry{Side: Telegram, Kind: "text", Text: "two"})
+	b.Add(Entry{Side: IRC, Kind: "join", Text: "* alice joins"})
+	assert.Equal(t, []string{"one", "two"}, texts(b.Last(5, "")))
+
+	// The oldest messages make room for new ones
+	b.Add(Entry{Side: IRC, Kind: "action", Text: "three"})
+	b.Add(Entry{Side: Telegram, Kind: "reply", Text: "four"})
+	b.Add(Entry{Side: IRC, Kind: "text", Text: "five"})
+	assert.Equal(t, []string{"three", "four", "five"}, texts(b.Last(5, "")))
+	assert.Equal(t, []string{"four", "five"}, texts(b.Last(2, "")))
+	assert.Equal(t, []string{"three", "five"}, texts(b.Last(5, IRC)))
+	assert.Equal(t, []string{"four"}, texts(b.Last(5, Telegram)))
+	assert.False(t, b.Last(1, "")[0].Time.IsZero())
+}
+
+func TestBufferNil(t *testing.T) {
+	b := New(0)
+	assert.Nil(t, b)
+	b.Add(Entry{Side: IRC, Kind: "text", Text: "one"})
+	assert.Empty(t, b.Last(5, ""))
+}
+
+func TestLines(t *testing.T) {
+	at := time.Date(2024, 5, 10, 9, 5, 0, 0, time.Local)
+	entries := []Entry{
+		{Time: at, Text: "<alice> hello"},
+		{Time: at.Add(time.Minute), Text: "<batman> first\n\nsecond"},
+	}
+	assert.Equal(t, []string{"[09:05] <alice> hello", "[09:06] <batman> first", "[09:06] second"}, Lines(entries, 5))
+	assert.Equal(t, []string{"[09:06] <batman> first", "[09:06] second"}, Lines(entries, 2))
+}
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		text string
+		n    int
+		ok   bool
+	}{
+		{"!history", 10, true},
+		{"!history 5", 5, true},
+		{"  !HISTORY 5 ", 5, true},
+		{"!history five", 0, false},
+		{"!history 0", 0, false},
+		{"!history 5 please", 0, false},
+		{"!historyx", 0, false},
+		{"what was the !history", 0, false},
+		{"", 0, false},
+	}
+	for _, c := range cases {
+		n, ok := ParseCommand(c.text, "!history", 10)
+		assert.Equal(t, c.ok, ok, c.text)
+		assert.Equal(t, c.n, n, c.text)
+	}
+	n, ok := ParseCommand("/history@teleirc_bot 3", "/history", 10)
+	assert.True(t, ok)
+	assert.Equal(t, 3, n)
+}
+
+func TestCooldown(t *testing.T) {
+	c := NewCooldown(time.Minute)
+	now := time.Now()
+	assert.True(t, c.Allow("alice", now))
+	assert.False(t, c.Allow("alice", now.Add(30*time.Second)))
+	assert.True(t, c.Allow("bob", now.Add(30*time.Second)))
+	assert.True(t, c.Allow("alice", now.Add(time.Minute)))
+	assert.Len(t, c.last, 2)
+	assert.True(t, c.Allow("carol", now.Add(2*time.Minute)))
+	assert.Len(t, c.last, 1, "expired cooldowns are forgotten")
+}
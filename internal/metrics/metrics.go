@@ -0,0 +1,294 @@
+/*
+Package metrics counts what the bridge does, and serves the counts in the
+Prometheus text format
+*/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Directions of relayed and dropped messages
+const (
+	IRCToTelegram = "irc_to_telegram"
+	TelegramToIRC = "telegram_to_irc"
+)
+
+// Sides of the bridge
+const (
+	IRC      = "irc"
+	Telegram = "telegram"
+)
+
+// Reasons for dropping a message
+const (
+	Blacklist       = "blacklist"
+	NoForwardPrefix = "no_forward_prefix"
+	Stale           = "stale"
+	RateLimited     = "rate_limited"
+	OtherChat       = "other_chat"
+)
+
+var (
+	MessagesRelayed = NewCounter("teleirc_messages_relayed_total",
+		"Messages relayed to the other side, by direction and kind of message.", "direction", "kind")
+	MessagesDropped = NewCounter("teleirc_messages_dropped_total",
+		"Messages not relayed, by direction and reason.", "direction", "reason")
+	SendErrors = NewCounter("teleirc_send_errors_total",
+		"Failed attempts to send a message, by side.", "side")
+	Reconnects = NewCounter("teleirc_reconnects_total",
+		"Reconnects after a lost connection or a config reload, by side.", "side")
+	Connected = NewGauge("teleirc_connected",
+		"1 if the side is connected, 0 if not.", "side")
+	QueueDepth = NewGauge("teleirc_queue_depth",
+		"Messages waiting to be sent, by side.", "side")
+	IRCLag = NewGauge("teleirc_irc_lag_seconds",
+		"Time between the last PING to the IRC server and its PONG.")
+	TelegramPollDuration = NewHistogram("teleirc_telegram_poll_duration_seconds",
+		"Duration of getUpdates long polling requests to the Bot API.",
+		[]float64{0.1, 0.5, 1, 5, 10, 30, 60, 120})
+)
+
+/*
+metric is a family of series that can be written in the text format
+*/
+type metric interface {
+	write(w io.Writer)
+}
+
+// registry holds every metric, in the order they are written
+var registry struct {
+	sync.Mutex
+	metrics []metric
+}
+
+func register(m metric) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+/*
+Handler serves every metric in the Prometheus text format
+*/
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registry.Lock()
+		metrics := append([]metric(nil), registry.metrics...)
+		registry.Unlock()
+		for _, m := range metrics {
+			m.write(w)
+		}
+	})
+}
+
+/*
+series holds the values of a metric by label values
+*/
+type series struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newSeries(name, help, typ string, labels []string) *series {
+	return &series{name: name, help: help, typ: typ, labels: labels, values: map[string]float64{}}
+}
+
+func (s *series) key(labelValues []string) string {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", s.name, s.labels, labelValues))
+	}
+	return strings.Join(labelValues, "\x00")
+}
+
+func (s *series) add(delta float64, labelValues []string) {
+	key := s.key(labelValues)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] += delta
+}
+
+func (s *series) set(value float64, labelValues []string) {
+	key := s.key(labelValues)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+func (s *series) get(labelValues []string) float64 {
+	key := s.key(labelValues)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+func (s *series) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.typ)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// A metric without labels always has a value
+	if len(s.labels) == 0 && len(keys) == 0 {
+		keys = append(keys, "")
+	}
+	for _, key := range keys {
+		var labelValues []string
+		if len(s.labels) > 0 {
+			labelValues = strings.Split(key, "\x00")
+		}
+		fmt.Fprintf(w, "%s%s %s\n", s.name, formatLabels(s.labels, labelValues), formatValue(s.values[key]))
+	}
+}
+
+/*
+Counter counts events. Its values only go up.
+*/
+type Counter struct {
+	*series
+}
+
+/*
+NewCounter registers a counter with the given label names
+*/
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newSeries(name, help, "counter", labels)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+// Value returns the count with the given label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.get(labelValues)
+}
+
+/*
+Gauge is a value that goes up and down
+*/
+type Gauge struct {
+	*series
+}
+
+/*
+NewGauge registers a gauge with the given label names
+*/
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newSeries(name, help, "gauge", labels)}
+	register(g)
+	return g
+}
+
+// Set sets the gauge with the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues)
+}
+
+// Add adds delta, which may be negative, to the gauge
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues)
+}
+
+// Value returns the gauge with the given label values
+func (g *Gauge) Value(labelValues ...string) float64 {
+	return g.get(labelValues)
+}
+
+/*
+Histogram counts observations, such as durations, in buckets
+*/
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+/*
+NewHistogram registers a histogram with the given upper bounds of its
+buckets, in increasing order
+*/
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatValue(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatValue(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+scrape returns what Handler serves
+*/
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	return rec.Body.String()
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_events_total", "Events.", "side", "kind")
+	c.Inc("irc", "text")
+	c.Inc("irc", "text")
+	c.Inc("telegram", `say "hi"`)
+	assert.Equal(t, float64(2), c.Value("irc", "text"))
+
+	out := scrape(t)
+	assert.Contains(t, out, "# HELP test_events_total Events.\n# TYPE test_events_total counter\n")
+	assert.Contains(t, out, `test_events_total{side="irc",kind="text"} 2`+"\n")
+	assert.Contains(t, out, `test_events_total{side="telegram",kind="say \"hi\""} 1`+"\n")
+}
+
+func TestCounterWrongLabels(t *testing.T) {
+	c := NewCounter("test_wrong_total", "Wrong.", "side")
+	assert.Panics(t, func() { c.Inc("irc", "text") })
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_lag_seconds", "Lag.")
+	assert.Contains(t, scrape(t), "test_lag_seconds 0\n", "a gauge without labels is always served")
+
+	g.Set(0.25)
+	g.Add(-0.05)
+	assert.InDelta(t, 0.2, g.Value(), 1e-9)
+	assert.Contains(t, scrape(t), "# TYPE test_lag_seconds gauge\ntest_lag_seconds 0.2\n")
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Duration.", []float64{0.5, 1})
+	h.Observe(0.1)
+	h.Observe(0.7)
+	h.Observe(3)
+	assert.Equal(t, uint64(3), h.Count())
+
+	assert.Contains(t, scrape(t), `# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.5"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 3.8
+test_duration_seconds_count 3
+`)
+}
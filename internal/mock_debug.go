@@ -1,13 +1,12 @@
 // Code generated by MockGen. DO NOT EDIT.
 // Source: debug.go
 
-// Package mock_internal is a generated GoMock package.
+// Package internal is a generated GoMock package.
 package internal
 
 import (
-	reflect "reflect"
-
 	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
 )
 
 // MockDebugLogger is a mock of DebugLogger interface
@@ -34,9 +33,9 @@ func (m *MockDebugLogger) EXPECT() *MockDebugLoggerMockRecorder {
 }
 
 // LogInfo mocks base method
-func (m *MockDebugLogger) LogInfo(v ...interface{}) {
+func (m *MockDebugLogger) LogInfo(f string, v ...any) {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{}
+	varargs := []interface{}{f}
 	for _, a := range v {
 		varargs = append(varargs, a)
 	}
@@ -44,15 +43,16 @@ func (m *MockDebugLogger) LogInfo(v ...interface{}) {
 }
 
 // LogInfo indicates an expected call of LogInfo
-func (mr *MockDebugLoggerMockRecorder) LogInfo(v ...interface{}) *gomock.Call {
+func (mr *MockDebugLoggerMockRecorder) LogInfo(f interface{}, v ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogInfo", reflect.TypeOf((*MockDebugLogger)(nil).LogInfo), v...)
+	varargs := append([]interface{}{f}, v...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogInfo", reflect.TypeOf((*MockDebugLogger)(nil).LogInfo), varargs...)
 }
 
 // LogDebug mocks base method
-func (m *MockDebugLogger) LogDebug(v ...interface{}) {
+func (m *MockDebugLogger) LogDebug(f string, v ...any) {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{}
+	varargs := []interface{}{f}
 	for _, a := range v {
 		varargs = append(varargs, a)
 	}
@@ -60,15 +60,16 @@ func (m *MockDebugLogger) LogDebug(v ...interface{}) {
 }
 
 // LogDebug indicates an expected call of LogDebug
-func (mr *MockDebugLoggerMockRecorder) LogDebug(v ...interface{}) *gomock.Call {
+func (mr *MockDebugLoggerMockRecorder) LogDebug(f interface{}, v ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogDebug", reflect.TypeOf((*MockDebugLogger)(nil).LogDebug), v...)
+	varargs := append([]interface{}{f}, v...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogDebug", reflect.TypeOf((*MockDebugLogger)(nil).LogDebug), varargs...)
 }
 
 // LogError mocks base method
-func (m *MockDebugLogger) LogError(v ...interface{}) {
+func (m *MockDebugLogger) LogError(f string, v ...any) {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{}
+	varargs := []interface{}{f}
 	for _, a := range v {
 		varargs = append(varargs, a)
 	}
@@ -76,15 +77,16 @@ func (m *MockDebugLogger) LogError(v ...interface{}) {
 }
 
 // LogError indicates an expected call of LogError
-func (mr *MockDebugLoggerMockRecorder) LogError(v ...interface{}) *gomock.Call {
+func (mr *MockDebugLoggerMockRecorder) LogError(f interface{}, v ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogError", reflect.TypeOf((*MockDebugLogger)(nil).LogError), v...)
+	varargs := append([]interface{}{f}, v...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogError", reflect.TypeOf((*MockDebugLogger)(nil).LogError), varargs...)
 }
 
 // LogWarning mocks base method
-func (m *MockDebugLogger) LogWarning(v ...interface{}) {
+func (m *MockDebugLogger) LogWarning(f string, v ...any) {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{}
+	varargs := []interface{}{f}
 	for _, a := range v {
 		varargs = append(varargs, a)
 	}
@@ -92,15 +94,16 @@ func (m *MockDebugLogger) LogWarning(v ...interface{}) {
 }
 
 // LogWarning indicates an expected call of LogWarning
-func (mr *MockDebugLoggerMockRecorder) LogWarning(v ...interface{}) *gomock.Call {
+func (mr *MockDebugLoggerMockRecorder) LogWarning(f interface{}, v ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogWarning", reflect.TypeOf((*MockDebugLogger)(nil).LogWarning), v...)
+	varargs := append([]interface{}{f}, v...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogWarning", reflect.TypeOf((*MockDebugLogger)(nil).LogWarning), varargs...)
 }
 
 // PrintVersion mocks base method
-func (m *MockDebugLogger) PrintVersion(v ...interface{}) {
+func (m *MockDebugLogger) PrintVersion(f string, v ...any) {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{}
+	varargs := []interface{}{f}
 	for _, a := range v {
 		varargs = append(varargs, a)
 	}
@@ -108,7 +111,26 @@ func (m *MockDebugLogger) PrintVersion(v ...interface{}) {
 }
 
 // PrintVersion indicates an expected call of PrintVersion
-func (mr *MockDebugLoggerMockRecorder) PrintVersion(v ...interface{}) *gomock.Call {
+func (mr *MockDebugLoggerMockRecorder) PrintVersion(f interface{}, v ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{f}, v...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrintVersion", reflect.TypeOf((*MockDebugLogger)(nil).PrintVersion), varargs...)
+}
+
+// With mocks base method
+func (m *MockDebugLogger) With(args ...any) DebugLogger {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "With", varargs...)
+	ret0, _ := ret[0].(DebugLogger)
+	return ret0
+}
+
+// With indicates an expected call of With
+func (mr *MockDebugLoggerMockRecorder) With(args ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrintVersion", reflect.TypeOf((*MockDebugLogger)(nil).PrintVersion), v...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "With", reflect.TypeOf((*MockDebugLogger)(nil).With), args...)
 }
@@ -0,0 +1,164 @@
+/*
+Package proxy dials network connections through SOCKS5 and HTTP CONNECT
+proxies, configured by URL
+*/
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// Direct is the proxy setting that connects without a proxy, overriding a
+// proxy configured for both sides of the bridge
+const Direct = "direct"
+
+/*
+Dialer dials network connections. It matches the girc.Dialer interface.
+*/
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+/*
+Schemes lists the supported proxy URL schemes
+*/
+var Schemes = []string{"socks5", "socks5h", "http", "https"}
+
+/*
+IsValid returns whether the setting is Direct or a URL of a supported proxy
+*/
+func IsValid(setting string) bool {
+	if setting == Direct {
+		return true
+	}
+	u, err := url.Parse(setting)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, scheme := range Schemes {
+		if u.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Redacted returns the proxy setting with the password masked, for logging
+*/
+func Redacted(setting string) string {
+	u, err := url.Parse(setting)
+	if err != nil {
+		return setting
+	}
+	return u.Redacted()
+}
+
+/*
+NewDialer returns a dialer that connects through the proxy at proxyURL,
+using forward to reach the proxy. Without a proxy, forward is returned.
+*/
+func NewDialer(proxyURL string, forward *net.Dialer) (Dialer, error) {
+	if proxyURL == "" || proxyURL == Direct {
+		return forward, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return xproxy.FromURL(u, forward)
+	case "http", "https":
+		return &connectDialer{proxy: u, forward: forward}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+/*
+connectDialer tunnels connections through an HTTP proxy with the CONNECT method
+*/
+type connectDialer struct {
+	proxy   *url.URL
+	forward *net.Dialer
+}
+
+/*
+Dial connects to addr through the proxy
+*/
+func (d *connectDialer) Dial(network, addr string) (net.Conn, error) {
+	proxyAddr := d.proxy.Host
+	if d.proxy.Port() == "" {
+		if d.proxy.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(d.proxy.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(d.proxy.Hostname(), "8080")
+		}
+	}
+
+	conn, err := d.forward.Dial(network, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if d.proxy.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: d.proxy.Hostname()})
+	}
+	if d.forward.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.forward.Timeout))
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if d.proxy.User != nil {
+		password, _ := d.proxy.User.Password()
+		credentials := d.proxy.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", d.proxy.Host, addr, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	// The server may have spoken first, so keep what was read past the response
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+/*
+bufferedConn is a connection that reads through a buffered reader
+*/
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
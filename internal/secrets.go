@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// credentialsDirEnv is set by systemd to the directory holding the
+// credentials of LoadCredential= and SetCredential=
+const credentialsDirEnv = "CREDENTIALS_DIRECTORY"
+
+/*
+loadSecretFiles sets secret settings from files, so they do not have to be
+in the config file. NAME_FILE names a file holding the value of NAME.
+Otherwise, a systemd credential named NAME is used, unless NAME is set.
+*/
+func loadSecretFiles() error {
+	credentialsDir := os.Getenv(credentialsDirEnv)
+	for _, s := range settingsByKey() {
+		if !s.secret {
+			continue
+		}
+		_, set := os.LookupEnv(s.env)
+		if path, ok := os.LookupEnv(s.env + "_FILE"); ok {
+			if set {
+				return fmt.Errorf("both %s and %s_FILE are set, remove one of them", s.env, s.env)
+			}
+			value, err := readSecret(path)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", s.env, err)
+			}
+			if err := setFileEnv(s.env, value); err != nil {
+				return err
+			}
+			continue
+		}
+		if credentialsDir == "" || set {
+			continue
+		}
+		value, err := readSecret(filepath.Join(credentialsDir, s.env))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("credential %s: %w", s.env, err)
+		}
+		if err := setFileEnv(s.env, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+readSecret returns the contents of a secret file, without the line break
+editors add at the end
+*/
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+/*
+secretValues returns the values of the secret settings that are set
+*/
+func (s *Settings) secretValues() []string {
+	var values []string
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i))
+			} else if field.Tag.Get("secret") == "true" && v.Field(i).String() != "" {
+				values = append(values, v.Field(i).String())
+			}
+		}
+	}
+	walk(reflect.ValueOf(s).Elem())
+	return values
+}
+
+// secrets scrubs the values of secret settings, and of the secrets the bot
+// obtains while running, from log lines
+var secrets struct {
+	sync.RWMutex
+	settings []string
+	runtime  []string
+	replacer *strings.Replacer
+}
+
+/*
+setSecrets sets the values of secret settings that are scrubbed from log
+lines
+*/
+func setSecrets(values []string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.settings = append([]string(nil), values...)
+	updateReplacer()
+}
+
+/*
+AddSecret scrubs value from log lines from now on. Use it for secrets that
+are not settings, such as access tokens obtained while running.
+*/
+func AddSecret(value string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	if value == "" || slices.Contains(secrets.runtime, value) {
+		return
+	}
+	secrets.runtime = append(secrets.runtime, value)
+	updateReplacer()
+}
+
+/*
+updateReplacer rebuilds the replacer from the known secrets. Callers must
+hold the lock on secrets.
+*/
+func updateReplacer() {
+	// Longer secrets go first, in case one contains another
+	values := append(append([]string(nil), secrets.settings...), secrets.runtime...)
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	var pairs []string
+	for _, value := range values {
+		pairs = append(pairs, value, "***")
+	}
+	secrets.replacer = strings.NewReplacer(pairs...)
+}
+
+/*
+redact replaces the known secrets in s with ***
+*/
+func redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	if secrets.replacer == nil {
+		return s
+	}
+	return secrets.replacer.Replace(s)
+}
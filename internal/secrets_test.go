@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const secretTestToken = "000000000:AAAAAAaAAa2AaAAaoAAAA-a_aaAAaAaaaAA"
+
+/*
+writeSecret writes a secret file with a trailing line break, like editors do
+*/
+func writeSecret(t *testing.T, dir, name, value string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(value+"\n"), 0600))
+	return path
+}
+
+func TestLoadConfigSecretFile(t *testing.T) {
+	t.Cleanup(func() { setSecrets(nil) })
+	path := writeConfig(t, "teleirc.yaml", `
+irc:
+  server: irc.example.com
+  channel: "#bridge"
+  bot_name: teleirc
+telegram:
+  chat_id: -100
+`)
+	dir := t.TempDir()
+	t.Setenv("TELEIRC_TOKEN_FILE", writeSecret(t, dir, "token", secretTestToken))
+	t.Setenv("IRC_NICKSERV_PASS_FILE", writeSecret(t, dir, "nickserv", "hunter2"))
+
+	settings, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, secretTestToken, settings.Telegram.Token)
+	assert.Equal(t, "hunter2", settings.IRC.NickServPassword)
+}
+
+func TestLoadConfigSecretFileKey(t *testing.T) {
+	t.Cleanup(func() { setSecrets(nil) })
+	token := writeSecret(t, t.TempDir(), "token", secretTestToken)
+	path := writeConfig(t, "teleirc.toml", `
+[irc]
+server = "irc.example.com"
+channel = "#bridge"
+bot_name = "teleirc"
+
+[telegram]
+teleirc_token_file = "`+token+`"
+chat_id = -100
+`)
+	settings, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, secretTestToken, settings.Telegram.Token)
+}
+
+func TestLoadConfigCredentials(t *testing.T) {
+	t.Cleanup(func() { setSecrets(nil) })
+	path := writeConfig(t, "teleirc.yaml", `
+irc:
+  server: irc.example.com
+  channel: "#bridge"
+  bot_name: teleirc
+  server_password: from-config
+telegram:
+  chat_id: -100
+`)
+	dir := t.TempDir()
+	writeSecret(t, dir, "TELEIRC_TOKEN", secretTestToken)
+	writeSecret(t, dir, "IRC_SERVER_PASSWORD", "from-credential")
+	t.Setenv(credentialsDirEnv, dir)
+
+	settings, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, secretTestToken, settings.Telegram.Token)
+	assert.Equal(t, "from-config", settings.IRC.ServerPass, "the config file should win over a credential")
+}
+
+func TestLoadConfigSecretSetTwice(t *testing.T) {
+	path := writeConfig(t, "teleirc.yaml", `
+irc:
+  server: irc.example.com
+  channel: "#bridge"
+  bot_name: teleirc
+telegram:
+  teleirc_token: "`+secretTestToken+`"
+  teleirc_token_file: /run/secrets/token
+  chat_id: -100
+`)
+	_, err := LoadConfig(path)
+	assert.ErrorContains(t, err, "both TELEIRC_TOKEN and TELEIRC_TOKEN_FILE are set")
+}
+
+func TestLoadConfigSecretFileMissing(t *testing.T) {
+	path := writeConfig(t, "teleirc.yaml", `
+irc:
+  server: irc.example.com
+  channel: "#bridge"
+  bot_name: teleirc
+telegram:
+  chat_id: -100
+`)
+	t.Setenv("TELEIRC_TOKEN_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err := LoadConfig(path)
+	assert.ErrorContains(t, err, "TELEIRC_TOKEN_FILE")
+}
+
+func TestRedact(t *testing.T) {
+	t.Cleanup(func() { setSecrets(nil) })
+	assert.Equal(t, "token abc", redact("token abc"))
+
+	setSecrets([]string{"abc", "abcdef"})
+	assert.Equal(t, "token *** and ***", redact("token abcdef and abc"))
+	assert.Equal(t, "nothing to hide", redact("nothing to hide"))
+}
+
+func TestAddSecret(t *testing.T) {
+	t.Cleanup(func() {
+		secrets.Lock()
+		secrets.runtime = nil
+		secrets.Unlock()
+		setSecrets(nil)
+	})
+	setSecrets([]string{"abc"})
+	AddSecret("access-token")
+	AddSecret("")
+	assert.Equal(t, "*** and ***", redact("abc and access-token"))
+
+	// Reloading the settings keeps the secrets obtained while running
+	setSecrets([]string{"def"})
+	assert.Equal(t, "abc and *** and ***", redact("abc and def and access-token"))
+}
+
+func TestSecretValues(t *testing.T) {
+	settings := &Settings{}
+	settings.Telegram.Token = secretTestToken
+	settings.Imgur.ImgurClientSecret = "imgur"
+	settings.IRC.Channel = "#bridge"
+	assert.ElementsMatch(t, []string{secretTestToken, "imgur"}, settings.secretValues())
+}
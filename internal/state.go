@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+/*
+State contains values that TeleIRC learns while running and that should
+survive a restart, such as the new chat ID of a migrated Telegram group.
+*/
+type State struct {
+	// TelegramChatID replaces TelegramOldChatID as the bridged chat
+	TelegramChatID    int64 `json:"telegram_chat_id,omitempty"`
+	TelegramOldChatID int64 `json:"telegram_old_chat_id,omitempty"`
+}
+
+/*
+LoadState reads the state file at path. A missing file is not an error
+and results in an empty State.
+*/
+func LoadState(path string) (*State, error) {
+	state := &State{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+/*
+Save writes the state to path with WriteFileAtomic
+*/
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data)
+}
+
+/*
+WriteFileAtomic writes data to path. The file is written to a temporary file
+first and then renamed, so a crash never leaves a truncated file.
+*/
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadStateMissing(t *testing.T) {
+	state, err := LoadState(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, &State{}, state, "Missing state file should give an empty state")
+}
+
+func TestStateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	saved := &State{TelegramChatID: -1000000000100, TelegramOldChatID: -100}
+	assert.NoError(t, saved.Save(path))
+
+	loaded, err := LoadState(path)
+	assert.NoError(t, err)
+	assert.Equal(t, saved, loaded, "Loaded state should match saved state")
+}
+
+func TestLoadStateInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	assert.NoError(t, os.WriteFile(path, []byte("not json"), 0600))
+
+	_, err := LoadState(path)
+	assert.Error(t, err, "Invalid state file should fail to load")
+}
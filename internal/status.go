@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+SideStatus is the state of one side of the bridge, as reported by /readyz
+*/
+type SideStatus struct {
+	Ready bool `json:"ready"`
+	// Reason says why the side is not ready
+	Reason         string     `json:"reason,omitempty"`
+	ConnectedSince *time.Time `json:"connected_since,omitempty"`
+	LastMessage    *time.Time `json:"last_message,omitempty"`
+	// LastEvent is the last line received from the IRC server
+	LastEvent *time.Time `json:"last_event,omitempty"`
+	// LastPoll is the last successful getUpdates request to Telegram
+	LastPoll *time.Time `json:"last_poll,omitempty"`
+	// LagSeconds is the last measured lag to the IRC server
+	LagSeconds  float64    `json:"lag_seconds,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+/*
+Status tracks the connection of one side of the bridge. A nil Status
+tracks nothing, so clients built without one still work.
+*/
+type Status struct {
+	mu             sync.Mutex
+	connectedSince time.Time
+	lastMessage    time.Time
+	lastEvent      time.Time
+	lastPoll       time.Time
+	lag            time.Duration
+	lastError      string
+	lastErrorAt    time.Time
+}
+
+/*
+SetConnected records that the side connected just now
+*/
+func (s *Status) SetConnected() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connectedSince = time.Now()
+}
+
+/*
+SetDisconnected records that the side lost its connection, because of err
+if it is not nil
+*/
+func (s *Status) SetDisconnected(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.connectedSince = time.Time{}
+	s.lag = 0
+	s.mu.Unlock()
+	s.SetError(err)
+}
+
+/*
+SetError records an error, unless it is nil. Secrets are scrubbed from it,
+since errors of HTTP requests to the Bot API contain the token.
+*/
+func (s *Status) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = redact(err.Error())
+	s.lastErrorAt = time.Now()
+}
+
+/*
+MessageReceived records that a message from the bridged chat arrived
+*/
+func (s *Status) MessageReceived() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastMessage = time.Now()
+}
+
+/*
+EventReceived records that the server sent something, which shows the
+connection is alive
+*/
+func (s *Status) EventReceived() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastEvent = time.Now()
+}
+
+/*
+Polled records a successful request for updates
+*/
+func (s *Status) Polled() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastPoll = time.Now()
+}
+
+/*
+SetLag records the lag measured to the server
+*/
+func (s *Status) SetLag(lag time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lag = lag
+}
+
+/*
+Snapshot returns the recorded state. Whether the side is ready is up to
+the client.
+*/
+func (s *Status) Snapshot() SideStatus {
+	if s == nil {
+		return SideStatus{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SideStatus{
+		ConnectedSince: timeOrNil(s.connectedSince),
+		LastMessage:    timeOrNil(s.lastMessage),
+		LastEvent:      timeOrNil(s.lastEvent),
+		LastPoll:       timeOrNil(s.lastPoll),
+		LagSeconds:     s.lag.Seconds(),
+		LastError:      s.lastError,
+		LastErrorAt:    timeOrNil(s.lastErrorAt),
+	}
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
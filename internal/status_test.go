@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	t.Cleanup(func() { setSecrets(nil) })
+	setSecrets([]string{"secret-token"})
+	s := &Status{}
+	assert.Equal(t, SideStatus{}, s.Snapshot())
+
+	s.SetConnected()
+	s.MessageReceived()
+	s.EventReceived()
+	snapshot := s.Snapshot()
+	assert.NotNil(t, snapshot.ConnectedSince)
+	assert.NotNil(t, snapshot.LastMessage)
+	assert.NotNil(t, snapshot.LastEvent)
+	assert.Nil(t, snapshot.LastPoll)
+
+	s.SetDisconnected(errors.New(`Post "https://api.telegram.org/botsecret-token/getUpdates": EOF`))
+	snapshot = s.Snapshot()
+	assert.Nil(t, snapshot.ConnectedSince)
+	assert.Equal(t, `Post "https://api.telegram.org/bot***/getUpdates": EOF`, snapshot.LastError)
+	assert.NotNil(t, snapshot.LastErrorAt)
+
+	// Disconnecting without an error keeps the last one
+	s.SetDisconnected(nil)
+	assert.Equal(t, snapshot.LastError, s.Snapshot().LastError)
+}
+
+func TestStatusNil(t *testing.T) {
+	var s *Status
+	s.SetConnected()
+	s.SetError(errors.New("failed"))
+	s.Polled()
+	assert.Equal(t, SideStatus{}, s.Snapshot())
+}
@@ -0,0 +1,89 @@
+/*
+Package systemd tells systemd about the state of the service over the
+notify socket, see sd_notify(3)
+*/
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+Notifier sends notifications to systemd. A nil Notifier, returned when
+the service was not started by systemd with Type=notify, sends nothing.
+*/
+type Notifier struct {
+	conn     *net.UnixConn
+	watchdog time.Duration
+}
+
+/*
+NewNotifier connects to the socket in NOTIFY_SOCKET, and reads the watchdog
+interval from WATCHDOG_USEC. It returns nil if NOTIFY_SOCKET is unset.
+*/
+func NewNotifier() (*Notifier, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil, nil
+	}
+	// A leading @ stands for the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Notifier{conn: conn, watchdog: watchdogInterval()}, nil
+}
+
+/*
+watchdogInterval returns the interval systemd expects WATCHDOG=1 in, or 0
+if the watchdog is disabled or meant for another process
+*/
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+/*
+Notify sends a state, such as "READY=1" or "STATUS=...". Several states are
+separated by newlines.
+*/
+func (n *Notifier) Notify(state string) error {
+	if n == nil {
+		return nil
+	}
+	_, err := n.conn.Write([]byte(state))
+	return err
+}
+
+/*
+WatchdogInterval returns the interval systemd expects WATCHDOG=1 in, or 0
+if the watchdog is disabled
+*/
+func (n *Notifier) WatchdogInterval() time.Duration {
+	if n == nil {
+		return 0
+	}
+	return n.watchdog
+}
+
+/*
+Close closes the connection to the notify socket
+*/
+func (n *Notifier) Close() error {
+	if n == nil {
+		return nil
+	}
+	return n.conn.Close()
+}
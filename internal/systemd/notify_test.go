@@ -0,0 +1,73 @@
+package systemd
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ritlug/teleirc/internal/testing/fakesystemd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifierDisabled(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	n, err := NewNotifier()
+	require.NoError(t, err)
+	assert.Nil(t, n)
+	// A nil Notifier does nothing
+	assert.NoError(t, n.Notify("READY=1"))
+	assert.Zero(t, n.WatchdogInterval())
+	assert.NoError(t, n.Close())
+}
+
+func TestNotify(t *testing.T) {
+	s, err := fakesystemd.New(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	t.Setenv("NOTIFY_SOCKET", s.Path)
+	t.Setenv("WATCHDOG_USEC", "")
+
+	n, err := NewNotifier()
+	require.NoError(t, err)
+	defer n.Close()
+	assert.Zero(t, n.WatchdogInterval())
+
+	require.NoError(t, n.Notify("READY=1\nSTATUS=Connected"))
+	assert.True(t, s.WaitFor("STATUS=Connected", time.Second))
+	assert.Equal(t, []string{"READY=1", "STATUS=Connected"}, s.States())
+}
+
+func TestNotifyMissingSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", t.TempDir()+"/missing.sock")
+	_, err := NewNotifier()
+	assert.Error(t, err)
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	s, err := fakesystemd.New(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	t.Setenv("NOTIFY_SOCKET", s.Path)
+
+	tests := []struct {
+		usec, pid string
+		expected  time.Duration
+	}{
+		{"30000000", "", 30 * time.Second},
+		{"30000000", strconv.Itoa(os.Getpid()), 30 * time.Second},
+		// The watchdog is meant for another process
+		{"30000000", "1", 0},
+		{"0", "", 0},
+		{"nonsense", "", 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		n, err := NewNotifier()
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, n.WatchdogInterval(), "WATCHDOG_USEC=%s WATCHDOG_PID=%s", tt.usec, tt.pid)
+		n.Close()
+	}
+}
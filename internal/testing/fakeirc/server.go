@@ -0,0 +1,1083 @@
+/*
+Package fakeirc provides a small scriptable IRC server for tests. It listens
+on localhost, so the real IRC client code can connect to it, and lets tests
+act as other users on the network.
+*/
+package fakeirc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+// ServerName is the name the fake server uses as the source of its replies
+const ServerName = "irc.fake"
+
+// ChatHistoryLimit is the most messages one CHATHISTORY request returns
+const ChatHistoryLimit = 100
+
+// timeFormat is the format of server-time tags
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+const (
+	// nickServNick is the nick of the NickServ service
+	nickServNick = "NickServ"
+	// errNeedReggedNick is sent when joining a +r channel without an account
+	errNeedReggedNick = "477"
+)
+
+/*
+Server is a fake IRC server. Clients connect to Host and Port.
+*/
+type Server struct {
+	Host string
+	Port int
+	// URL is the ws:// or wss:// URL of a server started with NewWebSocket
+	URL string
+
+	// Caps lists the capabilities offered in reply to CAP LS, mapped to their
+	// values. "sasl" is offered automatically when SASL is configured.
+	Caps map[string]string
+	// Password is the server password clients must send with PASS, if set
+	Password string
+	// Accounts maps account names to passwords for SASL PLAIN
+	Accounts map[string]string
+	// ExternalAccount is the account SASL EXTERNAL logs in to, if set
+	ExternalAccount string
+	// CertAccounts maps SHA-512 fingerprints of TLS client certificates to
+	// the accounts SASL EXTERNAL logs in to. It takes precedence over
+	// ExternalAccount.
+	CertAccounts map[string]string
+	// NoSASL stops the server from offering "sasl" when SASL is configured
+	NoSASL bool
+	// NickServ runs a NickServ service that handles IDENTIFY, GHOST and
+	// REGAIN for the accounts in Accounts. Account names are nicks.
+	NickServ bool
+	// NoRegain makes NickServ answer REGAIN as an unknown command, like
+	// services that only have GHOST
+	NoRegain bool
+	// ChatHistory offers draft/chathistory, with batch, server-time and
+	// message-tags, and keeps the messages sent to channels so clients can
+	// fetch them with CHATHISTORY. Messages and joins get msgid and time
+	// tags.
+	ChatHistory bool
+
+	listener net.Listener
+
+	mu       sync.Mutex
+	conns    map[*conn]struct{}
+	users    map[string]*user
+	channels map[string]*channel
+	received []girc.Event
+	// lastID numbers the msgid tags
+	lastID int
+	// lastBatch numbers the batches sent
+	lastBatch int
+	// changed is closed and replaced whenever a line is received
+	changed chan struct{}
+	wg      sync.WaitGroup
+}
+
+type user struct {
+	nick  string
+	ident string
+	host  string
+	// conn is nil for users played by the test
+	conn *conn
+}
+
+func (u *user) source() string {
+	return u.nick + "!" + u.ident + "@" + u.host
+}
+
+type channel struct {
+	name string
+	key  string
+	// registeredOnly is channel mode +r: only logged in users may join
+	registeredOnly bool
+	topic          string
+	members        map[string]*user
+	// history keeps the tagged messages sent to the channel, oldest first
+	history []*girc.Event
+}
+
+type conn struct {
+	net.Conn
+	server *Server
+	writeM sync.Mutex
+	user   *user
+	// stalled drops everything sent to the client, see Stall
+	stalled bool
+
+	pass          string
+	gotUser       bool
+	registered    bool
+	negotiating   bool
+	caps          map[string]bool
+	saslMechanism string
+	account       string
+}
+
+/*
+New starts a fake IRC server listening on a random localhost port
+*/
+func New() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := NewWithListener(l)
+	return s, nil
+}
+
+/*
+NewWithListener starts a fake IRC server accepting connections from l. Use
+this to serve IRC over TLS or any other transport.
+*/
+func NewWithListener(l net.Listener) *Server {
+	addr := l.Addr().(*net.TCPAddr)
+	s := &Server{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Caps:     map[string]string{},
+		Accounts: map[string]string{},
+		listener: l,
+		conns:    map[*conn]struct{}{},
+		users:    map[string]*user{},
+		channels: map[string]*channel{},
+		changed:  make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+/*
+Addr returns the host:port the server listens on
+*/
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+/*
+Close stops the server and disconnects every client
+*/
+func (s *Server) Close() {
+	s.listener.Close()
+	s.Disconnect()
+	s.wg.Wait()
+}
+
+/*
+Disconnect closes every client connection, leaving the server running
+*/
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+/*
+Stall stops the server from sending anything on the open connections,
+while it keeps reading from them, like a connection behind a half-dead NAT.
+New connections work normally.
+*/
+func (s *Server) Stall() {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.writeM.Lock()
+		c.stalled = true
+		c.writeM.Unlock()
+	}
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{Conn: nc, server: s, caps: map[string]bool{}}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+		}()
+	}
+}
+
+/*
+Received returns every line clients sent to the server so far
+*/
+func (s *Server) Received() []girc.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]girc.Event(nil), s.received...)
+}
+
+/*
+WaitFor waits until a client sent a line that match returns true for, and
+returns it. The second return value is false if the timeout passed first.
+Lines received before WaitFor was called are checked too.
+*/
+func (s *Server) WaitFor(match func(girc.Event) bool, timeout time.Duration) (girc.Event, bool) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		received := s.received
+		s.mu.Unlock()
+
+		for _, e := range received {
+			if match(e) {
+				return e, true
+			}
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return girc.Event{}, false
+		}
+	}
+}
+
+/*
+WaitForCommand waits until a client sent a line with the given command and
+first parameter
+*/
+func (s *Server) WaitForCommand(command, target string, timeout time.Duration) (girc.Event, bool) {
+	return s.WaitFor(func(e girc.Event) bool {
+		return e.Command == command && len(e.Params) > 0 && e.Params[0] == target
+	}, timeout)
+}
+
+/*
+Join makes a user played by the test join a channel
+*/
+func (s *Server) Join(nick, channelName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.fakeUser(nick)
+	ch := s.channel(channelName)
+	ch.members[strings.ToLower(u.nick)] = u
+	join := &girc.Event{Source: girc.ParseSource(u.source()), Command: girc.JOIN, Params: []string{ch.name}}
+	s.tag(join)
+	s.broadcast(ch, nil, join)
+}
+
+/*
+Part makes a user played by the test leave a channel
+*/
+func (s *Server) Part(nick, channelName, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.fakeUser(nick)
+	ch := s.channel(channelName)
+	s.broadcast(ch, nil, &girc.Event{Source: girc.ParseSource(u.source()), Command: girc.PART, Params: []string{ch.name, reason}})
+	delete(ch.members, strings.ToLower(u.nick))
+}
+
+/*
+Say sends a PRIVMSG from a user played by the test to a channel or nick
+*/
+func (s *Server) Say(nick, target, text string) {
+	s.message(nick, girc.PRIVMSG, target, text)
+}
+
+/*
+Notice sends a NOTICE from a user played by the test to a channel or nick
+*/
+func (s *Server) Notice(nick, target, text string) {
+	s.message(nick, girc.NOTICE, target, text)
+}
+
+/*
+Action sends a CTCP ACTION (/me) from a user played by the test
+*/
+func (s *Server) Action(nick, target, text string) {
+	s.message(nick, girc.PRIVMSG, target, "\x01ACTION "+text+"\x01")
+}
+
+func (s *Server) message(nick, command, target, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.fakeUser(nick)
+	e := &girc.Event{Source: girc.ParseSource(u.source()), Command: command, Params: []string{target, text}}
+	if girc.IsValidChannel(target) {
+		s.keep(s.channel(target), e)
+		s.broadcast(s.channel(target), nil, e)
+	} else if to, ok := s.users[strings.ToLower(target)]; ok && to.conn != nil {
+		to.conn.send(e)
+	}
+}
+
+/*
+SetTopic changes the topic of a channel as a user played by the test
+*/
+func (s *Server) SetTopic(nick, channelName, topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.fakeUser(nick)
+	ch := s.channel(channelName)
+	ch.topic = topic
+	s.broadcast(ch, nil, &girc.Event{Source: girc.ParseSource(u.source()), Command: girc.TOPIC, Params: []string{ch.name, topic}})
+}
+
+/*
+SetKey sets the key clients need to join a channel
+*/
+func (s *Server) SetKey(channelName, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channel(channelName).key = key
+}
+
+/*
+SetRegisteredOnly sets channel mode +r, so only logged in clients can join
+*/
+func (s *Server) SetRegisteredOnly(channelName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channel(channelName).registeredOnly = true
+}
+
+/*
+Kick removes a user from a channel as a user played by the test
+*/
+func (s *Server) Kick(nick, channelName, target, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.fakeUser(nick)
+	ch := s.channel(channelName)
+	s.broadcast(ch, nil, &girc.Event{Source: girc.ParseSource(u.source()), Command: girc.KICK, Params: []string{ch.name, target, reason}})
+	delete(ch.members, strings.ToLower(target))
+}
+
+/*
+ChangeNick renames a user played by the test
+*/
+func (s *Server) ChangeNick(nick, newNick string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.fakeUser(nick)
+	s.renameUser(u, newNick)
+}
+
+/*
+Quit disconnects a user played by the test from the network
+*/
+func (s *Server) Quit(nick, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.fakeUser(nick)
+	s.removeUser(u, reason)
+}
+
+/*
+Send writes a raw line to every connected client
+*/
+func (s *Server) Send(raw string) {
+	e := girc.ParseEvent(raw)
+	if e == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.send(e)
+	}
+}
+
+/*
+fakeUser returns the user played by the test with the given nick, creating
+it if needed. Callers must hold mu.
+*/
+func (s *Server) fakeUser(nick string) *user {
+	if u, ok := s.users[strings.ToLower(nick)]; ok {
+		return u
+	}
+	u := &user{nick: nick, ident: strings.ToLower(nick), host: "fake.user"}
+	s.users[strings.ToLower(nick)] = u
+	return u
+}
+
+/*
+channel returns the channel with the given name, creating it if needed.
+Callers must hold mu.
+*/
+func (s *Server) channel(name string) *channel {
+	if ch, ok := s.channels[strings.ToLower(name)]; ok {
+		return ch
+	}
+	ch := &channel{name: name, members: map[string]*user{}}
+	s.channels[strings.ToLower(name)] = ch
+	return ch
+}
+
+/*
+broadcast sends an event to every connected client in a channel except
+skip. Callers must hold mu.
+*/
+func (s *Server) broadcast(ch *channel, skip *conn, e *girc.Event) {
+	for _, member := range ch.members {
+		if member.conn != nil && member.conn != skip {
+			member.conn.send(e)
+		}
+	}
+}
+
+/*
+tag adds msgid and time tags to an event when ChatHistory is set. Callers
+must hold mu.
+*/
+func (s *Server) tag(e *girc.Event) {
+	if !s.ChatHistory {
+		return
+	}
+	s.lastID++
+	e.Tags = girc.Tags{"msgid": fmt.Sprintf("fake-%d", s.lastID), "time": time.Now().UTC().Format(timeFormat)}
+}
+
+/*
+keep tags a message sent to a channel, and adds it to the channel's history
+when ChatHistory is set. Callers must hold mu.
+*/
+func (s *Server) keep(ch *channel, e *girc.Event) {
+	if !s.ChatHistory {
+		return
+	}
+	s.tag(e)
+	ch.history = append(ch.history, e)
+}
+
+/*
+broadcastShared sends an event to every connected client sharing a channel
+with u, and to u itself. Callers must hold mu.
+*/
+func (s *Server) broadcastShared(u *user, e *girc.Event) {
+	sent := map[*conn]bool{}
+	if u.conn != nil {
+		u.conn.send(e)
+		sent[u.conn] = true
+	}
+	for _, ch := range s.channels {
+		if _, ok := ch.members[strings.ToLower(u.nick)]; !ok {
+			continue
+		}
+		for _, member := range ch.members {
+			if member.conn != nil && !sent[member.conn] {
+				member.conn.send(e)
+				sent[member.conn] = true
+			}
+		}
+	}
+}
+
+/*
+renameUser changes the nick of a user and tells everyone who can see it.
+Callers must hold mu.
+*/
+func (s *Server) renameUser(u *user, newNick string) {
+	s.broadcastShared(u, &girc.Event{Source: girc.ParseSource(u.source()), Command: girc.NICK, Params: []string{newNick}})
+	delete(s.users, strings.ToLower(u.nick))
+	for _, ch := range s.channels {
+		if _, ok := ch.members[strings.ToLower(u.nick)]; ok {
+			delete(ch.members, strings.ToLower(u.nick))
+			ch.members[strings.ToLower(newNick)] = u
+		}
+	}
+	u.nick = newNick
+	s.users[strings.ToLower(newNick)] = u
+}
+
+/*
+removeUser removes a user from the network and tells everyone who can see
+it. Callers must hold mu.
+*/
+func (s *Server) removeUser(u *user, reason string) {
+	quit := &girc.Event{Source: girc.ParseSource(u.source()), Command: girc.QUIT, Params: []string{reason}}
+	for _, ch := range s.channels {
+		if _, ok := ch.members[strings.ToLower(u.nick)]; !ok {
+			continue
+		}
+		delete(ch.members, strings.ToLower(u.nick))
+		s.broadcast(ch, u.conn, quit)
+	}
+	if s.users[strings.ToLower(u.nick)] == u {
+		delete(s.users, strings.ToLower(u.nick))
+	}
+}
+
+func (c *conn) send(e *girc.Event) {
+	c.writeM.Lock()
+	defer c.writeM.Unlock()
+	if c.stalled {
+		return
+	}
+	if len(e.Tags) > 0 && !c.caps["message-tags"] {
+		e = e.Copy()
+		e.Tags = nil
+	}
+	c.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	c.Write(append(e.Bytes(), '\r', '\n'))
+}
+
+/*
+reply sends a numeric or command from the server to the client
+*/
+func (c *conn) reply(command string, params ...string) {
+	c.send(&girc.Event{Source: &girc.Source{Name: ServerName}, Command: command, Params: params})
+}
+
+/*
+numeric sends a numeric reply addressed to the client's nick
+*/
+func (c *conn) numeric(command string, params ...string) {
+	nick := "*"
+	if c.user != nil && c.user.nick != "" {
+		nick = c.user.nick
+	}
+	c.reply(command, append([]string{nick}, params...)...)
+}
+
+func (c *conn) serve() {
+	s := c.server
+	defer func() {
+		c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		if c.user != nil {
+			s.removeUser(c.user, "Connection closed")
+		}
+		s.mu.Unlock()
+	}()
+
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		line := string(bytes.TrimRight(scanner.Bytes(), "\r"))
+		e := girc.ParseEvent(line)
+		if e == nil {
+			continue
+		}
+
+		s.mu.Lock()
+		s.received = append(s.received, *e.Copy())
+		close(s.changed)
+		s.changed = make(chan struct{})
+		quit := c.handle(e)
+		s.mu.Unlock()
+
+		if quit {
+			return
+		}
+	}
+}
+
+/*
+handle processes a line sent by the client and returns whether the
+connection should be closed. Callers must hold the server's mu.
+*/
+func (c *conn) handle(e *girc.Event) bool {
+	s := c.server
+
+	switch e.Command {
+	case girc.CAP:
+		c.handleCAP(e)
+	case girc.PASS:
+		if len(e.Params) > 0 {
+			c.pass = e.Params[0]
+		}
+	case girc.AUTHENTICATE:
+		c.handleAuthenticate(e)
+	case girc.NICK:
+		if len(e.Params) == 0 {
+			c.numeric(girc.ERR_NONICKNAMEGIVEN, "No nickname given")
+			return false
+		}
+		newNick := e.Params[0]
+		if other, ok := s.users[strings.ToLower(newNick)]; ok && other != c.user {
+			c.numeric(girc.ERR_NICKNAMEINUSE, newNick, "Nickname is already in use")
+			return false
+		}
+		if c.user == nil {
+			c.user = &user{nick: newNick, host: "127.0.0.1", conn: c}
+			s.users[strings.ToLower(newNick)] = c.user
+		} else if c.registered {
+			s.renameUser(c.user, newNick)
+		} else {
+			delete(s.users, strings.ToLower(c.user.nick))
+			c.user.nick = newNick
+			s.users[strings.ToLower(newNick)] = c.user
+		}
+	case girc.USER:
+		if len(e.Params) > 0 && c.user != nil {
+			c.user.ident = e.Params[0]
+		}
+		c.gotUser = true
+	case girc.PING:
+		c.reply(girc.PONG, append([]string{ServerName}, e.Params...)...)
+	case girc.PONG:
+		// Nothing to do
+	case girc.QUIT:
+		reason := ""
+		if len(e.Params) > 0 {
+			reason = e.Last()
+		}
+		c.reply(girc.ERROR, "Closing Link: "+reason)
+		if c.user != nil {
+			s.removeUser(c.user, reason)
+		}
+		return true
+	default:
+		if !c.registered {
+			c.numeric(girc.ERR_NOTREGISTERED, "You have not registered")
+			return false
+		}
+		c.handleRegistered(e)
+	}
+
+	if !c.registered && !c.negotiating && c.user != nil && c.gotUser {
+		return c.register()
+	}
+	return false
+}
+
+/*
+register completes client registration, returning whether the connection
+should be closed
+*/
+func (c *conn) register() bool {
+	if c.server.Password != "" && c.pass != c.server.Password {
+		c.numeric(girc.ERR_PASSWDMISMATCH, "Password incorrect")
+		c.reply(girc.ERROR, "Closing Link: Bad password")
+		return true
+	}
+	c.registered = true
+	c.numeric(girc.RPL_WELCOME, "Welcome to the fake IRC network "+c.user.nick)
+	c.numeric(girc.RPL_YOURHOST, "Your host is "+ServerName)
+	c.numeric(girc.RPL_CREATED, "This server was created today")
+	c.numeric(girc.RPL_MYINFO, ServerName, "fakeirc-1.0", "iow", "klnt")
+	isupport := []string{"CHANTYPES=#", "NETWORK=FakeNet"}
+	if c.server.ChatHistory {
+		isupport = append(isupport, "CHATHISTORY="+strconv.Itoa(ChatHistoryLimit))
+	}
+	c.numeric(girc.RPL_ISUPPORT, append(isupport, "are supported by this server")...)
+	c.numeric(girc.ERR_NOMOTD, "MOTD File is missing")
+	return false
+}
+
+func (c *conn) handleCAP(e *girc.Event) {
+	if len(e.Params) == 0 {
+		return
+	}
+	switch strings.ToUpper(e.Params[0]) {
+	case girc.CAP_LS:
+		if !c.registered {
+			c.negotiating = true
+		}
+		c.reply(girc.CAP, "*", girc.CAP_LS, strings.Join(c.offeredCaps(), " "))
+	case girc.CAP_REQ:
+		if !c.registered {
+			c.negotiating = true
+		}
+		offered := c.server.capMap()
+		requested := strings.Fields(e.Last())
+		for _, name := range requested {
+			if _, ok := offered[strings.TrimPrefix(name, "-")]; !ok {
+				c.reply(girc.CAP, "*", girc.CAP_NAK, e.Last())
+				return
+			}
+		}
+		for _, name := range requested {
+			if strings.HasPrefix(name, "-") {
+				delete(c.caps, name[1:])
+			} else {
+				c.caps[name] = true
+			}
+		}
+		c.reply(girc.CAP, "*", girc.CAP_ACK, e.Last())
+	case girc.CAP_LIST:
+		var enabled []string
+		for name := range c.caps {
+			enabled = append(enabled, name)
+		}
+		c.reply(girc.CAP, "*", girc.CAP_LIST, strings.Join(enabled, " "))
+	case girc.CAP_END:
+		c.negotiating = false
+	}
+}
+
+/*
+capMap returns the capabilities the server offers. Callers must hold mu.
+*/
+func (s *Server) capMap() map[string]string {
+	caps := map[string]string{}
+	if s.ChatHistory {
+		for _, name := range []string{"draft/chathistory", "batch", "server-time", "message-tags"} {
+			caps[name] = ""
+		}
+	}
+	for name, value := range s.Caps {
+		caps[name] = value
+	}
+	if _, ok := caps["sasl"]; !ok && !s.NoSASL {
+		var mechanisms []string
+		if len(s.Accounts) > 0 {
+			mechanisms = append(mechanisms, "PLAIN")
+		}
+		if s.ExternalAccount != "" || len(s.CertAccounts) > 0 {
+			mechanisms = append(mechanisms, "EXTERNAL")
+		}
+		if len(mechanisms) > 0 {
+			caps["sasl"] = strings.Join(mechanisms, ",")
+		}
+	}
+	return caps
+}
+
+func (c *conn) offeredCaps() []string {
+	var caps []string
+	for name, value := range c.server.capMap() {
+		if value != "" {
+			name += "=" + value
+		}
+		caps = append(caps, name)
+	}
+	return caps
+}
+
+func (c *conn) handleAuthenticate(e *girc.Event) {
+	if !c.caps["sasl"] || len(e.Params) == 0 {
+		c.numeric(girc.ERR_SASLFAIL, "SASL authentication failed")
+		return
+	}
+	s := c.server
+
+	if c.saslMechanism == "" {
+		mechanism := strings.ToUpper(e.Params[0])
+		if !strings.Contains(","+s.capMap()["sasl"]+",", ","+mechanism+",") {
+			c.numeric(girc.RPL_SASLMECHS, s.capMap()["sasl"], "are available SASL mechanisms")
+			c.numeric(girc.ERR_SASLFAIL, "SASL authentication failed")
+			return
+		}
+		c.saslMechanism = mechanism
+		c.reply(girc.AUTHENTICATE, "+")
+		return
+	}
+
+	mechanism := c.saslMechanism
+	c.saslMechanism = ""
+	switch mechanism {
+	case "PLAIN":
+		data, err := base64.StdEncoding.DecodeString(e.Params[0])
+		parts := strings.Split(string(data), "\x00")
+		if err != nil || len(parts) != 3 || s.Accounts[parts[1]] == "" || s.Accounts[parts[1]] != parts[2] {
+			c.numeric(girc.ERR_SASLFAIL, "SASL authentication failed")
+			return
+		}
+		c.loggedIn(parts[1])
+	case "EXTERNAL":
+		if account, ok := s.CertAccounts[c.certFingerprint()]; ok {
+			c.loggedIn(account)
+		} else if s.ExternalAccount != "" {
+			c.loggedIn(s.ExternalAccount)
+		} else {
+			c.numeric(girc.ERR_SASLFAIL, "SASL authentication failed")
+		}
+	}
+}
+
+/*
+certFingerprint returns the SHA-512 fingerprint of the client certificate
+sent over TLS, or an empty string if there is none
+*/
+func (c *conn) certFingerprint() string {
+	tlsConn, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	hash := sha512.Sum512(certs[0].Raw)
+	return hex.EncodeToString(hash[:])
+}
+
+func (c *conn) loggedIn(account string) {
+	c.account = account
+	c.numeric(girc.RPL_LOGGEDIN, c.user.source(), account, "You are now logged in as "+account)
+	c.numeric(girc.RPL_SASLSUCCESS, "SASL authentication successful")
+}
+
+/*
+handleRegistered handles commands only available after registration.
+Callers must hold the server's mu.
+*/
+func (c *conn) handleRegistered(e *girc.Event) {
+	s := c.server
+	source := girc.ParseSource(c.user.source())
+
+	switch e.Command {
+	case girc.JOIN:
+		if len(e.Params) == 0 {
+			c.numeric(girc.ERR_NEEDMOREPARAMS, girc.JOIN, "Not enough parameters")
+			return
+		}
+		names := strings.Split(e.Params[0], ",")
+		var keys []string
+		if len(e.Params) > 1 {
+			keys = strings.Split(e.Params[1], ",")
+		}
+		for i, name := range names {
+			ch := s.channel(name)
+			if ch.key != "" && (i >= len(keys) || keys[i] != ch.key) {
+				c.numeric(girc.ERR_BADCHANNELKEY, ch.name, "Cannot join channel (+k)")
+				continue
+			}
+			if ch.registeredOnly && c.account == "" {
+				c.numeric(errNeedReggedNick, ch.name, "Cannot join channel (+r) - you need to be identified with services")
+				continue
+			}
+			ch.members[strings.ToLower(c.user.nick)] = c.user
+			join := &girc.Event{Source: source, Command: girc.JOIN, Params: []string{ch.name}}
+			s.tag(join)
+			s.broadcast(ch, nil, join)
+			if ch.topic != "" {
+				c.numeric(girc.RPL_TOPIC, ch.name, ch.topic)
+			}
+			var nicks []string
+			for _, member := range ch.members {
+				nicks = append(nicks, member.nick)
+			}
+			c.numeric(girc.RPL_NAMREPLY, "=", ch.name, strings.Join(nicks, " "))
+			c.numeric(girc.RPL_ENDOFNAMES, ch.name, "End of /NAMES list")
+		}
+	case girc.PART:
+		if len(e.Params) == 0 {
+			return
+		}
+		for _, name := range strings.Split(e.Params[0], ",") {
+			ch := s.channel(name)
+			s.broadcast(ch, nil, &girc.Event{Source: source, Command: girc.PART, Params: e.Params})
+			delete(ch.members, strings.ToLower(c.user.nick))
+		}
+	case girc.PRIVMSG, girc.NOTICE:
+		if len(e.Params) < 2 {
+			return
+		}
+		if s.NickServ && e.Command == girc.PRIVMSG && strings.EqualFold(e.Params[0], nickServNick) {
+			c.handleNickServ(e.Last())
+			return
+		}
+		msg := &girc.Event{Source: source, Command: e.Command, Params: e.Params}
+		if girc.IsValidChannel(e.Params[0]) {
+			s.keep(s.channel(e.Params[0]), msg)
+			s.broadcast(s.channel(e.Params[0]), c, msg)
+		} else if to, ok := s.users[strings.ToLower(e.Params[0])]; ok && to.conn != nil {
+			to.conn.send(msg)
+		}
+	case girc.TOPIC:
+		if len(e.Params) == 0 {
+			return
+		}
+		ch := s.channel(e.Params[0])
+		if len(e.Params) == 1 {
+			if ch.topic == "" {
+				c.numeric(girc.RPL_NOTOPIC, ch.name, "No topic is set")
+			} else {
+				c.numeric(girc.RPL_TOPIC, ch.name, ch.topic)
+			}
+			return
+		}
+		ch.topic = e.Params[1]
+		s.broadcast(ch, nil, &girc.Event{Source: source, Command: girc.TOPIC, Params: e.Params})
+	case girc.KICK:
+		if len(e.Params) < 2 {
+			return
+		}
+		ch := s.channel(e.Params[0])
+		s.broadcast(ch, nil, &girc.Event{Source: source, Command: girc.KICK, Params: e.Params})
+		delete(ch.members, strings.ToLower(e.Params[1]))
+	case girc.MODE:
+		if len(e.Params) == 1 && girc.IsValidChannel(e.Params[0]) {
+			c.numeric(girc.RPL_CHANNELMODEIS, e.Params[0], "+nt")
+		}
+	case "CHATHISTORY":
+		c.handleChatHistory(e)
+	case girc.WHO:
+		if len(e.Params) > 0 {
+			c.numeric(girc.RPL_ENDOFWHO, e.Params[0], "End of /WHO list")
+		}
+	default:
+		c.numeric(girc.ERR_UNKNOWNCOMMAND, e.Command, fmt.Sprintf("Unknown command %q", e.Command))
+	}
+}
+
+/*
+handleNickServ handles a command sent to NickServ. Callers must hold the
+server's mu.
+*/
+func (c *conn) handleNickServ(text string) {
+	s := c.server
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		return
+	}
+	notice := func(msg string) {
+		c.send(&girc.Event{
+			Source:  &girc.Source{Name: nickServNick, Ident: "NickServ", Host: "services.fake"},
+			Command: girc.NOTICE,
+			Params:  []string{c.user.nick, msg},
+		})
+	}
+
+	command := strings.ToUpper(args[0])
+	if command == "REGAIN" && s.NoRegain {
+		command = ""
+	}
+	switch command {
+	case "IDENTIFY":
+		account, password := c.user.nick, ""
+		switch len(args) {
+		case 2:
+			password = args[1]
+		case 3:
+			account, password = args[1], args[2]
+		default:
+			notice("Syntax: IDENTIFY [account] <password>")
+			return
+		}
+		if s.Accounts[account] == "" || s.Accounts[account] != password {
+			notice("Invalid password for " + account + ".")
+			return
+		}
+		c.account = account
+		c.numeric(girc.RPL_LOGGEDIN, c.user.source(), account, "You are now logged in as "+account)
+		notice("You are now identified for " + account + ".")
+		c.send(&girc.Event{Source: &girc.Source{Name: c.user.nick}, Command: girc.MODE, Params: []string{c.user.nick, "+r"}})
+	case "GHOST", "REGAIN":
+		if len(args) < 2 {
+			notice("Syntax: " + strings.ToUpper(args[0]) + " <nick> [password]")
+			return
+		}
+		nick := args[1]
+		allowed := strings.EqualFold(c.account, nick) ||
+			(len(args) > 2 && s.Accounts[nick] != "" && s.Accounts[nick] == args[2])
+		if !allowed {
+			notice("You are not authorized to use " + strings.ToUpper(args[0]) + " on " + nick + ".")
+			return
+		}
+		u, ok := s.users[strings.ToLower(nick)]
+		if !ok || u == c.user {
+			notice(nick + " is not online.")
+			return
+		}
+		s.removeUser(u, "GHOST command used by "+c.user.nick)
+		if u.conn != nil {
+			u.conn.Close()
+		}
+		if strings.ToUpper(args[0]) == "REGAIN" {
+			s.renameUser(c.user, nick)
+			notice(nick + " has been regained.")
+		} else {
+			notice(nick + " has been ghosted.")
+		}
+	default:
+		notice("Unknown command " + strings.ToUpper(args[0]) + ".")
+	}
+}
+
+/*
+handleChatHistory answers CHATHISTORY LATEST and AFTER with a chathistory
+batch. References are "*", msgid=<id> or timestamp=<time>. Callers must
+hold the server's mu.
+*/
+func (c *conn) handleChatHistory(e *girc.Event) {
+	s := c.server
+	if !s.ChatHistory || len(e.Params) < 4 {
+		c.reply("FAIL", "CHATHISTORY", "NEED_MORE_PARAMS", "Missing parameters")
+		return
+	}
+	subcommand := strings.ToUpper(e.Params[0])
+	limit, err := strconv.Atoi(e.Params[3])
+	if (subcommand != "LATEST" && subcommand != "AFTER") || err != nil || limit < 1 ||
+		(subcommand == "AFTER" && e.Params[2] == "*") {
+		c.reply("FAIL", "CHATHISTORY", "INVALID_PARAMS", "Invalid parameters")
+		return
+	}
+	limit = min(limit, ChatHistoryLimit)
+	ch := s.channel(e.Params[1])
+
+	messages := ch.history[historyIndex(ch.history, e.Params[2]):]
+	if subcommand == "LATEST" {
+		messages = messages[max(0, len(messages)-limit):]
+	} else {
+		messages = messages[:min(limit, len(messages))]
+	}
+
+	s.lastBatch++
+	ref := fmt.Sprintf("history%d", s.lastBatch)
+	c.reply("BATCH", "+"+ref, "chathistory", ch.name)
+	for _, msg := range messages {
+		msg = msg.Copy()
+		msg.Tags["batch"] = ref
+		c.send(msg)
+	}
+	c.reply("BATCH", "-"+ref)
+}
+
+/*
+historyIndex returns the index of the first message in history after
+reference
+*/
+func historyIndex(history []*girc.Event, reference string) int {
+	if msgid, ok := strings.CutPrefix(reference, "msgid="); ok {
+		for i, msg := range history {
+			if msg.Tags["msgid"] == msgid {
+				return i + 1
+			}
+		}
+		return len(history)
+	}
+	if timestamp, ok := strings.CutPrefix(reference, "timestamp="); ok {
+		after, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return len(history)
+		}
+		for i, msg := range history {
+			if at, _ := time.Parse(time.RFC3339Nano, msg.Tags["time"]); at.After(after) {
+				return i
+			}
+		}
+		return len(history)
+	}
+	return 0
+}
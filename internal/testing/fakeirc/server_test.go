@@ -0,0 +1,293 @@
+package fakeirc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+connect starts a girc client against the server and returns it once it is
+registered. The returned channel receives the result of the connection.
+*/
+func connect(t *testing.T, s *Server, config girc.Config) (*girc.Client, chan error) {
+	config.Server = s.Host
+	config.Port = s.Port
+	if config.Nick == "" {
+		config.Nick = "bot"
+	}
+	config.User = "bot"
+	client := girc.New(config)
+	done := make(chan error, 1)
+	go func() { done <- client.Connect() }()
+	return client, done
+}
+
+func TestRegistration(t *testing.T) {
+	s, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+
+	connected := make(chan struct{})
+	client, done := connect(t, s, girc.Config{})
+	client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) { close(connected) })
+	defer client.Close()
+
+	select {
+	case <-connected:
+	case err := <-done:
+		assert.Fail(t, "connection failed", "%v", err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "client did not register")
+	}
+}
+
+func TestSASLPlain(t *testing.T) {
+	s, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	s.Accounts["bot"] = "hunter2"
+
+	client, _ := connect(t, s, girc.Config{SASL: &girc.SASLPlain{User: "bot", Pass: "hunter2"}})
+	defer client.Close()
+
+	_, ok := s.WaitFor(func(e girc.Event) bool {
+		return e.Command == girc.CAP && len(e.Params) > 0 && e.Params[0] == girc.CAP_END
+	}, 5*time.Second)
+	assert.True(t, ok, "client should finish CAP negotiation")
+	_, ok = s.WaitForCommand(girc.AUTHENTICATE, "PLAIN", time.Second)
+	assert.True(t, ok, "client should authenticate with PLAIN")
+}
+
+func TestSASLPlainWrongPassword(t *testing.T) {
+	s, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	s.Accounts["bot"] = "hunter2"
+
+	client, done := connect(t, s, girc.Config{SASL: &girc.SASLPlain{User: "bot", Pass: "wrong"}})
+	defer client.Close()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err, "client should give up after SASL failure")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "client kept the connection after SASL failure")
+	}
+}
+
+func TestSASLExternal(t *testing.T) {
+	s, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	s.ExternalAccount = "bot"
+
+	client, _ := connect(t, s, girc.Config{SASL: &girc.SASLExternal{}})
+	defer client.Close()
+
+	_, ok := s.WaitForCommand(girc.AUTHENTICATE, "EXTERNAL", 5*time.Second)
+	assert.True(t, ok, "client should authenticate with EXTERNAL")
+	_, ok = s.WaitFor(func(e girc.Event) bool {
+		return e.Command == girc.CAP && len(e.Params) > 0 && e.Params[0] == girc.CAP_END
+	}, 5*time.Second)
+	assert.True(t, ok, "client should finish CAP negotiation")
+}
+
+func TestNickInUse(t *testing.T) {
+	s, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	s.Join("bot", "#test")
+
+	client, _ := connect(t, s, girc.Config{})
+	defer client.Close()
+
+	_, ok := s.WaitForCommand(girc.NICK, "bot_", 5*time.Second)
+	assert.True(t, ok, "client should pick another nick")
+}
+
+func TestChannelTraffic(t *testing.T) {
+	s, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	s.SetKey("#test", "sekrit")
+
+	messages := make(chan girc.Event, 10)
+	client, _ := connect(t, s, girc.Config{})
+	client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) { c.Cmd.JoinKey("#test", "sekrit") })
+	for _, command := range []string{girc.PRIVMSG, girc.TOPIC, girc.KICK, girc.NICK, girc.QUIT} {
+		client.Handlers.Add(command, func(c *girc.Client, e girc.Event) { messages <- e })
+	}
+	defer client.Close()
+
+	_, ok := s.WaitForCommand(girc.JOIN, "#test", 5*time.Second)
+	if !assert.True(t, ok, "client should join") {
+		return
+	}
+	// Make sure the JOIN was handled before acting as other users
+	s.Join("alice", "#test")
+	client.Cmd.Message("#test", "hi")
+	s.WaitForCommand(girc.PRIVMSG, "#test", time.Second)
+
+	s.Say("alice", "#test", "hello")
+	s.SetTopic("alice", "#test", "new topic")
+	s.ChangeNick("alice", "alicia")
+	s.Kick("alicia", "#test", "mallory", "bye")
+	s.Quit("alicia", "gone")
+
+	expected := []struct{ command, last string }{
+		{girc.PRIVMSG, "hello"},
+		{girc.TOPIC, "new topic"},
+		{girc.NICK, "alicia"},
+		{girc.KICK, "bye"},
+		{girc.QUIT, "gone"},
+	}
+	for _, want := range expected {
+		select {
+		case e := <-messages:
+			assert.Equal(t, want.command, e.Command)
+			assert.Equal(t, want.last, e.Last())
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "missing event", want.command)
+		}
+	}
+}
+
+func TestNickServ(t *testing.T) {
+	s, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	s.NickServ = true
+	s.NoSASL = true
+	s.Accounts["bot"] = "hunter2"
+	s.SetRegisteredOnly("#registered")
+
+	events := make(chan girc.Event, 10)
+	client, _ := connect(t, s, girc.Config{})
+	client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) { c.Cmd.Join("#registered") })
+	for _, command := range []string{errNeedReggedNick, girc.NOTICE, girc.RPL_LOGGEDIN} {
+		client.Handlers.Add(command, func(c *girc.Client, e girc.Event) { events <- e })
+	}
+	defer client.Close()
+
+	next := func() *girc.Event {
+		select {
+		case e := <-events:
+			return &e
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "missing event")
+			return &girc.Event{}
+		}
+	}
+
+	assert.Equal(t, errNeedReggedNick, next().Command, "joining a +r channel should fail before identifying")
+
+	client.Cmd.Message("NickServ", "IDENTIFY wrong")
+	assert.Equal(t, "Invalid password for bot.", next().Last())
+
+	client.Cmd.Message("NickServ", "IDENTIFY hunter2")
+	assert.Equal(t, girc.RPL_LOGGEDIN, next().Command)
+	assert.Equal(t, "You are now identified for bot.", next().Last())
+
+	client.Cmd.Join("#registered")
+	_, ok := s.WaitFor(func(e girc.Event) bool {
+		return e.Command == girc.JOIN && len(e.Params) > 0 && e.Params[0] == "#registered"
+	}, time.Second)
+	assert.True(t, ok)
+}
+
+func TestStall(t *testing.T) {
+	s, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+
+	pongs := make(chan string, 2)
+	client, _ := connect(t, s, girc.Config{})
+	client.Handlers.Add(girc.PONG, func(c *girc.Client, e girc.Event) { pongs <- e.Last() })
+	defer client.Close()
+	_, ok := s.WaitFor(func(e girc.Event) bool { return e.Command == girc.USER }, 5*time.Second)
+	assert.True(t, ok, "client should register")
+
+	client.Cmd.Ping("before")
+	select {
+	case pong := <-pongs:
+		assert.Equal(t, "before", pong)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "server did not answer PING")
+	}
+
+	s.Stall()
+	client.Cmd.Ping("after")
+	_, ok = s.WaitFor(func(e girc.Event) bool { return e.Command == girc.PING && e.Last() == "after" }, 5*time.Second)
+	assert.True(t, ok, "server should still read from a stalled connection")
+	select {
+	case pong := <-pongs:
+		assert.Fail(t, "stalled server answered PING", pong)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestChatHistory(t *testing.T) {
+	s, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	s.ChatHistory = true
+	s.Join("alice", "#test")
+	s.Say("alice", "#test", "one") // fake-2, after the join
+	s.Say("alice", "#test", "two")
+	s.Say("alice", "#test", "three")
+
+	events := make(chan girc.Event, 10)
+	client, _ := connect(t, s, girc.Config{SupportedCaps: map[string][]string{"draft/chathistory": nil}})
+	client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
+		c.Cmd.SendRaw("CHATHISTORY AFTER #test msgid=fake-2 5")
+	})
+	for _, command := range []string{"BATCH", girc.PRIVMSG} {
+		client.Handlers.Add(command, func(c *girc.Client, e girc.Event) { events <- e })
+	}
+	defer client.Close()
+
+	var got []girc.Event
+	for len(got) < 4 {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "missing history", "got %v", got)
+			return
+		}
+	}
+	assert.Equal(t, "chathistory", got[0].Params[1])
+	ref := got[0].Params[0][1:]
+	for i, text := range []string{"two", "three"} {
+		assert.Equal(t, text, got[i+1].Last())
+		batch, _ := got[i+1].Tags.Get("batch")
+		assert.Equal(t, ref, batch)
+		msgid, _ := got[i+1].Tags.Get("msgid")
+		assert.NotEmpty(t, msgid)
+	}
+	assert.Equal(t, "-"+ref, got[3].Params[0])
+	assert.True(t, client.HasCapability("draft/chathistory"))
+	value, _ := client.GetServerOption("CHATHISTORY")
+	assert.Equal(t, "100", value)
+}
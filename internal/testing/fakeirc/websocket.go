@@ -0,0 +1,123 @@
+package fakeirc
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/ritlug/teleirc/internal/websocket"
+	xwebsocket "golang.org/x/net/websocket"
+)
+
+// WebSocketPath is the path WebSocket servers accept connections on
+const WebSocketPath = "/webirc"
+
+/*
+NewWebSocket starts a fake IRC server that accepts IRC over WebSocket on
+WebSocketPath, over TLS if tlsConfig is set. Clients connect to URL.
+*/
+func NewWebSocket(tlsConfig *tls.Config) *Server {
+	l := &webSocketListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(WebSocketPath, xwebsocket.Server{Handshake: chooseProtocol, Handler: l.serve})
+	l.server = httptest.NewUnstartedServer(mux)
+
+	scheme := "ws://"
+	if tlsConfig != nil {
+		l.server.TLS = tlsConfig
+		l.server.StartTLS()
+		scheme = "wss://"
+	} else {
+		l.server.Start()
+	}
+
+	s := NewWithListener(l)
+	s.URL = scheme + l.Addr().String() + WebSocketPath
+	return s
+}
+
+/*
+chooseProtocol picks the binary IRC subprotocol if the client offered it,
+then the text one
+*/
+func chooseProtocol(config *xwebsocket.Config, req *http.Request) error {
+	offered := config.Protocol
+	config.Protocol = nil
+	for _, protocol := range []string{websocket.BinaryProtocol, websocket.TextProtocol} {
+		for _, p := range offered {
+			if p == protocol {
+				config.Protocol = []string{protocol}
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+/*
+webSocketListener hands the server WebSocket connections as IRC line streams
+*/
+type webSocketListener struct {
+	server *httptest.Server
+	conns  chan net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+/*
+serve passes the connection to Accept and holds it open until it is closed,
+since returning ends the WebSocket connection
+*/
+func (l *webSocketListener) serve(ws *xwebsocket.Conn) {
+	c := &webSocketConn{Conn: websocket.NewConn(ws), done: make(chan struct{})}
+	select {
+	case l.conns <- c:
+	case <-l.closed:
+		return
+	}
+	select {
+	case <-c.done:
+	case <-l.closed:
+	}
+}
+
+func (l *webSocketListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *webSocketListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		l.server.CloseClientConnections()
+		l.server.Close()
+	})
+	return nil
+}
+
+func (l *webSocketListener) Addr() net.Addr {
+	return l.server.Listener.Addr()
+}
+
+/*
+webSocketConn signals done when the server closes it
+*/
+type webSocketConn struct {
+	net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (c *webSocketConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,329 @@
+/*
+Package fakeproxy provides SOCKS5 and HTTP proxies for tests. They listen
+on localhost, forward connections to their destination, and record where
+clients connected to.
+*/
+package fakeproxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+/*
+Server is a fake proxy server
+*/
+type Server struct {
+	// Username and Password are the credentials clients must send, if set
+	Username string
+	Password string
+
+	scheme    string
+	listener  net.Listener
+	transport *http.Transport
+
+	mu      sync.Mutex
+	targets []string
+	conns   map[net.Conn]struct{}
+	wg      sync.WaitGroup
+}
+
+/*
+NewSOCKS5 starts a SOCKS5 proxy listening on a random localhost port
+*/
+func NewSOCKS5() (*Server, error) {
+	return newServer("socks5")
+}
+
+/*
+NewHTTP starts an HTTP proxy listening on a random localhost port. It
+tunnels CONNECT requests and forwards plain HTTP requests.
+*/
+func NewHTTP() (*Server, error) {
+	return newServer("http")
+}
+
+func newServer(scheme string) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		scheme:    scheme,
+		listener:  l,
+		transport: &http.Transport{},
+		conns:     map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+/*
+URL returns the proxy URL clients should use, including the credentials
+*/
+func (s *Server) URL() string {
+	u := &url.URL{Scheme: s.scheme, Host: s.listener.Addr().String()}
+	if s.Username != "" {
+		u.User = url.UserPassword(s.Username, s.Password)
+	}
+	return u.String()
+}
+
+/*
+Targets returns the host:port destinations clients connected to through the
+proxy, in order
+*/
+func (s *Server) Targets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+/*
+Close stops the proxy and closes all connections through it
+*/
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.transport.CloseIdleConnections()
+	s.wg.Wait()
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.track(conn)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			if s.scheme == "socks5" {
+				s.serveSOCKS5(conn)
+			} else {
+				s.serveHTTP(conn)
+			}
+		}()
+	}
+}
+
+func (s *Server) track(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = struct{}{}
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	conn.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+func (s *Server) record(target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets = append(s.targets, target)
+}
+
+/*
+tunnel dials target and copies data both ways until either side closes
+*/
+func (s *Server) tunnel(client net.Conn, clientReader io.Reader, target net.Conn) {
+	s.track(target)
+	defer s.untrack(target)
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(target, clientReader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, target)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+/*
+serveSOCKS5 handles a SOCKS5 CONNECT, see RFC 1928 and RFC 1929
+*/
+func (s *Server) serveSOCKS5(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil || header[0] != 5 {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return
+	}
+	method := byte(0x00)
+	if s.Username != "" {
+		method = 0x02
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == method
+	}
+	if !offered {
+		conn.Write([]byte{5, 0xff})
+		return
+	}
+	conn.Write([]byte{5, method})
+
+	if method == 0x02 {
+		username, password, err := readSOCKSCredentials(reader)
+		if err != nil {
+			return
+		}
+		if username != s.Username || password != s.Password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil || request[1] != 1 {
+		conn.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1, 4:
+		ip := make([]byte, 4)
+		if request[3] == 4 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(reader, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	s.record(target)
+
+	targetConn, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	s.tunnel(conn, reader, targetConn)
+}
+
+func readSOCKSCredentials(reader *bufio.Reader) (string, string, error) {
+	readString := func() (string, error) {
+		length, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		b := make([]byte, length)
+		_, err = io.ReadFull(reader, b)
+		return string(b), err
+	}
+	version, err := reader.ReadByte()
+	if err != nil {
+		return "", "", err
+	}
+	if version != 1 {
+		return "", "", errors.New("unsupported authentication version")
+	}
+	username, err := readString()
+	if err != nil {
+		return "", "", err
+	}
+	password, err := readString()
+	return username, password, err
+}
+
+/*
+serveHTTP handles HTTP proxy requests: CONNECT tunnels and plain requests
+with absolute URLs
+*/
+func (s *Server) serveHTTP(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		if !s.authorized(req) {
+			resp := &http.Response{
+				StatusCode: http.StatusProxyAuthRequired,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{"Proxy-Authenticate": {`Basic realm="fakeproxy"`}},
+			}
+			resp.Write(conn)
+			return
+		}
+
+		if req.Method == http.MethodConnect {
+			s.record(req.Host)
+			targetConn, err := net.Dial("tcp", req.Host)
+			if err != nil {
+				conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+				return
+			}
+			conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+			s.tunnel(conn, reader, targetConn)
+			return
+		}
+
+		s.record(req.URL.Host)
+		req.RequestURI = ""
+		req.Header.Del("Proxy-Authorization")
+		resp, err := s.transport.RoundTrip(req)
+		if err != nil {
+			conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n"))
+			return
+		}
+		err = resp.Write(conn)
+		resp.Body.Close()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) authorized(req *http.Request) bool {
+	if s.Username == "" {
+		return true
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(s.Username + ":" + s.Password))
+	return req.Header.Get("Proxy-Authorization") == "Basic "+credentials
+}
@@ -0,0 +1,107 @@
+/*
+Package fakesystemd provides a notify socket for tests, which records what
+the service tells systemd
+*/
+package fakesystemd
+
+import (
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Socket is a fake systemd notify socket
+*/
+type Socket struct {
+	// Path is the value for NOTIFY_SOCKET
+	Path string
+
+	conn *net.UnixConn
+
+	mu       sync.Mutex
+	messages []string
+	received chan struct{}
+	done     chan struct{}
+}
+
+/*
+New listens on a notify socket in dir
+*/
+func New(dir string) (*Socket, error) {
+	path := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	s := &Socket{Path: path, conn: conn, received: make(chan struct{}), done: make(chan struct{})}
+	go s.read()
+	return s, nil
+}
+
+func (s *Socket) read() {
+	defer close(s.done)
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.messages = append(s.messages, string(buf[:n]))
+		close(s.received)
+		s.received = make(chan struct{})
+		s.mu.Unlock()
+	}
+}
+
+/*
+Messages returns the datagrams received so far
+*/
+func (s *Socket) Messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+/*
+States returns every state received so far, such as "READY=1", in order
+*/
+func (s *Socket) States() []string {
+	var states []string
+	for _, m := range s.Messages() {
+		states = append(states, strings.Split(m, "\n")...)
+	}
+	return states
+}
+
+/*
+WaitFor waits until the state was received, and returns whether it was
+*/
+func (s *Socket) WaitFor(state string, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		received := s.received
+		s.mu.Unlock()
+		if slices.Contains(s.States(), state) {
+			return true
+		}
+		select {
+		case <-received:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+/*
+Close stops listening
+*/
+func (s *Socket) Close() {
+	s.conn.Close()
+	<-s.done
+}
@@ -0,0 +1,27 @@
+package fakesystemd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocket(t *testing.T) {
+	s, err := New(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("unixgram", s.Path)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("READY=1\nSTATUS=up"))
+	require.NoError(t, err)
+
+	assert.True(t, s.WaitFor("STATUS=up", time.Second))
+	assert.False(t, s.WaitFor("WATCHDOG=1", 50*time.Millisecond))
+	assert.Equal(t, []string{"READY=1\nSTATUS=up"}, s.Messages())
+	assert.Equal(t, []string{"READY=1", "STATUS=up"}, s.States())
+}
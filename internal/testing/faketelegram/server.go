@@ -0,0 +1,461 @@
+/*
+Package faketelegram provides an in-process fake of the Telegram Bot API,
+so tests can run the real bot client against it instead of calling
+handlers directly.
+*/
+package faketelegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// maxPollWait caps how long a getUpdates request waits for new updates
+const maxPollWait = 5 * time.Second
+
+/*
+Call is a request the bot made to the fake server
+*/
+type Call struct {
+	Method string
+	Params map[string]string
+}
+
+/*
+Error is an error response the fake server returns instead of handling a call
+*/
+type Error struct {
+	Code            int
+	Description     string
+	RetryAfter      int
+	MigrateToChatID int64
+}
+
+/*
+TooManyRequests returns the error Telegram sends when the bot is rate limited
+*/
+func TooManyRequests(retryAfter int) Error {
+	return Error{
+		Code:        http.StatusTooManyRequests,
+		Description: "Too Many Requests: retry after " + strconv.Itoa(retryAfter),
+		RetryAfter:  retryAfter,
+	}
+}
+
+/*
+Forbidden returns the error Telegram sends when the bot was removed from a chat
+*/
+func Forbidden() Error {
+	return Error{
+		Code:        http.StatusForbidden,
+		Description: "Forbidden: bot was kicked from the group chat",
+	}
+}
+
+/*
+MigrateToChat returns the error Telegram sends when a group was upgraded to a
+supergroup with the given chat ID
+*/
+func MigrateToChat(chatID int64) Error {
+	return Error{
+		Code:            http.StatusBadRequest,
+		Description:     "Bad Request: group chat was upgraded to a supergroup chat",
+		MigrateToChatID: chatID,
+	}
+}
+
+/*
+Server is a fake Telegram Bot API server. Point the bot client at URL to use it.
+*/
+type Server struct {
+	*httptest.Server
+	Token string
+	Me    models.User
+
+	mu            sync.Mutex
+	updates       []*models.Update
+	nextUpdateID  int64
+	nextMessageID int
+	calls         []Call
+	failures      map[string][]Error
+	files         map[string]models.File
+	chats         map[int64]chat
+	members       map[[2]int64]models.ChatMemberType
+	webhookURL    string
+	webhookSecret string
+	// changed is closed and replaced whenever an update or a call is added
+	changed chan struct{}
+}
+
+/*
+New starts a fake Bot API server for the bot with the given token
+*/
+func New(token string) *Server {
+	s := &Server{
+		Token:         token,
+		Me:            models.User{ID: 1, IsBot: true, FirstName: "TeleIRC", Username: "teleirc_bot"},
+		nextUpdateID:  1,
+		nextMessageID: 1,
+		failures:      map[string][]Error{},
+		files:         map[string]models.File{},
+		chats:         map[int64]chat{},
+		members:       map[[2]int64]models.ChatMemberType{},
+		changed:       make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+/*
+notify wakes up everyone waiting for updates or calls. Callers must hold mu.
+*/
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+/*
+AddUpdate queues an update for the next getUpdates request. The update ID
+is assigned by the server and returned.
+*/
+func (s *Server) AddUpdate(u models.Update) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.ID = s.nextUpdateID
+	s.nextUpdateID++
+	s.updates = append(s.updates, &u)
+	s.notify()
+	return u.ID
+}
+
+/*
+AddMessage queues an update with a new text message sent by from to chatID
+*/
+func (s *Server) AddMessage(chatID int64, from *models.User, text string) int64 {
+	return s.AddUpdate(models.Update{Message: &models.Message{
+		ID:   s.newMessageID(),
+		From: from,
+		Chat: models.Chat{ID: chatID, Type: models.ChatTypeSupergroup},
+		Date: int(time.Now().Unix()),
+		Text: text,
+	}})
+}
+
+/*
+AddFile makes a file available through getFile
+*/
+func (s *Server) AddFile(f models.File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[f.FileID] = f
+}
+
+/*
+chat is a chat known to getChat, with the bot's membership status in it
+*/
+type chat struct {
+	info   models.ChatFullInfo
+	status models.ChatMemberType
+}
+
+/*
+AddChat makes a chat available through getChat, and getChatMember answer
+with status for the bot
+*/
+func (s *Server) AddChat(info models.ChatFullInfo, status models.ChatMemberType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chats[info.ID] = chat{info: info, status: status}
+}
+
+/*
+AddMember makes getChatMember answer with status for the user in chatID,
+which must be added with AddChat. Other users have left the chat.
+*/
+func (s *Server) AddMember(chatID, userID int64, status models.ChatMemberType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.members[[2]int64{chatID, userID}] = status
+}
+
+/*
+FailNext makes the next call to method return err instead of being handled.
+Failures queue up, so calling it twice fails the next two calls.
+*/
+func (s *Server) FailNext(method string, err Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], err)
+}
+
+/*
+Calls returns every call the bot made so far, except for getUpdates
+*/
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+/*
+CallsTo returns every call the bot made to method so far
+*/
+func (s *Server) CallsTo(method string) []Call {
+	var calls []Call
+	for _, call := range s.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+/*
+WaitForCalls waits until the bot made n calls to method and returns them.
+If the timeout passes first, the calls made so far are returned.
+*/
+func (s *Server) WaitForCalls(method string, n int, timeout time.Duration) []Call {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		s.mu.Unlock()
+
+		calls := s.CallsTo(method)
+		if len(calls) >= n {
+			return calls
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return calls
+		}
+	}
+}
+
+/*
+Webhook returns the webhook URL and secret token set by the bot, if any
+*/
+func (s *Server) Webhook() (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.webhookURL, s.webhookSecret
+}
+
+/*
+DeliverWebhook posts an update to the webhook the bot registered, the way
+Telegram does, and returns the HTTP status code of the response
+*/
+func (s *Server) DeliverWebhook(u models.Update) (int, error) {
+	url, secret := s.Webhook()
+	s.mu.Lock()
+	u.ID = s.nextUpdateID
+	s.nextUpdateID++
+	s.mu.Unlock()
+
+	body, err := json.Marshal(u)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (s *Server) newMessageID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextMessageID
+	s.nextMessageID++
+	return id
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+s.Token+"/")
+	if !ok {
+		writeError(w, Error{Code: http.StatusUnauthorized, Description: "Unauthorized"})
+		return
+	}
+
+	params := map[string]string{}
+	// Requests without parameters have an empty body, which is fine
+	if err := r.ParseMultipartForm(1 << 20); err == nil {
+		for key, values := range r.MultipartForm.Value {
+			params[key] = values[0]
+		}
+	}
+
+	if method == "getUpdates" {
+		s.getUpdates(w, params)
+		return
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, Call{Method: method, Params: params})
+	s.notify()
+	if failures := s.failures[method]; len(failures) > 0 {
+		s.failures[method] = failures[1:]
+		s.mu.Unlock()
+		writeError(w, failures[0])
+		return
+	}
+	s.mu.Unlock()
+
+	switch method {
+	case "getMe":
+		writeResult(w, s.Me)
+	case "sendMessage":
+		chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+		writeResult(w, models.Message{
+			ID:   s.newMessageID(),
+			From: &s.Me,
+			Chat: models.Chat{ID: chatID},
+			Date: int(time.Now().Unix()),
+			Text: params["text"],
+		})
+	case "editMessageText":
+		chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+		messageID, _ := strconv.Atoi(params["message_id"])
+		writeResult(w, models.Message{
+			ID:       messageID,
+			From:     &s.Me,
+			Chat:     models.Chat{ID: chatID},
+			Date:     int(time.Now().Unix()),
+			EditDate: int(time.Now().Unix()),
+			Text:     params["text"],
+		})
+	case "getFile":
+		s.mu.Lock()
+		f, ok := s.files[params["file_id"]]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, Error{Code: http.StatusBadRequest, Description: "Bad Request: invalid file_id"})
+			return
+		}
+		writeResult(w, f)
+	case "getChat", "getChatMember":
+		chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+		s.mu.Lock()
+		c, ok := s.chats[chatID]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, Error{Code: http.StatusBadRequest, Description: "Bad Request: chat not found"})
+			return
+		}
+		if method == "getChat" {
+			writeResult(w, c.info)
+			return
+		}
+		userID, _ := strconv.ParseInt(params["user_id"], 10, 64)
+		if userID == s.Me.ID {
+			// ChatMember has no JSON encoding of its own
+			writeResult(w, map[string]any{"status": c.status, "user": s.Me})
+			return
+		}
+		s.mu.Lock()
+		status, ok := s.members[[2]int64{chatID, userID}]
+		s.mu.Unlock()
+		if !ok {
+			status = models.ChatMemberTypeLeft
+		}
+		writeResult(w, map[string]any{"status": status, "user": models.User{ID: userID}})
+	case "setWebhook":
+		s.mu.Lock()
+		s.webhookURL = params["url"]
+		s.webhookSecret = params["secret_token"]
+		s.mu.Unlock()
+		writeResult(w, true)
+	case "deleteWebhook":
+		s.mu.Lock()
+		s.webhookURL = ""
+		s.webhookSecret = ""
+		s.mu.Unlock()
+		writeResult(w, true)
+	default:
+		writeError(w, Error{Code: http.StatusNotFound, Description: "Not Found: method not found"})
+	}
+}
+
+/*
+getUpdates answers a long polling request, waiting for updates newer than
+the requested offset until the requested timeout passes
+*/
+func (s *Server) getUpdates(w http.ResponseWriter, params map[string]string) {
+	offset, _ := strconv.ParseInt(params["offset"], 10, 64)
+	timeout, _ := strconv.Atoi(params["timeout"])
+	wait := min(time.Duration(timeout)*time.Second, maxPollWait)
+	deadline := time.After(wait)
+
+	for {
+		s.mu.Lock()
+		// Updates before the offset were confirmed by the bot
+		for len(s.updates) > 0 && s.updates[0].ID < offset {
+			s.updates = s.updates[1:]
+		}
+		updates := append([]*models.Update{}, s.updates...)
+		changed := s.changed
+		if failures := s.failures["getUpdates"]; len(failures) > 0 {
+			s.failures["getUpdates"] = failures[1:]
+			s.mu.Unlock()
+			writeError(w, failures[0])
+			return
+		}
+		s.mu.Unlock()
+
+		if len(updates) > 0 {
+			writeResult(w, updates)
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			writeResult(w, updates)
+			return
+		}
+	}
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func writeError(w http.ResponseWriter, e Error) {
+	resp := map[string]any{
+		"ok":          false,
+		"error_code":  e.Code,
+		"description": e.Description,
+	}
+	parameters := map[string]any{}
+	if e.RetryAfter != 0 {
+		parameters["retry_after"] = e.RetryAfter
+	}
+	if e.MigrateToChatID != 0 {
+		parameters["migrate_to_chat_id"] = e.MigrateToChatID
+	}
+	if len(parameters) > 0 {
+		resp["parameters"] = parameters
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,89 @@
+package faketelegram
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram/bot"
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "000000000:AAAAAAaAAa2AaAAaoAAAA-a_aaAAaAaaaAA"
+
+func newBot(t *testing.T, s *Server, token string) *tgbotapi.Bot {
+	b, err := tgbotapi.New(token, tgbotapi.WithServerURL(s.URL), tgbotapi.WithSkipGetMe())
+	assert.NoError(t, err)
+	return b
+}
+
+func TestGetMe(t *testing.T) {
+	s := New(testToken)
+	defer s.Close()
+
+	me, err := newBot(t, s, testToken).GetMe(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, s.Me.Username, me.Username)
+	assert.Len(t, s.CallsTo("getMe"), 1)
+}
+
+func TestWrongToken(t *testing.T) {
+	s := New(testToken)
+	defer s.Close()
+
+	_, err := newBot(t, s, "1:wrong").GetMe(context.Background())
+	assert.True(t, errors.Is(err, tgbotapi.ErrorUnauthorized))
+}
+
+func TestFailNext(t *testing.T) {
+	s := New(testToken)
+	defer s.Close()
+	b := newBot(t, s, testToken)
+	s.FailNext("sendMessage", TooManyRequests(3))
+	s.FailNext("sendMessage", MigrateToChat(-1000000000100))
+	s.FailNext("sendMessage", Forbidden())
+	params := &tgbotapi.SendMessageParams{ChatID: -100, Text: "hi"}
+
+	_, err := b.SendMessage(context.Background(), params)
+	var tooMany *tgbotapi.TooManyRequestsError
+	if assert.True(t, errors.As(err, &tooMany)) {
+		assert.Equal(t, 3, tooMany.RetryAfter)
+	}
+
+	_, err = b.SendMessage(context.Background(), params)
+	var migrate *tgbotapi.MigrateError
+	if assert.True(t, errors.As(err, &migrate)) {
+		assert.Equal(t, -1000000000100, migrate.MigrateToChatID)
+	}
+
+	_, err = b.SendMessage(context.Background(), params)
+	assert.True(t, errors.Is(err, tgbotapi.ErrorForbidden))
+
+	msg, err := b.SendMessage(context.Background(), params)
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", msg.Text)
+	assert.Len(t, s.CallsTo("sendMessage"), 4)
+}
+
+func TestEditMessageText(t *testing.T) {
+	s := New(testToken)
+	defer s.Close()
+
+	msg, err := newBot(t, s, testToken).EditMessageText(context.Background(), &tgbotapi.EditMessageTextParams{
+		ChatID:    -100,
+		MessageID: 7,
+		Text:      "edited",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, msg.ID)
+	assert.Equal(t, "edited", msg.Text)
+}
+
+func TestWaitForCallsTimeout(t *testing.T) {
+	s := New(testToken)
+	defer s.Close()
+
+	calls := s.WaitForCalls("sendMessage", 1, 10*time.Millisecond)
+	assert.Empty(t, calls)
+}
@@ -0,0 +1,207 @@
+/*
+Package websocket carries IRC over WebSocket connections, as described by the
+IRCv3 WebSocket specification: every IRC line travels in its own frame,
+without the trailing CRLF.
+*/
+package websocket
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ritlug/teleirc/internal/proxy"
+	xwebsocket "golang.org/x/net/websocket"
+)
+
+const (
+	// BinaryProtocol is the subprotocol that sends lines in binary frames
+	BinaryProtocol = "binary.ircv3.net"
+	// TextProtocol is the subprotocol that sends lines in UTF-8 text frames
+	TextProtocol = "text.ircv3.net"
+)
+
+// handshakeTimeout bounds the TLS and WebSocket handshakes
+const handshakeTimeout = 10 * time.Second
+
+/*
+IsURL returns whether the server setting is a ws:// or wss:// URL
+*/
+func IsURL(server string) bool {
+	return strings.HasPrefix(server, "ws://") || strings.HasPrefix(server, "wss://")
+}
+
+/*
+ParseURL parses a ws:// or wss:// server URL
+*/
+func ParseURL(server string) (*url.URL, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("unsupported WebSocket scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("WebSocket URL %q has no host", server)
+	}
+	return u, nil
+}
+
+/*
+Port returns the port of a WebSocket URL, defaulting to 80 for ws:// and
+443 for wss://
+*/
+func Port(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Port()
+	}
+	if u.Scheme == "wss" {
+		return "443"
+	}
+	return "80"
+}
+
+/*
+Dialer connects to an IRC server over WebSocket. It matches the girc.Dialer
+interface.
+*/
+type Dialer struct {
+	url       *url.URL
+	tlsConfig *tls.Config
+	forward   proxy.Dialer
+}
+
+/*
+NewDialer returns a dialer for the ws:// or wss:// server URL. forward makes
+the underlying connection, and tlsConfig secures it for wss:// URLs.
+*/
+func NewDialer(server string, tlsConfig *tls.Config, forward proxy.Dialer) (*Dialer, error) {
+	u, err := ParseURL(server)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: u.Hostname()}
+	}
+	return &Dialer{url: u, tlsConfig: tlsConfig, forward: forward}, nil
+}
+
+/*
+Dial connects to the dialer's URL. The address girc passes in is ignored,
+since the URL also holds the path of the WebSocket endpoint.
+*/
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, net.JoinHostPort(d.url.Hostname(), Port(d.url)))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	origin := "http://" + d.url.Host
+	if d.url.Scheme == "wss" {
+		tlsConn := tls.Client(conn, d.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+		origin = "https://" + d.url.Host
+	}
+
+	config, err := xwebsocket.NewConfig(d.url.String(), origin)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	config.Protocol = []string{BinaryProtocol, TextProtocol}
+	ws, err := xwebsocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("WebSocket handshake with %s failed: %w", d.url.Redacted(), err)
+	}
+	conn.SetDeadline(time.Time{})
+	return NewConn(ws), nil
+}
+
+/*
+NewConn wraps a WebSocket connection as a stream of CRLF terminated IRC
+lines. Lines are sent in binary frames if the binary subprotocol was
+negotiated, and in text frames otherwise.
+*/
+func NewConn(ws *xwebsocket.Conn) net.Conn {
+	protocols := ws.Config().Protocol
+	return &conn{Conn: ws, ws: ws, binary: len(protocols) == 1 && protocols[0] == BinaryProtocol}
+}
+
+/*
+conn is a net.Conn that frames IRC lines as WebSocket messages
+*/
+type conn struct {
+	net.Conn
+	ws     *xwebsocket.Conn
+	binary bool
+
+	readM   sync.Mutex
+	pending []byte
+
+	writeM  sync.Mutex
+	partial []byte
+}
+
+/*
+Read returns the lines received so far, each ending in CRLF
+*/
+func (c *conn) Read(b []byte) (int, error) {
+	c.readM.Lock()
+	defer c.readM.Unlock()
+	for len(c.pending) == 0 {
+		var msg []byte
+		if err := xwebsocket.Message.Receive(c.ws, &msg); err != nil {
+			return 0, err
+		}
+		msg = bytes.TrimRight(msg, "\r\n")
+		if len(msg) > 0 {
+			c.pending = append(msg, '\r', '\n')
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+/*
+Write sends every complete line in b as its own message, keeping any
+unterminated line until the rest of it is written
+*/
+func (c *conn) Write(b []byte) (int, error) {
+	c.writeM.Lock()
+	defer c.writeM.Unlock()
+	c.partial = append(c.partial, b...)
+	for {
+		end := bytes.IndexByte(c.partial, '\n')
+		if end < 0 {
+			return len(b), nil
+		}
+		line := bytes.TrimRight(c.partial[:end], "\r")
+		c.partial = c.partial[end+1:]
+		if len(line) == 0 {
+			continue
+		}
+		var err error
+		if c.binary {
+			err = xwebsocket.Message.Send(c.ws, line)
+		} else {
+			// Text frames must hold valid UTF-8
+			err = xwebsocket.Message.Send(c.ws, strings.ToValidUTF8(string(line), "\uFFFD"))
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
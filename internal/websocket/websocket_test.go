@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xwebsocket "golang.org/x/net/websocket"
+)
+
+type frame struct {
+	binary bool
+	data   string
+}
+
+// frameCodec receives a frame along with its type
+var frameCodec = xwebsocket.Codec{
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		*v.(*frame) = frame{binary: payloadType == xwebsocket.BinaryFrame, data: string(data)}
+		return nil
+	},
+}
+
+/*
+startEchoServer starts a WebSocket server on /irc that negotiates protocol,
+if set, and sends every frame it receives back to the client in the same
+kind of frame. Received frames are passed to frames.
+*/
+func startEchoServer(t *testing.T, protocol string) (string, chan frame) {
+	t.Helper()
+	frames := make(chan frame, 10)
+	mux := http.NewServeMux()
+	mux.Handle("/irc", xwebsocket.Server{
+		Handshake: func(config *xwebsocket.Config, req *http.Request) error {
+			config.Protocol = nil
+			if protocol != "" {
+				config.Protocol = []string{protocol}
+			}
+			return nil
+		},
+		Handler: func(ws *xwebsocket.Conn) {
+			ws.Write([]byte("first line"))
+			for {
+				var f frame
+				if err := frameCodec.Receive(ws, &f); err != nil {
+					return
+				}
+				frames <- f
+				if f.binary {
+					xwebsocket.Message.Send(ws, []byte(f.data))
+				} else {
+					xwebsocket.Message.Send(ws, f.data)
+				}
+			}
+		},
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return "ws://" + server.Listener.Addr().String() + "/irc", frames
+}
+
+func dial(t *testing.T, server string) net.Conn {
+	t.Helper()
+	dialer, err := NewDialer(server, nil, &net.Dialer{Timeout: 5 * time.Second})
+	require.NoError(t, err)
+	conn, err := dialer.Dial("tcp", "ignored:6667")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestIsURL(t *testing.T) {
+	assert.True(t, IsURL("ws://irc.example.com/webirc"))
+	assert.True(t, IsURL("wss://irc.example.com"))
+	assert.False(t, IsURL("irc.example.com"))
+	assert.False(t, IsURL("https://irc.example.com"))
+
+	_, err := ParseURL("wss://")
+	assert.Error(t, err)
+	_, err = ParseURL("https://irc.example.com")
+	assert.Error(t, err)
+
+	u, err := ParseURL("wss://irc.example.com/webirc")
+	require.NoError(t, err)
+	assert.Equal(t, "443", Port(u))
+	u, err = ParseURL("ws://irc.example.com:8097")
+	require.NoError(t, err)
+	assert.Equal(t, "8097", Port(u))
+}
+
+func TestFraming(t *testing.T) {
+	for _, protocol := range []string{BinaryProtocol, TextProtocol, ""} {
+		t.Run(protocol, func(t *testing.T) {
+			server, frames := startEchoServer(t, protocol)
+			conn := dial(t, server)
+			reader := bufio.NewReader(conn)
+
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, "first line\r\n", line, "lines should be terminated with CRLF")
+
+			// Two lines in one write, then a line split across writes
+			_, err = conn.Write([]byte("NICK teleirc\r\nUSER teleirc 0 * :TeleIRC\r\nPRIV"))
+			require.NoError(t, err)
+			_, err = conn.Write([]byte("MSG #bridge :hi\r\n"))
+			require.NoError(t, err)
+
+			binary := protocol == BinaryProtocol
+			for _, expected := range []string{"NICK teleirc", "USER teleirc 0 * :TeleIRC", "PRIVMSG #bridge :hi"} {
+				select {
+				case f := <-frames:
+					assert.Equal(t, frame{binary: binary, data: expected}, f)
+				case <-time.After(5 * time.Second):
+					require.Fail(t, "frame not received", expected)
+				}
+				line, err := reader.ReadString('\n')
+				require.NoError(t, err)
+				assert.Equal(t, expected+"\r\n", line)
+			}
+		})
+	}
+}
+
+func TestTextFramesAreUTF8(t *testing.T) {
+	server, frames := startEchoServer(t, TextProtocol)
+	conn := dial(t, server)
+	_, err := conn.Write([]byte("PRIVMSG #bridge :caf\xe9\r\n"))
+	require.NoError(t, err)
+	select {
+	case f := <-frames:
+		assert.Equal(t, "PRIVMSG #bridge :caf�", f.data)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "frame not received")
+	}
+}
+
+func TestHandshakeFailure(t *testing.T) {
+	server, _ := startEchoServer(t, "")
+	dialer, err := NewDialer(strings.Replace(server, "/irc", "/missing", 1), nil, &net.Dialer{})
+	require.NoError(t, err)
+	_, err = dialer.Dial("tcp", "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "WebSocket handshake")
+	}
+}